@@ -0,0 +1,235 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBanned                = errors.New("Your IP address is banned from this server.")
+	ErrRateLimited           = errors.New("Too many connection attempts, please try again later.")
+	ErrTooManySessions       = errors.New("The server is full, please try again later.")
+	ErrTooManySessionsFromIp = errors.New("Too many viewers connected from your IP address.")
+	ErrLockedOut             = errors.New("Too many failed login attempts, please try again later.")
+)
+
+// a zero value on any of the limits disables it
+type Limits struct {
+	ConnectionsPerMinute int
+	MaxSessionsPerIp     int
+	MaxSessions          int
+	LoginFailureLimit    int
+	LoginFailureLockout  time.Duration
+}
+
+type connectionBucket struct {
+	tokens     float64
+	lastUpdate time.Time
+}
+
+type loginFailures struct {
+	count       int
+	firstFailed time.Time
+	lockedUntil time.Time
+}
+
+type Controller struct {
+	mutex        sync.Mutex
+	limits       Limits
+	banList      *BanList
+	buckets      map[string]*connectionBucket
+	failures     map[string]*loginFailures
+	sessions     map[string]int
+	sessionCount int
+	lastSweep    time.Time
+	now          func() time.Time
+}
+
+const (
+	// idle entries are removed from the rate limiter and lockout maps after this period
+	SWEEP_INTERVAL = time.Minute
+)
+
+func NewController(limits Limits, banList *BanList) *Controller {
+	if banList == nil {
+		banList = NewBanList("")
+	}
+
+	return &Controller{
+		limits:   limits,
+		banList:  banList,
+		buckets:  make(map[string]*connectionBucket),
+		failures: make(map[string]*loginFailures),
+		sessions: make(map[string]int),
+		now:      time.Now,
+	}
+}
+
+func (c *Controller) BanList() *BanList {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.banList
+}
+
+// SetBanList replaces the ban list checked by AllowConnection, nil is an empty ban list
+func (c *Controller) SetBanList(banList *BanList) {
+	if banList == nil {
+		banList = NewBanList("")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.banList = banList
+}
+
+// SetLimits replaces the limits, the sessions already acquired count towards the new ones
+func (c *Controller) SetLimits(limits Limits) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.limits = limits
+}
+
+// AllowConnection checks the ban list, the lockout state and the connection rate for a newly accepted connection
+func (c *Controller) AllowConnection(ip net.IP) error {
+	if c.BanList().Contains(ip) {
+		return ErrBanned
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+
+	key := ip.String()
+
+	if failures, ok := c.failures[key]; ok && now.Before(failures.lockedUntil) {
+		return ErrLockedOut
+	}
+
+	if c.limits.ConnectionsPerMinute <= 0 {
+		return nil
+	}
+
+	// token bucket: allows a burst of ConnectionsPerMinute connections, refilled continuously along the minute
+	burst := float64(c.limits.ConnectionsPerMinute)
+	bucket, ok := c.buckets[key]
+	if !ok {
+		bucket = &connectionBucket{tokens: burst, lastUpdate: now}
+		c.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.lastUpdate).Minutes() * burst
+	if bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.lastUpdate = now
+
+	if bucket.tokens < 1 {
+		return ErrRateLimited
+	}
+	bucket.tokens -= 1
+
+	return nil
+}
+
+// AcquireSession reserves a session slot for the given ip, the returned function releases it and is safe to call more than once
+func (c *Controller) AcquireSession(ip net.IP) (func(), error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := ip.String()
+
+	if c.limits.MaxSessions > 0 && c.sessionCount >= c.limits.MaxSessions {
+		return nil, ErrTooManySessions
+	}
+
+	if c.limits.MaxSessionsPerIp > 0 && c.sessions[key] >= c.limits.MaxSessionsPerIp {
+		return nil, ErrTooManySessionsFromIp
+	}
+
+	c.sessions[key]++
+	c.sessionCount++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+
+			c.sessionCount--
+			c.sessions[key]--
+			if c.sessions[key] <= 0 {
+				delete(c.sessions, key)
+			}
+		})
+	}
+
+	return release, nil
+}
+
+// LoginFailed records a failed login and locks the ip out once LoginFailureLimit failures happen within LoginFailureLockout
+func (c *Controller) LoginFailed(ip net.IP) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.limits.LoginFailureLimit <= 0 {
+		return
+	}
+
+	now := c.now()
+	key := ip.String()
+
+	failures, ok := c.failures[key]
+	if !ok || now.Sub(failures.firstFailed) > c.limits.LoginFailureLockout {
+		failures = &loginFailures{firstFailed: now}
+		c.failures[key] = failures
+	}
+
+	failures.count++
+	if failures.count >= c.limits.LoginFailureLimit {
+		failures.lockedUntil = now.Add(c.limits.LoginFailureLockout)
+		failures.count = 0
+		failures.firstFailed = now
+		fmt.Printf("[access] - %s locked out for %s after too many failed logins\n", key, c.limits.LoginFailureLockout)
+	}
+}
+
+func (c *Controller) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < SWEEP_INTERVAL {
+		return
+	}
+	c.lastSweep = now
+
+	for key, bucket := range c.buckets {
+		if now.Sub(bucket.lastUpdate) > SWEEP_INTERVAL {
+			delete(c.buckets, key)
+		}
+	}
+
+	for key, failures := range c.failures {
+		if now.After(failures.lockedUntil) && now.Sub(failures.firstFailed) > c.limits.LoginFailureLockout {
+			delete(c.failures, key)
+		}
+	}
+}
+
+func RemoteIp(conn net.Conn) (net.IP, error) {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil, fmt.Errorf("[RemoteIp] - error splitting remote address: %w", err)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("[RemoteIp] - invalid remote address %s", host)
+	}
+
+	return ip, nil
+}
@@ -0,0 +1,148 @@
+package access
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	current time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.current
+}
+
+func newTestController(limits Limits, banList *BanList) (*Controller, *fakeClock) {
+	clock := &fakeClock{current: time.Date(2024, 10, 25, 18, 0, 0, 0, time.UTC)}
+	controller := NewController(limits, banList)
+	controller.now = clock.Now
+	return controller, clock
+}
+
+func TestAllowConnectionRateLimit(t *testing.T) {
+	controller, clock := newTestController(Limits{ConnectionsPerMinute: 3}, nil)
+	ip := net.ParseIP("10.0.0.1")
+
+	for i := 0; i < 3; i++ {
+		if err := controller.AllowConnection(ip); err != nil {
+			t.Fatalf("expected connection %d to be allowed, got %v", i, err)
+		}
+	}
+
+	if err := controller.AllowConnection(ip); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+
+	if err := controller.AllowConnection(net.ParseIP("10.0.0.2")); err != nil {
+		t.Errorf("expected other ip to be allowed, got %v", err)
+	}
+
+	// 20 seconds refill one connection when the limit is 3 per minute
+	clock.current = clock.current.Add(20 * time.Second)
+	if err := controller.AllowConnection(ip); err != nil {
+		t.Errorf("expected connection to be allowed after refill, got %v", err)
+	}
+}
+
+func TestAcquireSessionLimits(t *testing.T) {
+	controller, _ := newTestController(Limits{MaxSessions: 3, MaxSessionsPerIp: 2}, nil)
+	first := net.ParseIP("10.0.0.1")
+	second := net.ParseIP("10.0.0.2")
+
+	releaseA, err := controller.AcquireSession(first)
+	if err != nil {
+		t.Fatalf("expected first session to be allowed, got %v", err)
+	}
+	if _, err := controller.AcquireSession(first); err != nil {
+		t.Fatalf("expected second session to be allowed, got %v", err)
+	}
+	if _, err := controller.AcquireSession(first); !errors.Is(err, ErrTooManySessionsFromIp) {
+		t.Errorf("expected ErrTooManySessionsFromIp, got %v", err)
+	}
+	if _, err := controller.AcquireSession(second); err != nil {
+		t.Fatalf("expected session from other ip to be allowed, got %v", err)
+	}
+	if _, err := controller.AcquireSession(net.ParseIP("10.0.0.3")); !errors.Is(err, ErrTooManySessions) {
+		t.Errorf("expected ErrTooManySessions, got %v", err)
+	}
+
+	releaseA()
+	releaseA() // releasing twice must not free two slots
+
+	if _, err := controller.AcquireSession(first); err != nil {
+		t.Errorf("expected session to be allowed after release, got %v", err)
+	}
+	if _, err := controller.AcquireSession(second); !errors.Is(err, ErrTooManySessions) {
+		t.Errorf("expected ErrTooManySessions, got %v", err)
+	}
+}
+
+func TestLoginFailureLockout(t *testing.T) {
+	controller, clock := newTestController(Limits{LoginFailureLimit: 3, LoginFailureLockout: time.Minute}, nil)
+	ip := net.ParseIP("10.0.0.1")
+
+	controller.LoginFailed(ip)
+	controller.LoginFailed(ip)
+	if err := controller.AllowConnection(ip); err != nil {
+		t.Fatalf("expected connection to be allowed before reaching the limit, got %v", err)
+	}
+
+	controller.LoginFailed(ip)
+	if err := controller.AllowConnection(ip); !errors.Is(err, ErrLockedOut) {
+		t.Errorf("expected ErrLockedOut, got %v", err)
+	}
+
+	clock.current = clock.current.Add(time.Minute + time.Second)
+	if err := controller.AllowConnection(ip); err != nil {
+		t.Errorf("expected connection to be allowed after lockout, got %v", err)
+	}
+}
+
+func TestAllowConnectionBanned(t *testing.T) {
+	banList := NewBanList("")
+	if err := banList.Ban("192.168.0.0/16"); err != nil {
+		t.Fatalf("unexpected error banning network: %v", err)
+	}
+
+	controller, _ := newTestController(Limits{}, banList)
+
+	if err := controller.AllowConnection(net.ParseIP("192.168.10.20")); !errors.Is(err, ErrBanned) {
+		t.Errorf("expected ErrBanned, got %v", err)
+	}
+	if err := controller.AllowConnection(net.ParseIP("10.0.0.1")); err != nil {
+		t.Errorf("expected connection to be allowed, got %v", err)
+	}
+}
+
+func TestSetLimitsAndBanList(t *testing.T) {
+	controller, _ := newTestController(Limits{MaxSessions: 1}, nil)
+	ip := net.ParseIP("10.0.0.1")
+
+	if _, err := controller.AcquireSession(ip); err != nil {
+		t.Fatalf("expected the first session to be allowed, got %v", err)
+	}
+
+	controller.SetLimits(Limits{MaxSessions: 2})
+	if _, err := controller.AcquireSession(ip); err != nil {
+		t.Errorf("expected a second session with the raised limit, got %v", err)
+	}
+	controller.SetLimits(Limits{MaxSessions: 1})
+	if _, err := controller.AcquireSession(ip); !errors.Is(err, ErrTooManySessions) {
+		t.Errorf("expected the sessions acquired to count towards the lowered limit, got %v", err)
+	}
+
+	banList := NewBanList("")
+	banList.Ban("10.0.0.0/8")
+	controller.SetBanList(banList)
+	if err := controller.AllowConnection(ip); !errors.Is(err, ErrBanned) {
+		t.Errorf("expected ErrBanned with the new ban list, got %v", err)
+	}
+
+	controller.SetBanList(nil)
+	if err := controller.AllowConnection(ip); err != nil {
+		t.Errorf("expected connection to be allowed without a ban list, got %v", err)
+	}
+}
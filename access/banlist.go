@@ -0,0 +1,185 @@
+package access
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// BanList keeps banned addresses and networks, persisted as a text file with one ip or CIDR per line ('#' starts a comment)
+type BanList struct {
+	mutex    sync.RWMutex
+	filePath string
+	entries  []string
+	networks []*net.IPNet
+}
+
+func NewBanList(filePath string) *BanList {
+	return &BanList{
+		filePath: filePath,
+	}
+}
+
+// LoadBanList reads the ban list file; a missing file is an empty ban list
+func LoadBanList(filePath string) (*BanList, error) {
+	b := NewBanList(filePath)
+	if err := b.Load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *BanList) Load() error {
+	if b.filePath == "" {
+		return nil
+	}
+
+	file, err := os.Open(b.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("[BanList.Load] - error opening ban list %s: %w", b.filePath, err)
+	}
+	defer file.Close()
+
+	var entries []string
+	var networks []*net.IPNet
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := scanner.Text()
+		if index := strings.IndexByte(line, '#'); index >= 0 {
+			line = line[:index]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		network, err := parseNetwork(line)
+		if err != nil {
+			return fmt.Errorf("[BanList.Load] - error on line %d of %s: %w", lineNumber, b.filePath, err)
+		}
+
+		entries = append(entries, line)
+		networks = append(networks, network)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("[BanList.Load] - error reading ban list %s: %w", b.filePath, err)
+	}
+
+	b.mutex.Lock()
+	b.entries = entries
+	b.networks = networks
+	b.mutex.Unlock()
+
+	return nil
+}
+
+func (b *BanList) Contains(ip net.IP) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, network := range b.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ban adds an ip or CIDR to the list and persists it
+func (b *BanList) Ban(entry string) error {
+	network, err := parseNetwork(entry)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, existing := range b.entries {
+		if existing == entry {
+			return nil
+		}
+	}
+
+	b.entries = append(b.entries, entry)
+	b.networks = append(b.networks, network)
+
+	return b.save()
+}
+
+// Unban removes an entry previously added with the exact same text
+func (b *BanList) Unban(entry string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i, existing := range b.entries {
+		if existing == entry {
+			b.entries = append(b.entries[:i], b.entries[i+1:]...)
+			b.networks = append(b.networks[:i], b.networks[i+1:]...)
+			return b.save()
+		}
+	}
+
+	return fmt.Errorf("[BanList.Unban] - %s is not banned", entry)
+}
+
+func (b *BanList) Entries() []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return append([]string(nil), b.entries...)
+}
+
+func (b *BanList) save() error {
+	if b.filePath == "" {
+		return nil
+	}
+
+	temporaryPath := b.filePath + ".tmp"
+	content := strings.Join(b.entries, "\n") + "\n"
+
+	if err := os.WriteFile(temporaryPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("[BanList.save] - error writing ban list: %w", err)
+	}
+
+	if err := os.Rename(temporaryPath, b.filePath); err != nil {
+		return fmt.Errorf("[BanList.save] - error replacing ban list: %w", err)
+	}
+
+	return nil
+}
+
+func parseNetwork(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s: %w", entry, err)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip address %s", entry)
+	}
+
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
@@ -0,0 +1,87 @@
+package access
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBanList(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "banlist.txt")
+	content := "# griefers\n10.0.0.1\n\n172.16.0.0/12 # whole network\n2001:db8::/32\n"
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write ban list: %v", err)
+	}
+
+	banList, err := LoadBanList(filePath)
+	if err != nil {
+		t.Fatalf("expected no error loading ban list, got %v", err)
+	}
+
+	tests := []struct {
+		ip     string
+		banned bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.2", false},
+		{"172.20.1.1", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+
+	for _, tt := range tests {
+		if got := banList.Contains(net.ParseIP(tt.ip)); got != tt.banned {
+			t.Errorf("expected Contains(%s) to be %v, got %v", tt.ip, tt.banned, got)
+		}
+	}
+}
+
+func TestLoadBanListMissingFile(t *testing.T) {
+	banList, err := LoadBanList(filepath.Join(t.TempDir(), "missing.txt"))
+	if err != nil {
+		t.Fatalf("expected missing ban list to be empty, got %v", err)
+	}
+
+	if len(banList.Entries()) != 0 {
+		t.Errorf("expected no entries, got %v", banList.Entries())
+	}
+}
+
+func TestLoadBanListInvalidEntry(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "banlist.txt")
+	if err := os.WriteFile(filePath, []byte("10.0.0.1\nnot-an-ip\n"), 0644); err != nil {
+		t.Fatalf("failed to write ban list: %v", err)
+	}
+
+	if _, err := LoadBanList(filePath); err == nil {
+		t.Error("expected error loading invalid ban list, got none")
+	}
+}
+
+func TestBanListPersistence(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "banlist.txt")
+	banList := NewBanList(filePath)
+
+	if err := banList.Ban("10.0.0.1"); err != nil {
+		t.Fatalf("unexpected error banning ip: %v", err)
+	}
+	if err := banList.Ban("192.168.0.0/24"); err != nil {
+		t.Fatalf("unexpected error banning network: %v", err)
+	}
+	if err := banList.Unban("10.0.0.1"); err != nil {
+		t.Fatalf("unexpected error unbanning ip: %v", err)
+	}
+
+	reloaded, err := LoadBanList(filePath)
+	if err != nil {
+		t.Fatalf("expected no error reloading ban list, got %v", err)
+	}
+
+	if reloaded.Contains(net.ParseIP("10.0.0.1")) {
+		t.Error("expected unbanned ip to be allowed after reload")
+	}
+	if !reloaded.Contains(net.ParseIP("192.168.0.77")) {
+		t.Error("expected banned network to persist after reload")
+	}
+}
@@ -0,0 +1,257 @@
+package cam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// A compiled cam starts with a fixed header, followed by the packets and then by an index with the offset of
+// every packet, so the packets can be reached without reading the ones before them:
+//
+//	header   magic, version, source size, modification time and sha256, packet count, first and last
+//	         timestamp, index offset
+//	packets  timestamp int64, type byte, data length uint32, data
+//	index    offset uint64 per packet
+//
+// Every number is little endian.
+const (
+	BINARY_CAM_VERSION     = 1
+	BINARY_CAM_HEADER_SIZE = 96
+	BINARY_CAM_RECORD_SIZE = 13 // the record of a packet without its data
+
+	// where the modification time of the text file is in the header
+	BINARY_CAM_MOD_TIME_OFFSET = 24
+)
+
+var binaryCamMagic = []byte("OTCAMBIN")
+
+var ErrInvalidBinaryCam = errors.New("invalid compiled cam")
+
+// binaryCamHeader is what the header tells about the cam and the text file it was compiled from
+type binaryCamHeader struct {
+	SourceSize     int64
+	SourceModTime  int64 // unix nanoseconds
+	SourceHash     [32]byte
+	PacketCount    int64
+	FirstTimestamp int64
+	LastTimestamp  int64
+	IndexOffset    int64
+}
+
+func (h *binaryCamHeader) encode() []byte {
+	buffer := make([]byte, 0, BINARY_CAM_HEADER_SIZE)
+	buffer = append(buffer, binaryCamMagic...)
+	buffer = binary.LittleEndian.AppendUint32(buffer, BINARY_CAM_VERSION)
+	buffer = binary.LittleEndian.AppendUint32(buffer, 0) // flags, none yet
+	buffer = binary.LittleEndian.AppendUint64(buffer, uint64(h.SourceSize))
+	buffer = binary.LittleEndian.AppendUint64(buffer, uint64(h.SourceModTime))
+	buffer = append(buffer, h.SourceHash[:]...)
+	buffer = binary.LittleEndian.AppendUint64(buffer, uint64(h.PacketCount))
+	buffer = binary.LittleEndian.AppendUint64(buffer, uint64(h.FirstTimestamp))
+	buffer = binary.LittleEndian.AppendUint64(buffer, uint64(h.LastTimestamp))
+	buffer = binary.LittleEndian.AppendUint64(buffer, uint64(h.IndexOffset))
+	return buffer
+}
+
+// decodeBinaryCamHeader reads the header of data and checks that the index fits in it
+func decodeBinaryCamHeader(data []byte) (binaryCamHeader, error) {
+	var header binaryCamHeader
+
+	if len(data) < BINARY_CAM_HEADER_SIZE || !bytes.Equal(data[:8], binaryCamMagic) {
+		return header, ErrInvalidBinaryCam
+	}
+	if version := binary.LittleEndian.Uint32(data[8:]); version != BINARY_CAM_VERSION {
+		return header, fmt.Errorf("%w: version %d", ErrInvalidBinaryCam, version)
+	}
+
+	header.SourceSize = int64(binary.LittleEndian.Uint64(data[16:]))
+	header.SourceModTime = int64(binary.LittleEndian.Uint64(data[BINARY_CAM_MOD_TIME_OFFSET:]))
+	copy(header.SourceHash[:], data[32:64])
+	header.PacketCount = int64(binary.LittleEndian.Uint64(data[64:]))
+	header.FirstTimestamp = int64(binary.LittleEndian.Uint64(data[72:]))
+	header.LastTimestamp = int64(binary.LittleEndian.Uint64(data[80:]))
+	header.IndexOffset = int64(binary.LittleEndian.Uint64(data[88:]))
+
+	if header.PacketCount < 0 || header.IndexOffset < BINARY_CAM_HEADER_SIZE ||
+		header.IndexOffset > int64(len(data)) || (int64(len(data))-header.IndexOffset)/8 < header.PacketCount {
+		return header, fmt.Errorf("%w: index out of bounds", ErrInvalidBinaryCam)
+	}
+	return header, nil
+}
+
+// binaryCamWriter writes a compiled cam, the header goes last once the packets are known
+type binaryCamWriter struct {
+	writer  io.WriterAt
+	header  binaryCamHeader
+	offset  int64
+	index   []byte
+	scratch []byte
+}
+
+func newBinaryCamWriter(writer io.WriterAt) *binaryCamWriter {
+	return &binaryCamWriter{writer: writer, offset: BINARY_CAM_HEADER_SIZE}
+}
+
+func (w *binaryCamWriter) add(packet CamPacket) error {
+	if w.header.PacketCount == 0 {
+		w.header.FirstTimestamp = packet.Timestamp
+	}
+	w.header.LastTimestamp = packet.Timestamp
+	w.header.PacketCount++
+	w.index = binary.LittleEndian.AppendUint64(w.index, uint64(w.offset))
+
+	packetType := byte('<')
+	if packet.Type == ">" {
+		packetType = '>'
+	}
+
+	w.scratch = binary.LittleEndian.AppendUint64(w.scratch[:0], uint64(packet.Timestamp))
+	w.scratch = append(w.scratch, packetType)
+	w.scratch = binary.LittleEndian.AppendUint32(w.scratch, uint32(len(packet.Data)))
+	w.scratch = append(w.scratch, packet.Data...)
+
+	if _, err := w.writer.WriteAt(w.scratch, w.offset); err != nil {
+		return err
+	}
+	w.offset += int64(len(w.scratch))
+	return nil
+}
+
+// finish writes the index and then the header, which makes the file valid
+func (w *binaryCamWriter) finish(source binaryCamHeader) error {
+	w.header.SourceSize = source.SourceSize
+	w.header.SourceModTime = source.SourceModTime
+	w.header.SourceHash = source.SourceHash
+	w.header.IndexOffset = w.offset
+
+	if _, err := w.writer.WriteAt(w.index, w.offset); err != nil {
+		return err
+	}
+	_, err := w.writer.WriteAt(w.header.encode(), 0)
+	return err
+}
+
+// mappedCam is a compiled cam in memory, shared read only by the readers of every session playing it. The
+// cache holds a reference as long as the cam is current, so it stays mapped until it is replaced and the
+// last reader of the old one is closed.
+type mappedCam struct {
+	path   string // of the text file
+	data   []byte
+	header binaryCamHeader
+	unmap  func() error
+
+	mutex      sync.Mutex
+	references int
+}
+
+func (m *mappedCam) acquire() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.references++
+}
+
+// release unmaps the cam once the last reader is closed
+func (m *mappedCam) release() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.references--
+	if m.references == 0 && m.unmap != nil {
+		if err := m.unmap(); err != nil {
+			fmt.Printf("[mappedCam.release] - Error unmapping compiled %s: %v\n", m.path, err)
+		}
+		m.data = nil
+	}
+}
+
+func (m *mappedCam) offset(index int64) int64 {
+	return int64(binary.LittleEndian.Uint64(m.data[m.header.IndexOffset+index*8:]))
+}
+
+func (m *mappedCam) packet(index int64) (CamPacket, error) {
+	offset := m.offset(index)
+	if offset < BINARY_CAM_HEADER_SIZE || offset+BINARY_CAM_RECORD_SIZE > m.header.IndexOffset {
+		return CamPacket{}, fmt.Errorf("%w: packet %d out of bounds", ErrInvalidBinaryCam, index)
+	}
+
+	record := m.data[offset:]
+	length := int64(binary.LittleEndian.Uint32(record[9:]))
+	if offset+BINARY_CAM_RECORD_SIZE+length > m.header.IndexOffset {
+		return CamPacket{}, fmt.Errorf("%w: packet %d out of bounds", ErrInvalidBinaryCam, index)
+	}
+
+	packet := CamPacket{
+		Timestamp: int64(binary.LittleEndian.Uint64(record)),
+		Type:      "<",
+		Data:      record[BINARY_CAM_RECORD_SIZE : BINARY_CAM_RECORD_SIZE+length : BINARY_CAM_RECORD_SIZE+length],
+	}
+	if record[8] == '>' {
+		packet.Type = ">"
+	}
+	return packet, nil
+}
+
+// BinaryCamReader reads a compiled cam from a CamCache. The data of the packets it returns points into the
+// shared memory of the cam, it must not be modified and is only valid until the reader is closed.
+type BinaryCamReader struct {
+	cam  *mappedCam
+	path string
+	next int64
+}
+
+func newBinaryCamReader(cam *mappedCam) *BinaryCamReader {
+	cam.acquire()
+	return &BinaryCamReader{cam: cam, path: cam.path}
+}
+
+func (r *BinaryCamReader) NextPacket() (CamPacket, error) {
+	if r.next >= r.cam.header.PacketCount {
+		return CamPacket{}, io.EOF
+	}
+
+	packet, err := r.cam.packet(r.next)
+	r.next++
+	return packet, err
+}
+
+func (r *BinaryCamReader) LastPacket() (CamPacket, error) {
+	if r.cam.header.PacketCount == 0 {
+		return CamPacket{}, io.EOF
+	}
+	return r.cam.packet(r.cam.header.PacketCount - 1)
+}
+
+func (r *BinaryCamReader) Reset() error {
+	r.next = 0
+	return nil
+}
+
+// SeekTimestamp moves the reader to the first packet recorded at timestamp or later
+func (r *BinaryCamReader) SeekTimestamp(timestamp int64) {
+	count := int(r.cam.header.PacketCount)
+	r.next = int64(sort.Search(count, func(i int) bool {
+		packet, err := r.cam.packet(int64(i))
+		return err != nil || packet.Timestamp >= timestamp
+	}))
+}
+
+// PacketCount is the number of packets of the cam, of both directions
+func (r *BinaryCamReader) PacketCount() int64 {
+	return r.cam.header.PacketCount
+}
+
+func (r *BinaryCamReader) Close() {
+	if r.cam != nil {
+		r.cam.release()
+		r.cam = nil
+	}
+}
+
+func (r *BinaryCamReader) Filename() string {
+	return r.path
+}
@@ -0,0 +1,274 @@
+package cam
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/library"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const BINARY_CAM_EXTENSION = ".camc"
+
+// CamCache compiles cam files into a binary copy the first time they are played, and maps the copies into
+// memory shared by every session playing them. A copy is compiled again once its cam file changed: a new
+// size or modification time, unless the content hashes the same as before.
+type CamCache struct {
+	dir string
+
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry
+
+	builds int // copies compiled, for the tests
+}
+
+// cacheEntry serializes the compilation of one cam, so sessions opening it together wait for a single build
+type cacheEntry struct {
+	mutex sync.Mutex
+	cam   *mappedCam
+}
+
+func NewCamCache(dir string) (*CamCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cam cache directory %s: %w", dir, err)
+	}
+	return &CamCache{dir: dir, entries: make(map[string]*cacheEntry)}, nil
+}
+
+// Open returns a reader of the compiled copy of the cam file, compiling it when there is no valid one
+func (c *CamCache) Open(filePath string) (*BinaryCamReader, error) {
+	absolutePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	entry, ok := c.entries[absolutePath]
+	if !ok {
+		entry = &cacheEntry{}
+		c.entries[absolutePath] = entry
+	}
+	c.mutex.Unlock()
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cam file %s: %w", filePath, err)
+	}
+
+	if entry.cam != nil && entry.cam.header.SourceSize == info.Size() && entry.cam.header.SourceModTime == info.ModTime().UnixNano() {
+		return newBinaryCamReader(entry.cam), nil
+	}
+
+	cam, err := c.load(filePath, c.binaryPath(absolutePath), info)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.cam != nil {
+		entry.cam.release()
+	}
+	entry.cam = cam
+	return newBinaryCamReader(cam), nil
+}
+
+// Forget drops the compiled copy of a cam file that changed or is gone, instead of waiting for the next Open
+// to notice. Sessions playing the copy keep reading it until they close their readers.
+func (c *CamCache) Forget(filePath string) {
+	absolutePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	entry, ok := c.entries[absolutePath]
+	delete(c.entries, absolutePath)
+	c.mutex.Unlock()
+
+	if ok {
+		entry.mutex.Lock()
+		if entry.cam != nil {
+			entry.cam.release()
+			entry.cam = nil
+		}
+		entry.mutex.Unlock()
+	}
+
+	if err := os.Remove(c.binaryPath(absolutePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("[CamCache.Forget] - Error removing the compiled %s: %v\n", filePath, err)
+	}
+}
+
+// Warm compiles a cam file ahead of its first viewer
+func (c *CamCache) Warm(filePath string) error {
+	reader, err := c.Open(filePath)
+	if err != nil {
+		return err
+	}
+	reader.Close()
+	return nil
+}
+
+// Close drops the references of the cache, the cams are unmapped once their readers are closed as well
+func (c *CamCache) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for path, entry := range c.entries {
+		entry.mutex.Lock()
+		if entry.cam != nil {
+			entry.cam.release()
+			entry.cam = nil
+		}
+		entry.mutex.Unlock()
+		delete(c.entries, path)
+	}
+}
+
+// binaryPath names the copy after a hash of the cam file path, cams of different folders may share a name
+func (c *CamCache) binaryPath(absolutePath string) string {
+	sum := sha256.Sum256([]byte(absolutePath))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:8])+"-"+library.CamName(absolutePath)+BINARY_CAM_EXTENSION)
+}
+
+// load maps the compiled copy of the cam file, compiling it first when it is missing or outdated
+func (c *CamCache) load(filePath string, binaryPath string, info os.FileInfo) (*mappedCam, error) {
+	cam, err := mapBinaryCam(filePath, binaryPath)
+	if err == nil {
+		if cam.header.SourceSize == info.Size() && cam.header.SourceModTime == info.ModTime().UnixNano() {
+			return cam, nil
+		}
+
+		// touched or copied over without a change, the hash tells
+		if cam.header.SourceSize == info.Size() {
+			if hash, err := hashFile(filePath); err == nil && hash == cam.header.SourceHash {
+				updateSourceModTime(binaryPath, info)
+				cam.header.SourceModTime = info.ModTime().UnixNano()
+				return cam, nil
+			}
+		}
+		cam.release()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("[CamCache.load] - Compiling %s again: %v\n", filePath, err)
+	}
+
+	if err := c.compile(filePath, binaryPath, info); err != nil {
+		return nil, err
+	}
+	return mapBinaryCam(filePath, binaryPath)
+}
+
+// compile writes the compiled copy next to where it goes and renames it into place, so sessions still
+// playing the previous copy keep their mapping
+func (c *CamCache) compile(filePath string, binaryPath string, info os.FileInfo) error {
+	source := binaryCamHeader{SourceSize: info.Size(), SourceModTime: info.ModTime().UnixNano()}
+	hash, err := hashFile(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading cam file %s: %w", filePath, err)
+	}
+	source.SourceHash = hash
+
+	reader := NewCamFileReader()
+	if err := reader.Open(filePath); err != nil {
+		reader.Close()
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.CreateTemp(c.dir, filepath.Base(binaryPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating compiled cam: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer := newBinaryCamWriter(file)
+	for {
+		packet, err := reader.NextPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if parseErr := new(ParseError); errors.As(err, &parseErr) {
+			fmt.Printf("%v\n", parseErr)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error compiling %s: %w", filePath, err)
+		}
+
+		if err := writer.add(packet); err != nil {
+			return fmt.Errorf("error writing compiled cam: %w", err)
+		}
+	}
+
+	if err := writer.finish(source); err != nil {
+		return fmt.Errorf("error writing compiled cam: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing compiled cam: %w", err)
+	}
+	if err := os.Rename(file.Name(), binaryPath); err != nil {
+		return fmt.Errorf("error writing compiled cam: %w", err)
+	}
+
+	c.mutex.Lock()
+	c.builds++
+	c.mutex.Unlock()
+	return nil
+}
+
+// mapBinaryCam maps a compiled copy and checks its header, the cache holds the first reference
+func mapBinaryCam(filePath string, binaryPath string) (*mappedCam, error) {
+	data, unmap, err := mapFile(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := decodeBinaryCamHeader(data)
+	if err != nil {
+		if unmap != nil {
+			unmap()
+		}
+		return nil, err
+	}
+
+	return &mappedCam{path: filePath, data: data, header: header, unmap: unmap, references: 1}, nil
+}
+
+func hashFile(filePath string) ([32]byte, error) {
+	var hash [32]byte
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return hash, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return hash, err
+	}
+	copy(hash[:], hasher.Sum(nil))
+	return hash, nil
+}
+
+// updateSourceModTime records the new modification time of an unchanged cam file, so it is not hashed again
+func updateSourceModTime(binaryPath string, info os.FileInfo) {
+	file, err := os.OpenFile(binaryPath, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	modTime := binary.LittleEndian.AppendUint64(nil, uint64(info.ModTime().UnixNano()))
+	if _, err := file.WriteAt(modTime, BINARY_CAM_MOD_TIME_OFFSET); err != nil {
+		fmt.Printf("[updateSourceModTime] - Error updating %s: %v\n", binaryPath, err)
+	}
+}
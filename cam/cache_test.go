@@ -0,0 +1,273 @@
+package cam
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"go-opentibia-camplayerserver/clock"
+	"go-opentibia-camplayerserver/command"
+	"go-opentibia-camplayerserver/library"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+const cacheTestLines = "< 1000 0a01\n> 1010 0b01\nnot a packet\n< 1500 0a0203\n< 2000 0a04\n"
+
+func readAllPackets(t *testing.T, reader PacketReader) []CamPacket {
+	t.Helper()
+
+	var packets []CamPacket
+	for {
+		packet, err := reader.NextPacket()
+		if errors.Is(err, io.EOF) {
+			return packets
+		}
+		if parseErr := new(ParseError); errors.As(err, &parseErr) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to read packet: %v", err)
+		}
+		packets = append(packets, CamPacket{Timestamp: packet.Timestamp, Type: packet.Type, Data: append([]byte(nil), packet.Data...)})
+	}
+}
+
+func samePackets(a []CamPacket, b []CamPacket) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Timestamp != b[i].Timestamp || a[i].Type != b[i].Type || !bytes.Equal(a[i].Data, b[i].Data) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCamCacheReadsLikeTheTextFile(t *testing.T) {
+	plainFile := writeCamFile(t, cacheTestLines)
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write([]byte(cacheTestLines))
+	gzipWriter.Close()
+	gzipFile := filepath.Join(t.TempDir(), "test.cam.gz")
+	if err := os.WriteFile(gzipFile, compressed.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write cam file: %v", err)
+	}
+
+	cache, err := NewCamCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create the cache: %v", err)
+	}
+	defer cache.Close()
+
+	for _, filePath := range []string{plainFile, gzipFile} {
+		textReader := NewCamFileReader()
+		if err := textReader.Open(filePath); err != nil {
+			t.Fatalf("failed to open %s: %v", filePath, err)
+		}
+		expected := readAllPackets(t, textReader)
+		textReader.Close()
+
+		reader, err := cache.Open(filePath)
+		if err != nil {
+			t.Fatalf("failed to open %s from the cache: %v", filePath, err)
+		}
+
+		if packets := readAllPackets(t, reader); !samePackets(packets, expected) || len(packets) != 4 {
+			t.Errorf("%s: expected the packets of the text file %v, got %v", filePath, expected, packets)
+		}
+
+		last, err := reader.LastPacket()
+		if err != nil || last.Timestamp != 2000 {
+			t.Errorf("%s: expected the last packet at 2000, got %v and %v", filePath, last, err)
+		}
+
+		reader.Reset()
+		if first, err := reader.NextPacket(); err != nil || first.Timestamp != 1000 {
+			t.Errorf("%s: expected to read from the start after a reset, got %v and %v", filePath, first, err)
+		}
+
+		if reader.Filename() != filePath {
+			t.Errorf("expected the reader to be named after the cam file, got %s", reader.Filename())
+		}
+		reader.Close()
+	}
+}
+
+func TestBinaryCamReaderSeekTimestamp(t *testing.T) {
+	cache, _ := NewCamCache(t.TempDir())
+	defer cache.Close()
+
+	reader, err := cache.Open(writeCamFile(t, cacheTestLines))
+	if err != nil {
+		t.Fatalf("failed to open from the cache: %v", err)
+	}
+	defer reader.Close()
+
+	for _, test := range []struct {
+		timestamp int64
+		expected  int64
+	}{{0, 1000}, {1000, 1000}, {1001, 1010}, {1600, 2000}} {
+		reader.SeekTimestamp(test.timestamp)
+		if packet, err := reader.NextPacket(); err != nil || packet.Timestamp != test.expected {
+			t.Errorf("seeking to %d: expected the packet at %d, got %v and %v", test.timestamp, test.expected, packet, err)
+		}
+	}
+
+	reader.SeekTimestamp(2001)
+	if _, err := reader.NextPacket(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the end after seeking past the last packet, got %v", err)
+	}
+}
+
+func TestCamCacheSharesAndInvalidates(t *testing.T) {
+	cache, _ := NewCamCache(t.TempDir())
+	defer cache.Close()
+	filePath := writeCamFile(t, cacheTestLines)
+
+	first, err := cache.Open(filePath)
+	if err != nil {
+		t.Fatalf("failed to open from the cache: %v", err)
+	}
+	second, _ := cache.Open(filePath)
+	if first.cam != second.cam || cache.builds != 1 {
+		t.Fatalf("expected both readers to share a single compiled copy, got %d builds", cache.builds)
+	}
+
+	// a new modification time alone does not compile it again, a new cache finds it on disk
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filePath, later, later)
+	otherCache, _ := NewCamCache(cache.dir)
+	defer otherCache.Close()
+	if reader, err := otherCache.Open(filePath); err != nil || otherCache.builds != 0 {
+		t.Errorf("expected the unchanged cam to be read from disk, got %d builds and %v", otherCache.builds, err)
+	} else {
+		reader.Close()
+	}
+
+	// a change does, the readers of the old copy keep reading it
+	if err := os.WriteFile(filePath, []byte("< 5000 0a09\n"), 0644); err != nil {
+		t.Fatalf("failed to write cam file: %v", err)
+	}
+	even := later.Add(time.Hour)
+	os.Chtimes(filePath, even, even)
+
+	third, err := cache.Open(filePath)
+	if err != nil {
+		t.Fatalf("failed to open the changed cam: %v", err)
+	}
+	defer third.Close()
+	if packet, _ := third.NextPacket(); packet.Timestamp != 5000 || cache.builds != 2 {
+		t.Errorf("expected the changed cam to be compiled again, got %v after %d builds", packet, cache.builds)
+	}
+
+	if packets := readAllPackets(t, first); len(packets) != 4 {
+		t.Errorf("expected the old copy to stay readable, got %v", packets)
+	}
+
+	oldCam := first.cam
+	first.Close()
+	second.Close()
+	if oldCam.references != 0 || oldCam.data != nil {
+		t.Errorf("expected the old copy to be unmapped with its last reader, %d references left", oldCam.references)
+	}
+}
+
+func TestCamCacheRejectsCorruptCopies(t *testing.T) {
+	cache, _ := NewCamCache(t.TempDir())
+	defer cache.Close()
+	filePath := writeCamFile(t, cacheTestLines)
+
+	absolutePath, _ := filepath.Abs(filePath)
+	if err := os.WriteFile(cache.binaryPath(absolutePath), []byte("OTCAMBIN garbage"), 0644); err != nil {
+		t.Fatalf("failed to write corrupt copy: %v", err)
+	}
+
+	reader, err := cache.Open(filePath)
+	if err != nil || cache.builds != 1 {
+		t.Fatalf("expected the corrupt copy to be compiled again, got %d builds and %v", cache.builds, err)
+	}
+	defer reader.Close()
+
+	if packets := readAllPackets(t, reader); len(packets) != 4 {
+		t.Errorf("expected the packets of the cam, got %v", packets)
+	}
+}
+
+func TestCamPlayerPlaysFromTheCache(t *testing.T) {
+	cache, _ := NewCamCache(t.TempDir())
+	defer cache.Close()
+
+	filePath := writeCamFile(t, "< 1000 0a01\n< 1000 0a02\n> 1010 0b01\n< 1000 0a03\n< 1050 0a04\n< 1100 0a05\n")
+	conn := &recordingConn{}
+
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, library.Single(filePath), conn, PlayerOptions{Cache: cache, Clock: fakeClock})
+	if _, ok := player.reader.(*BinaryCamReader); !ok {
+		t.Fatalf("expected the player to read from the cache, got %T", player.reader)
+	}
+	playFor(t, player, fakeClock, time.Second)
+
+	expectedFrames := []int{3, 1, 1}
+	if frames := recordedFrames(conn); !slices.Equal(frames, expectedFrames) {
+		t.Errorf("expected the writes to carry %v frames, got %v", expectedFrames, frames)
+	}
+}
+
+func TestCamPlayerSeeksBackInTheCache(t *testing.T) {
+	cache, _ := NewCamCache(t.TempDir())
+	defer cache.Close()
+
+	filePath := writeCamFile(t, "< 0 0a01\n< 1000 0a02\n< 2000 0a03\n< 3000 0a04\n")
+	conn := &recordingConn{}
+
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, library.Single(filePath), conn, PlayerOptions{Cache: cache, Clock: fakeClock})
+	playFor(t, player, fakeClock, 2500*time.Millisecond)
+
+	backward := command.Seek{Replies: command.NewReplies(), Delta: -time.Second}
+	player.handleCommand(backward)
+	<-backward.ReplyCh
+
+	// the client builds its game state from every packet, so the compiled cam is replayed from its start as well
+	if frames := recordedFrames(conn); !slices.Equal(frames, []int{1, 1, 1, 2}) {
+		t.Errorf("expected the packets up to the target to be replayed, got %v", frames)
+	}
+	if player.stats.currentTime != 1 || player.pending == nil || player.pending.Timestamp != 2000 {
+		t.Errorf("expected playback at 1.0 with the third packet pending, got %.1f and %v", player.stats.currentTime, player.pending)
+	}
+}
+
+func TestCamCacheForgetAndWarm(t *testing.T) {
+	cache, _ := NewCamCache(t.TempDir())
+	defer cache.Close()
+	filePath := writeCamFile(t, cacheTestLines)
+
+	if err := cache.Warm(filePath); err != nil || cache.builds != 1 {
+		t.Fatalf("expected the cam to be compiled ahead, got %d builds and %v", cache.builds, err)
+	}
+
+	reader, _ := cache.Open(filePath)
+	defer reader.Close()
+
+	absolutePath, _ := filepath.Abs(filePath)
+	cache.Forget(filePath)
+	if _, err := os.Stat(cache.binaryPath(absolutePath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the compiled copy to be removed, got %v", err)
+	}
+	if packets := readAllPackets(t, reader); len(packets) != 4 {
+		t.Errorf("expected the open reader to keep its copy, got %v", packets)
+	}
+
+	if again, err := cache.Open(filePath); err != nil || cache.builds != 2 {
+		t.Errorf("expected a forgotten cam to be compiled again, got %d builds and %v", cache.builds, err)
+	} else {
+		again.Close()
+	}
+}
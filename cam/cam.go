@@ -1,13 +1,17 @@
 package cam
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/clock"
+	"go-opentibia-camplayerserver/command"
+	"go-opentibia-camplayerserver/library"
 	"go-opentibia-camplayerserver/protocol"
+	"go-opentibia-camplayerserver/status"
+	"go-opentibia-camplayerserver/welcome"
 	"io"
-	"math"
-	"sync"
 	"time"
 )
 
@@ -19,154 +23,679 @@ type CamPacket struct {
 
 var ErrParse = errors.New("parse error")
 
+// errEntryEnd is the io.EOF of a playlist entry ending before its cam does
+var errEntryEnd = fmt.Errorf("%w: end of the playlist entry", io.EOF)
+
+// bounds of the default speed control
 const (
 	MINIMUM_PLAY_SPEED = 0.25
 	MAXIMUM_PLAY_SPEED = 64
 )
 
 type CamStats struct {
-	currentTime float64
-	duration    float64
-	speed       float64
-	date        string
+	currentTime  float64
+	duration     float64
+	speed        float64
+	recordedAt   time.Time
+	skipIdle     bool
+	skippingIdle bool // the wait for the next packet is a compressed idle period
+	speedControl SpeedControl
 }
 
-func (c *CamStats) Format() string {
-	durationStr := "?"
-	if c.duration > 0 {
-		durationStr = fmt.Sprintf("%.1f", c.duration)
-	}
-
-	speedStr := "Paused"
-	if c.speed > 0 {
-		speedStr = fmt.Sprintf("Speed: %.2fx", c.speed)
+func (c *CamStats) State() status.State {
+	return status.State{
+		Position:     c.currentTime,
+		Duration:     c.duration,
+		Speed:        c.speed,
+		RecordedAt:   c.recordedAt,
+		SkipIdle:     c.skipIdle,
+		SkippingIdle: c.skippingIdle,
 	}
+}
 
-	return fmt.Sprintf("%.1f/%s | %s", c.currentTime, durationStr, speedStr)
+func (c *CamStats) Format() string {
+	return status.Default.Render(c.State())
 }
 
 func (c *CamStats) IncreaseSpeed() {
-	if c.speed <= 0 {
-		c.speed = 1
-	} else {
-		c.speed = math.Min(c.speed*2, MAXIMUM_PLAY_SPEED)
-	}
+	c.speed = c.speedControl.Up(c.speed)
 }
 
 func (c *CamStats) DecreaseSpeed() {
-	c.speed = math.Max(c.speed/2, MINIMUM_PLAY_SPEED)
+	c.speed = c.speedControl.Down(c.speed)
 }
 
 func (c *CamStats) Speed(speed float64) {
 	c.speed = speed
 }
 
-func HandleCamFileStreaming(wg *sync.WaitGroup, c *client.Client, filePath string) {
-	defer wg.Done()
-	defer c.Conn.Close()
+const (
+	STATUS_INTERVAL         = 100 * time.Millisecond
+	END_OF_FILE_CLOSE_DELAY = 5 * time.Second
+	SEND_BLOCKED_THRESHOLD  = 10 * time.Millisecond
 
-	camFileReader := NewCamFileReader()
+	// packets skipped over by a seek are sent in writes of this many packets
+	SEEK_BATCH_SIZE = 256
 
-	err := camFileReader.Open(filePath)
-	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return
+	// gaps between packets longer than the threshold are idle periods, cut down to the delay when skipping them
+	DEFAULT_IDLE_THRESHOLD = 10 * time.Second
+	DEFAULT_IDLE_DELAY     = 500 * time.Millisecond
+)
+
+// PlayerOptions are shared by every viewer of the server
+type PlayerOptions struct {
+	Welcome           *welcome.Screen // the default welcome sequence when nil
+	StatusFormat      *status.Format  // status.Default when nil
+	StatusInterval    time.Duration
+	StatusMessageType protocol.MessageType
+	OnEnd             EndMode
+	LobbyMessage      string // DEFAULT_LOBBY_MESSAGE when empty
+	SkipIdle          bool   // viewers start with idle skipping on, they can toggle it with /skipidle
+	IdleThreshold     time.Duration
+	IdleDelay         time.Duration
+	Speed             SpeedControl // the power of two steps between MINIMUM_PLAY_SPEED and MAXIMUM_PLAY_SPEED when zero
+	Clock             clock.Clock  // clock.Real when nil
+	Cache             *CamCache    // the cam files are read as text when nil
+	// cams still being recorded are followed instead of ending at the last packet written so far, the
+	// viewers are kept FollowDelay behind the recording so they do not catch up with every packet written
+	Follow        bool
+	FollowDelay   time.Duration // DEFAULT_FOLLOW_DELAY when 0
+	FollowTimeout time.Duration // a cam not written to for this long is finished, library.DEFAULT_SETTLE_DELAY when 0
+}
+
+// camPlayer streams a cam file to one viewer: instead of polling, it sleeps on a timer until the
+// next packet is due, a command arrives or the status line has to be refreshed
+type camPlayer struct {
+	client         *client.Client
+	playlist       library.Playlist
+	current        int // playlist entry being played
+	entryPackets   int // packets of the current entry played so far, the ones sent by a seek are not counted
+	reader         PacketReader
+	cache          *CamCache
+	fileInfo       CamFileInfo
+	stats          CamStats
+	pending        *CamPacket  // next packet to send, read ahead to know when it is due
+	readAhead      []CamPacket // pings held while skipping idle and the packet after them, see readNextPacket
+	readAheadErr   error       // returned once readAhead is played
+	pendingDue     time.Time
+	anchorTime     time.Time // packets are due relative to the anchor, see dueAt
+	anchorPosition float64   // cam milliseconds played at anchorTime
+	clock          clock.Clock
+	finished       bool
+	finishedAt     time.Time // the connection closes closeDelay after it, whatever the viewer does meanwhile
+	onEnd          EndMode
+	lobbyMessage   string
+	inLobby        bool // finished and waiting for the viewer instead of closing
+	idleThreshold  time.Duration
+	idleDelay      time.Duration
+	closeDelay     time.Duration
+	statusInterval time.Duration
+	statusFormat   *status.Format
+	statusType     protocol.MessageType
+	hud            bool // the status line is shown
+	welcome        *welcome.Screen
+	welcomeSent    bool
+	announcements  int // announcements sent so far
+	follow         bool
+	followDelay    time.Duration
+	followTimeout  time.Duration
+	live           bool // the current entry is still being recorded
+	waitingLive    bool // every packet written so far was played, the file is read again at pendingDue
+}
+
+func newCamPlayer(c *client.Client, playlist library.Playlist, options PlayerOptions) *camPlayer {
+	if options.Welcome == nil {
+		// the default lines always compile
+		options.Welcome, _ = welcome.NewScreen(welcome.Options{})
+	}
+	if options.StatusFormat == nil {
+		options.StatusFormat = status.Default
+	}
+	if options.StatusInterval <= 0 {
+		options.StatusInterval = STATUS_INTERVAL
+	}
+	if options.StatusMessageType == 0 {
+		options.StatusMessageType = protocol.MESSAGE_STATUS_SMALL
+	}
+	if options.LobbyMessage == "" {
+		options.LobbyMessage = DEFAULT_LOBBY_MESSAGE
+	}
+	if options.IdleThreshold <= 0 {
+		options.IdleThreshold = DEFAULT_IDLE_THRESHOLD
 	}
-	defer camFileReader.Close()
+	if options.IdleDelay <= 0 {
+		options.IdleDelay = DEFAULT_IDLE_DELAY
+	}
+	if options.Clock == nil {
+		options.Clock = clock.Real
+	}
+	if options.FollowDelay <= 0 {
+		options.FollowDelay = DEFAULT_FOLLOW_DELAY
+	}
+	if options.FollowTimeout <= 0 {
+		options.FollowTimeout = library.DEFAULT_SETTLE_DELAY
+	}
+	options.Speed = options.Speed.orDefault()
+
+	return &camPlayer{
+		client:         c,
+		playlist:       playlist,
+		stats:          CamStats{speed: min(max(1.0, options.Speed.Minimum), options.Speed.Maximum), skipIdle: options.SkipIdle, speedControl: options.Speed},
+		onEnd:          options.OnEnd,
+		lobbyMessage:   options.LobbyMessage,
+		idleThreshold:  options.IdleThreshold,
+		idleDelay:      options.IdleDelay,
+		closeDelay:     END_OF_FILE_CLOSE_DELAY,
+		statusInterval: options.StatusInterval,
+		statusFormat:   options.StatusFormat,
+		statusType:     options.StatusMessageType,
+		hud:            true,
+		welcome:        options.Welcome,
+		clock:          options.Clock,
+		cache:          options.Cache,
+		follow:         options.Follow,
+		followDelay:    options.FollowDelay,
+		followTimeout:  options.FollowTimeout,
+	}
+}
 
-	var camStats CamStats
-	camStats.speed = 1.0
+// HandlePlaylistStreaming plays the playlist to the client from its current entry until it ends, the viewer
+// logs out or ctx is done
+func HandlePlaylistStreaming(ctx context.Context, c *client.Client, playlist library.Playlist, options PlayerOptions) error {
+	if playlist.Current < 0 || playlist.Current >= len(playlist.Entries) {
+		return fmt.Errorf("playlist %s has no entry %d", playlist.Name, playlist.Current)
+	}
 
-	lastPacket, err := camFileReader.LastPacket()
-	camFileReader.Reset()
+	player := newCamPlayer(c, playlist, options)
+	defer player.close()
 
-	if err == nil {
-		camStats.duration = float64(lastPacket.Timestamp) / 1000.0
+	if err := player.openEntry(playlist.Current); err != nil {
+		return err
 	}
 
-	previousTimestamp := int64(0)
-	nextProcessPacketTimestamp := time.Now()
-	nextBeatcountTimestamp := time.Now()
+	return player.run(ctx)
+}
 
-	poolInterval := 5 * time.Millisecond
-	beatCountInterval := 100 * time.Millisecond
+// HandleCamFileStreaming plays the cam file to the client until it ends, the viewer logs out or ctx is done
+func HandleCamFileStreaming(ctx context.Context, c *client.Client, filePath string, options PlayerOptions) error {
+	return HandlePlaylistStreaming(ctx, c, library.Single(filePath), options)
+}
+
+func (p *camPlayer) run(ctx context.Context) error {
+	// what is due at the start goes out before any command is taken
+	if err := p.sendDuePackets(); err != nil {
+		return err
+	}
 
-	welcomeMessageSent := false
-	welcomeMessage := "Welcome"
+	timer := p.clock.NewTimer(p.closeDelay)
+	defer timer.Stop()
+	p.schedule(timer)
+
+	statusTicker := p.clock.NewTicker(p.statusInterval)
+	defer statusTicker.Stop()
+
+	// a nil channel never fires, leaving the announcements out
+	var announcementCh <-chan time.Time
+	if interval := p.welcome.AnnouncementInterval(); interval > 0 {
+		announcementTicker := p.clock.NewTicker(interval)
+		defer announcementTicker.Stop()
+		announcementCh = announcementTicker.C()
+	}
 
 	for {
 		select {
-		case <-c.CancelCh:
-			fmt.Printf("CamServer is shutting down and closing file %s\n", camFileReader.Filename())
-			return
+		case <-ctx.Done():
+			fmt.Printf("Stopping playback and closing file %s\n", p.reader.Filename())
+			return nil
 
-		case command := <-c.CommandCh:
-			switch command {
+		case cmd := <-p.client.CommandCh:
+			if !p.handleCommand(cmd) {
+				return nil
+			}
+			p.schedule(timer)
 
-			case "speedUp":
-				camStats.IncreaseSpeed()
+		case <-statusTicker.C():
+			p.sendStatus()
 
-			case "speedDown":
-				camStats.DecreaseSpeed()
+		case <-announcementCh:
+			p.sendAnnouncement()
 
-			case "pause":
-				camStats.Speed(0)
+		case <-timer.C():
+			if p.finished {
+				return nil
+			}
 
-			case "logout":
-				fmt.Printf("CamServer is shutting down and closing file %s\n", camFileReader.Filename())
-				return
+			if err := p.sendDuePackets(); err != nil {
+				return err
 			}
+			p.schedule(timer)
+		}
+	}
+}
 
-			fmt.Printf("received command %s\n", command)
-			continue
+// handleCommand applies a viewer command, returning false when the session has to end
+func (p *camPlayer) handleCommand(cmd command.Command) bool {
+	previousSpeed := p.stats.speed
 
-		default:
+	switch cmd := cmd.(type) {
 
-			if time.Now().After(nextProcessPacketTimestamp) && camStats.speed > 0 {
-				camPacket, err := camFileReader.NextPacket()
+	case command.SpeedUp:
+		p.stats.IncreaseSpeed()
 
-				if err != nil {
-					if errors.Is(err, io.EOF) {
-						fmt.Printf("Finished to play cam file %s, closing Connection in few seconds\n", camFileReader.Filename())
-						time.Sleep(5 * time.Second)
-						return
-					} else if parseErr := new(ParseError); errors.As(err, &parseErr) {
-						fmt.Printf("%v", parseErr)
-						continue
-					}
+	case command.SpeedDown:
+		p.stats.DecreaseSpeed()
 
-					fmt.Printf("Unexpected error: %v\n", err)
-					return
-				}
+	case command.Pause:
+		p.stats.Speed(0)
 
-				if camPacket.Type != "<" {
-					continue
-				}
+	case command.SetSpeed:
+		if control := p.stats.speedControl; !control.Allows(cmd.Speed) {
+			cmd.Reply("", fmt.Errorf("Speed must be between %gx and %gx.", control.Minimum, control.Maximum))
+			break
+		}
+		p.stats.Speed(cmd.Speed)
+		cmd.Reply(fmt.Sprintf("Speed set to %.2fx.", p.stats.speed), nil)
+
+	case command.Seek:
+		target := int64(p.stats.currentTime*1000) + cmd.Delta.Milliseconds()
+		if err := p.seek(target); err != nil {
+			cmd.Reply("", fmt.Errorf("Failed to seek: %v", err))
+			return false
+		}
+		cmd.Reply(fmt.Sprintf("Moved to %.1f.", p.stats.currentTime), nil)
+		return true
+
+	case command.SetHud:
+		p.hud = cmd.Visible
+		if p.hud {
+			cmd.Reply("Status line shown.", nil)
+		} else {
+			cmd.Reply("Status line hidden.", nil)
+		}
+		return true
+
+	case command.SetSkipIdle:
+		p.setSkipIdle(cmd.Enabled)
+		if cmd.Enabled {
+			cmd.Reply(fmt.Sprintf("Skipping idle periods longer than %s.", p.idleThreshold), nil)
+		} else {
+			cmd.Reply("Idle periods are played as recorded.", nil)
+		}
+		return true
 
-				if previousTimestamp != 0 {
-					delay := time.Duration(float64(camPacket.Timestamp-previousTimestamp) / camStats.speed)
-					nextProcessPacketTimestamp = time.Now().Add(delay * time.Millisecond)
+	case command.Stop:
+		p.stats.Speed(0)
+		if err := p.seek(0); err != nil {
+			fmt.Printf("Failed to rewind %s: %v\n", p.reader.Filename(), err)
+			return false
+		}
+		return true
+
+	case command.Logout:
+		fmt.Printf("CamServer is shutting down and closing file %s\n", p.reader.Filename())
+		return false
+	}
+
+	// playback continues from where it is at the new speed, a pause keeps the position to resume from
+	if p.pending != nil && previousSpeed != p.stats.speed && !p.stats.skippingIdle {
+		now := p.clock.Now()
+		p.anchor(now, p.position(now, previousSpeed))
+		if p.stats.speed > 0 {
+			p.pendingDue = p.dueAt(p.pending.Timestamp)
+		}
+	}
+
+	fmt.Printf("received command %s\n", cmd)
+	return true
+}
+
+// seek moves playback to target milliseconds: every packet up to it is sent without delay so the client
+// rebuilds the game state, going backwards replays the cam from its beginning
+func (p *camPlayer) seek(target int64) error {
+	if start := p.playlist.Entries[p.current].Start.Milliseconds(); target < start {
+		target = start
+	}
+
+	if target < int64(p.stats.currentTime*1000) {
+		if err := p.reader.Reset(); err != nil {
+			return err
+		}
+		p.pending = nil
+		p.readAhead = nil
+		p.readAheadErr = nil
+		p.finished = false
+		p.inLobby = false
+		p.waitingLive = false
+		p.stats.currentTime = 0
+	}
+
+	batch := make([][]byte, 0, SEEK_BATCH_SIZE)
+	for {
+		if p.pending == nil {
+			next, err := p.readNextPacket()
+			if err != nil {
+				if errors.Is(err, io.EOF) && err != errEntryEnd && p.stillRecording() {
+					p.waitForRecording(p.clock.Now())
+					break
+				}
+				if errors.Is(err, io.EOF) {
+					p.finished = true
+					p.finishedAt = p.clock.Now()
+					break
 				}
-				previousTimestamp = camPacket.Timestamp
+				return err
+			}
+			p.pending = &next
+		}
 
-				camStats.currentTime = float64(camPacket.Timestamp) / 1000.0
+		if p.pending.Timestamp > target {
+			break
+		}
 
-				protocol.SendRawData(c.Conn, c.XteaKey, &camPacket.Data)
+		batch = append(batch, p.pending.Data)
+		p.stats.currentTime = float64(p.pending.Timestamp) / 1000.0
+		p.pending = nil
 
+		if len(batch) == SEEK_BATCH_SIZE {
+			if err := protocol.SendRawDataBatch(p.client, batch); err != nil {
+				return err
 			}
+			batch = batch[:0]
+		}
+	}
 
-			if time.Now().After(nextBeatcountTimestamp) {
-				protocol.SendTextMessage(c.Conn, c.XteaKey, camStats.Format(), protocol.MESSAGE_STATUS_SMALL)
+	if err := protocol.SendRawDataBatch(p.client, batch); err != nil {
+		return err
+	}
 
-				if !welcomeMessageSent {
-					protocol.SendTextMessage(c.Conn, c.XteaKey, welcomeMessage, protocol.MESSAGE_STATUS_CONSOLE_BLUE)
-					welcomeMessageSent = true
-				}
+	if p.finished {
+		return p.entryEnded()
+	}
 
-				nextBeatcountTimestamp = time.Now().Add(beatCountInterval)
+	now := p.clock.Now()
+	if p.waitingLive {
+		// past what was recorded so far, playback goes on from the last packet as it is written
+		p.anchor(now, p.stats.currentTime*1000)
+		p.stats.skippingIdle = false
+		return nil
+	}
+
+	p.stats.currentTime = float64(target) / 1000.0
+	p.anchor(now, float64(target))
+	p.pendingDue = now
+	p.stats.skippingIdle = false
+	if p.stats.speed > 0 {
+		if p.isIdle(p.pending.Timestamp - target) {
+			p.anchor(now.Add(p.idleDelay), float64(p.pending.Timestamp))
+			p.stats.skippingIdle = true
+		}
+		p.pendingDue = p.dueAt(p.pending.Timestamp)
+	}
+
+	return nil
+}
+
+// anchor pins the cam position in milliseconds to a point in time, every due time is computed from it instead
+// of adding up the delays between packets, so their rounding never accumulates over a long cam
+func (p *camPlayer) anchor(at time.Time, position float64) {
+	p.anchorTime = at
+	p.anchorPosition = position
+}
+
+// dueAt is when the packet at timestamp milliseconds is due at the current speed, which must not be paused
+func (p *camPlayer) dueAt(timestamp int64) time.Time {
+	offset := (float64(timestamp) - p.anchorPosition) * float64(time.Millisecond) / p.stats.speed
+	return p.anchorTime.Add(time.Duration(offset))
+}
+
+// position is the cam position in milliseconds reached at now playing at speed since the anchor, kept between
+// the last packet sent and the pending one
+func (p *camPlayer) position(now time.Time, speed float64) float64 {
+	position := p.anchorPosition
+	if speed > 0 {
+		position += float64(now.Sub(p.anchorTime)) / float64(time.Millisecond) * speed
+	}
+	return min(max(position, p.stats.currentTime*1000), float64(p.pending.Timestamp))
+}
+
+// isIdle tells whether a gap of gap milliseconds between packets is an idle period to skip
+func (p *camPlayer) isIdle(gap int64) bool {
+	return p.stats.skipIdle && time.Duration(gap)*time.Millisecond > p.idleThreshold
+}
+
+// setSkipIdle applies the change to the packet being waited for as well, so turning it on during an idle
+// period skips it right away
+func (p *camPlayer) setSkipIdle(enabled bool) {
+	p.stats.skipIdle = enabled
+	if p.pending == nil || p.stats.speed <= 0 {
+		return
+	}
+
+	now := p.clock.Now()
+	idle := p.isIdle(p.pending.Timestamp - int64(p.stats.currentTime*1000))
+
+	if skipTo := now.Add(p.idleDelay); idle && !p.stats.skippingIdle && p.pendingDue.After(skipTo) {
+		p.anchor(skipTo, float64(p.pending.Timestamp))
+		p.pendingDue = skipTo
+		p.stats.skippingIdle = true
+	} else if !idle && p.stats.skippingIdle {
+		p.anchor(now, p.stats.currentTime*1000)
+		p.pendingDue = p.dueAt(p.pending.Timestamp)
+		p.stats.skippingIdle = false
+	}
+}
+
+// schedule arms the timer for the pending packet, or stops it while paused. An armed timer is reset
+// without stopping it first, as of Go 1.23 the reset discards a tick that was not received.
+func (p *camPlayer) schedule(timer clock.Timer) {
+	switch {
+	case p.finished && !p.inLobby:
+		timer.Reset(p.clock.Until(p.finishedAt.Add(p.closeDelay)))
+	case p.finished || p.stats.speed <= 0:
+		timer.Stop()
+	default:
+		timer.Reset(p.clock.Until(p.pendingDue))
+	}
+}
+
+// sendDuePackets sends every packet already due in a single write and reads ahead the next one
+func (p *camPlayer) sendDuePackets() error {
+	if p.stats.speed <= 0 {
+		return nil
+	}
+
+	var batch [][]byte
+	now := p.clock.Now()
+
+	for !p.finished && !p.pendingDue.After(now) {
+		if p.pending != nil {
+			batch = append(batch, p.pending.Data)
+			p.stats.currentTime = float64(p.pending.Timestamp) / 1000.0
+			p.entryPackets++
+		}
+		if p.live {
+			p.stats.duration = max(p.stats.duration, p.stats.currentTime)
+		}
+
+		next, err := p.readNextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) && err != errEntryEnd && p.stillRecording() {
+				p.waitForRecording(now)
+				break
+			}
+			if errors.Is(err, io.EOF) {
+				p.finished = true
+				p.finishedAt = p.clock.Now()
+				p.pending = nil
+				break
+			}
+			return err
+		}
+
+		// the due time comes from the anchor instead of the clock, so the delay of a late write does not accumulate
+		p.stats.skippingIdle = false
+		if p.waitingLive {
+			// caught up with the recording, the packets written from now on are played followDelay after it
+			p.waitingLive = false
+			if due := p.dueAt(next.Timestamp); due.Before(now.Add(p.followDelay)) {
+				p.anchor(now.Add(p.followDelay), float64(next.Timestamp))
+			}
+		} else if p.pending == nil {
+			p.anchor(p.pendingDue, float64(next.Timestamp))
+		} else if p.isIdle(next.Timestamp - p.pending.Timestamp) {
+			p.anchor(p.pendingDue.Add(p.idleDelay), float64(next.Timestamp))
+			p.stats.skippingIdle = true
+		}
+		p.pending = &next
+		p.pendingDue = p.dueAt(next.Timestamp)
+	}
+
+	// with a pausing send queue the write blocks while the client catches up, which must not count as playback time
+	started := p.clock.Now()
+	err := protocol.SendRawDataBatch(p.client, batch)
+	if blocked := p.clock.Since(started); p.pending != nil && blocked > SEND_BLOCKED_THRESHOLD {
+		p.anchorTime = p.anchorTime.Add(blocked)
+		p.pendingDue = p.pendingDue.Add(blocked)
+	}
+
+	if err != nil || !p.finished {
+		return err
+	}
+	return p.entryEnded()
+}
+
+// stillRecording tells if the end of the current entry is only the end of what was recorded so far
+func (p *camPlayer) stillRecording() bool {
+	if !p.live {
+		return false
+	}
+	p.live = IsRecording(p.reader.Filename(), p.followTimeout, p.clock.Now())
+	return p.live
+}
+
+// waitForRecording reads the file again after FOLLOW_POLL_INTERVAL, playback stays where it is meanwhile
+func (p *camPlayer) waitForRecording(now time.Time) {
+	p.waitingLive = true
+	p.pending = nil
+	p.pendingDue = now.Add(FOLLOW_POLL_INTERVAL)
+}
+
+// readNextPacket returns the next packet sent to the client. While idle periods are skipped the pings are held
+// until the next packet shows whether the gap they are in is skipped: they are dropped with it, and played otherwise.
+func (p *camPlayer) readNextPacket() (CamPacket, error) {
+	if len(p.readAhead) > 0 {
+		next := p.readAhead[0]
+		p.readAhead = p.readAhead[1:]
+		return next, nil
+	}
+	if err := p.readAheadErr; err != nil {
+		p.readAheadErr = nil
+		return CamPacket{}, err
+	}
+
+	next, err := p.readServerPacket()
+	if err != nil || !p.stats.skipIdle || !protocol.IsPingPacket(next.Data) {
+		return next, err
+	}
+
+	last := int64(p.stats.currentTime * 1000)
+	if p.pending != nil {
+		last = p.pending.Timestamp
+	}
+
+	// without a decoder for the game packets, pings are the only ones known to carry nothing worth watching
+	pings := []CamPacket{next}
+	for {
+		next, err = p.readServerPacket()
+		if err != nil || !protocol.IsPingPacket(next.Data) {
+			break
+		}
+		pings = append(pings, next)
+	}
+
+	if err == nil && p.isIdle(next.Timestamp-last) {
+		return next, nil
+	}
+
+	if err != nil {
+		p.readAheadErr = err
+	} else {
+		pings = append(pings, next)
+	}
+	p.readAhead = pings[1:]
+	return pings[0], nil
+}
+
+// readServerPacket returns the next packet the server sent, skipping what the client sent and unparseable lines
+func (p *camPlayer) readServerPacket() (CamPacket, error) {
+	for {
+		camPacket, err := p.reader.NextPacket()
+		if err != nil {
+			if parseErr := new(ParseError); errors.As(err, &parseErr) {
+				fmt.Printf("%v", parseErr)
+				continue
 			}
+			return camPacket, err
+		}
+
+		if camPacket.Type != "<" {
+			continue
+		}
 
-			time.Sleep(poolInterval)
+		if end := p.playlist.Entries[p.current].End; end > 0 && camPacket.Timestamp > end.Milliseconds() {
+			return camPacket, errEntryEnd
 		}
+
+		return camPacket, nil
+	}
+}
+
+func (p *camPlayer) sendStatus() {
+	if p.hud {
+		protocol.SendTextMessage(p.client, p.statusFormat.Render(p.stats.State()), p.statusType)
+	}
+
+	if !p.welcomeSent {
+		p.sendWelcome()
+		p.welcomeSent = true
+	}
+}
+
+func (p *camPlayer) welcomeInfo() welcome.Info {
+	info := welcome.Info{
+		CamName:   p.fileInfo.Name,
+		Character: p.fileInfo.Character,
+		Duration:  welcome.FormatDuration(p.stats.duration),
+		Commands:  protocol.ChatCommands,
+	}
+	if !p.fileInfo.RecordedAt.IsZero() {
+		info.Date = p.fileInfo.RecordedAt.Format("2006-01-02 15:04")
+	}
+
+	return info
+}
+
+func (p *camPlayer) sendWelcome() {
+	messages, err := p.welcome.Welcome(p.welcomeInfo())
+	if err != nil {
+		fmt.Printf("Error rendering welcome for %s: %v\n", p.reader.Filename(), err)
+	}
+
+	for _, message := range messages {
+		protocol.SendTextMessage(p.client, message.Text, message.Type)
+	}
+}
+
+func (p *camPlayer) sendAnnouncement() {
+	message, err := p.welcome.Announcement(p.announcements, p.welcomeInfo())
+	p.announcements++
+
+	if err != nil {
+		fmt.Printf("Error rendering announcement for %s: %v\n", p.reader.Filename(), err)
+		return
+	}
+
+	if message.Text != "" {
+		protocol.SendTextMessage(p.client, message.Text, message.Type)
 	}
 }
@@ -0,0 +1,69 @@
+//go:build unix
+
+package cam
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type discardConn struct {
+	recordingConn
+}
+
+func (d *discardConn) Write(data []byte) (int, error) { return len(data), nil }
+
+func processCpuTime() time.Duration {
+	var usage syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// BenchmarkCamPlayer500Viewers plays one second of a cam to 500 concurrent viewers and reports the
+// process CPU time spent per second of playback per viewer
+func BenchmarkCamPlayer500Viewers(b *testing.B) {
+	const (
+		viewers        = 500
+		packets        = 200
+		packetInterval = 5 // milliseconds
+	)
+
+	var lines strings.Builder
+	for i := 0; i < packets; i++ {
+		fmt.Fprintf(&lines, "< %d 0a0102030405060708\n", 1000+i*packetInterval)
+	}
+	filePath := writeCamFile(b, lines.String())
+	playback := time.Duration(packets*packetInterval) * time.Millisecond
+
+	b.ResetTimer()
+	cpuBefore := processCpuTime()
+
+	for i := 0; i < b.N; i++ {
+		players := make([]*camPlayer, viewers)
+		for v := range players {
+			var conn net.Conn = &discardConn{}
+			players[v] = newTestPlayer(b, filePath, conn)
+			players[v].statusInterval = STATUS_INTERVAL
+		}
+
+		var wg sync.WaitGroup
+		for _, player := range players {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				player.run(context.Background())
+			}()
+		}
+		wg.Wait()
+	}
+
+	cpu := processCpuTime() - cpuBefore
+	viewerSeconds := float64(b.N) * viewers * playback.Seconds()
+	b.ReportMetric(float64(cpu.Milliseconds())/viewerSeconds, "cpu-ms/viewer-s")
+}
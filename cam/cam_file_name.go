@@ -0,0 +1,52 @@
+package cam
+
+import (
+	"go-opentibia-camplayerserver/library"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recording date in the file names written by the Gesior cam system
+const CAM_FILE_DATE_LAYOUT = "02-01-2006-15-04-05"
+
+// CamFileInfo is what can be told about a recording from its file name
+type CamFileInfo struct {
+	Name        string // file name without the cam extensions
+	Character   string
+	CharacterId int
+	RecordedAt  time.Time // zero when the name does not carry it
+}
+
+// ParseCamFileName reads the metadata of names like Character Name_123_25-10-2024-18-36-45.cam,
+// names following another convention only fill Name
+func ParseCamFileName(filePath string) CamFileInfo {
+	name := library.CamName(filePath)
+	info := CamFileInfo{Name: name}
+
+	// the character name may contain underscores itself, so the fields are taken from the right
+	dateSeparator := strings.LastIndexByte(name, '_')
+	if dateSeparator <= 0 {
+		return info
+	}
+
+	idSeparator := strings.LastIndexByte(name[:dateSeparator], '_')
+	if idSeparator <= 0 {
+		return info
+	}
+
+	recordedAt, err := time.Parse(CAM_FILE_DATE_LAYOUT, name[dateSeparator+1:])
+	if err != nil {
+		return info
+	}
+
+	characterId, err := strconv.Atoi(name[idSeparator+1 : dateSeparator])
+	if err != nil {
+		return info
+	}
+
+	info.Character = name[:idSeparator]
+	info.CharacterId = characterId
+	info.RecordedAt = recordedAt
+	return info
+}
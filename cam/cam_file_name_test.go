@@ -0,0 +1,33 @@
+package cam
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCamFileName(t *testing.T) {
+	tests := []struct {
+		filePath string
+		expected CamFileInfo
+	}{
+		{
+			"cams/Test_2_25-10-2024-18-36-45.cam",
+			CamFileInfo{Name: "Test_2_25-10-2024-18-36-45", Character: "Test", CharacterId: 2, RecordedAt: time.Date(2024, 10, 25, 18, 36, 45, 0, time.UTC)},
+		},
+		{
+			"Sir_Knight_Alot_15_01-02-2023-00-00-00.cam.gz",
+			CamFileInfo{Name: "Sir_Knight_Alot_15_01-02-2023-00-00-00", Character: "Sir_Knight_Alot", CharacterId: 15, RecordedAt: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{"otcv8 recording.cam", CamFileInfo{Name: "otcv8 recording"}},
+		{"Name_x_25-10-2024-18-36-45.cam", CamFileInfo{Name: "Name_x_25-10-2024-18-36-45"}},
+		{"_2_25-10-2024-18-36-45.cam", CamFileInfo{Name: "_2_25-10-2024-18-36-45"}},
+		{"Name_2_yesterday.cam", CamFileInfo{Name: "Name_2_yesterday"}},
+	}
+
+	for _, tt := range tests {
+		info := ParseCamFileName(tt.filePath)
+		if info != tt.expected {
+			t.Errorf("expected %+v for %s, got %+v", tt.expected, tt.filePath, info)
+		}
+	}
+}
@@ -1,14 +1,14 @@
 package cam
 
 import (
-	"compress/gzip"
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
-	"strings"
 )
 
 type ParseError struct {
@@ -20,12 +20,21 @@ func (e *ParseError) Error() string {
 }
 
 type CamFileReader struct {
-	file               *os.File
-	gzipReader         *gzip.Reader
-	fileBuffer         []byte
+	name   string
+	stream io.ReadCloser
+	// rewind opens the stream again from the start, for Reset
+	rewind func() (io.ReadCloser, error)
+	// the frames of a seekable zstd cam, which can be read from any of them
+	frames []seekFrame
+	// the file is still being written, a line without its newline at the end is not complete yet
+	follow bool
+	// the lines of a bucket point into readBuffer, which is reused once they were all parsed
+	readBuffer         []byte
+	readBufferUsed     int
+	partialLine        int // bytes of an incomplete line at the end of the used part of readBuffer
 	fileLine           int64
 	packetsBucketIndex int
-	packetsBucket      []string
+	packetsBucket      [][]byte
 }
 
 const (
@@ -36,67 +45,163 @@ const (
 
 func NewCamFileReader() *CamFileReader {
 	return &CamFileReader{
-		fileBuffer:         make([]byte, 0, FILE_READ_CHUNK_SIZE),
+		readBuffer:         make([]byte, FILE_READ_CHUNK_SIZE),
 		fileLine:           0,
 		packetsBucketIndex: 0,
 	}
 }
 
-func (c *CamFileReader) Open(filePath string) error {
-	var err error
-	c.file, err = os.Open(filePath)
-
+// Open reads the cam at a location, see OpenSource for the locations understood
+func (c *CamFileReader) Open(location string) error {
+	stream, err := OpenSource(location)
 	if err != nil {
 		fmt.Println("Error opening file:", err)
-		return fmt.Errorf("error while openning the file %s: %w", filePath, err)
+		return fmt.Errorf("error while openning the file %s: %w", location, err)
 	}
 
-	extension := filepath.Ext(filePath)
-	fmt.Printf("%s\n", extension)
-	if extension == ".gz" {
-		c.gzipReader, err = gzip.NewReader(c.file)
-		if err != nil {
-			return fmt.Errorf("error creating gzip reader: %w", err)
+	c.rewind = nil
+	if isRewindable(location) {
+		c.rewind = func() (io.ReadCloser, error) { return OpenSource(location) }
+	}
+
+	c.frames = nil
+	if stream.(*source).compression == COMPRESSION_ZSTD && isLocalFile(location) {
+		frames, err := readSeekTable(location)
+		if err != nil && !errors.Is(err, ErrNotSeekable) {
+			fmt.Printf("[CamFileReader.Open] - Reading %s from the start: %v\n", location, err)
 		}
+		c.frames = frames
 	}
 
-	c.fileLine = 1
+	c.start(location, stream)
 	return nil
 }
 
-func (c *CamFileReader) Close() {
-	if c.gzipReader != nil {
-		c.gzipReader.Close()
+// OpenReader reads the cam from reader, named name in the errors. Reset only works when reader can seek.
+func (c *CamFileReader) OpenReader(name string, reader io.Reader) error {
+	stream, err := decompress(io.NopCloser(reader))
+	if err != nil {
+		return fmt.Errorf("error while reading %s: %w", name, err)
 	}
-	if c.file != nil {
-		c.file.Close()
+
+	c.rewind = nil
+	c.frames = nil
+	if seeker, ok := reader.(io.Seeker); ok {
+		c.rewind = func() (io.ReadCloser, error) {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return decompress(io.NopCloser(reader))
+		}
+	}
+	c.start(name, stream)
+	return nil
+}
+
+func (c *CamFileReader) start(name string, stream io.ReadCloser) {
+	c.name = name
+	c.stream = stream
+	c.partialLine = 0
+	c.readBufferUsed = 0
+	c.fileLine = 1
+	c.packetsBucketIndex = 0
+	c.packetsBucket = c.packetsBucket[:0]
+}
+
+func (c *CamFileReader) Close() {
+	if c.stream != nil {
+		c.stream.Close()
+		c.stream = nil
 	}
 }
 
 func (c *CamFileReader) Reset() error {
-	// Reset the file pointer to the beginning
-	if _, err := c.file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("error seeking to start of file: %w", err)
+	if c.rewind == nil {
+		return fmt.Errorf("error resetting %s: %w", c.name, ErrNotRewindable)
 	}
 
-	// Reset the gzip reader if the file is gzip-compressed
-	if c.gzipReader != nil {
-		if err := c.gzipReader.Reset(c.file); err != nil {
-			return fmt.Errorf("error resetting gzip reader: %w", err)
+	// Read the cam again from the beginning
+	stream, err := c.rewind()
+	if err != nil {
+		return fmt.Errorf("error resetting %s: %w", c.name, err)
+	}
+	c.Close()
+	c.start(c.name, stream)
+
+	return nil
+}
+
+// SeekFrame moves a seekable zstd cam to the start of the last frame with a packet at timestamp or before it,
+// the packets before timestamp in that frame are read as well. Other cams return ErrNotSeekable.
+func (c *CamFileReader) SeekFrame(timestamp int64) error {
+	if c.frames == nil {
+		return fmt.Errorf("error seeking %s: %w", c.name, ErrNotSeekable)
+	}
+
+	// the first frame starting after timestamp, the frames are in the order of their packets
+	var searchErr error
+	after := sort.Search(len(c.frames), func(i int) bool {
+		first, err := c.firstTimestamp(i)
+		if err != nil && !errors.Is(err, io.EOF) {
+			searchErr = err
 		}
+		return err != nil || first > timestamp
+	})
+	if searchErr != nil {
+		return fmt.Errorf("error seeking %s: %w", c.name, searchErr)
 	}
 
-	// Reset fields to their initial state
-	c.fileBuffer = make([]byte, 0, FILE_READ_CHUNK_SIZE)
-	c.fileLine = 1
-	c.packetsBucketIndex = 0
-	c.packetsBucket = nil
+	return c.readFromFrame(max(after-1, 0))
+}
+
+// firstTimestamp is the timestamp of the first packet of a frame, io.EOF for an empty frame
+func (c *CamFileReader) firstTimestamp(frame int) (int64, error) {
+	if err := c.readFromFrame(frame); err != nil {
+		return 0, err
+	}
+
+	for {
+		packet, err := c.NextPacket()
+		if parseErr := new(ParseError); errors.As(err, &parseErr) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return packet.Timestamp, nil
+	}
+}
+
+// readFromFrame starts reading a seekable zstd cam at a frame
+func (c *CamFileReader) readFromFrame(frame int) error {
+	file, err := os.Open(c.name)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(c.frames[frame].compressedOffset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
 
+	stream, err := decompress(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	c.Close()
+	c.start(c.name, stream)
 	return nil
 }
 
+// Follow reads a cam still being written: at the end of the file, a line without its newline is kept until
+// the rest of it is written instead of being taken as the last line, and the packets written after an io.EOF
+// are read by the next calls
+func (c *CamFileReader) Follow() {
+	c.follow = true
+}
+
 func (c *CamFileReader) Filename() string {
-	return c.file.Name()
+	return c.name
 }
 
 func (c *CamFileReader) NextPacket() (CamPacket, error) {
@@ -112,14 +217,14 @@ func (c *CamFileReader) NextPacket() (CamPacket, error) {
 	}
 
 	rawData := c.packetsBucket[c.packetsBucketIndex]
-	camPacket, err := parseCamPacket(&rawData)
+	camPacket, err := parseCamPacket(rawData)
 	c.fileLine += 1
 	c.packetsBucketIndex += 1
 
 	if err != nil {
 		if parseErr, ok := err.(*ParseError); ok {
 			return camPacket, &ParseError{
-				fmt.Sprintf("%s on line %d in file %s; data: %s", parseErr.Message, c.fileLine-1, c.file.Name(), rawData),
+				fmt.Sprintf("%s on line %d in file %s; data: %s", parseErr.Message, c.fileLine-1, c.name, rawData),
 			}
 		}
 		return camPacket, err
@@ -129,20 +234,31 @@ func (c *CamFileReader) NextPacket() (CamPacket, error) {
 }
 
 func (c *CamFileReader) LastPacket() (CamPacket, error) {
-	var lastLine string
+	var lastLine []byte
+
+	// a seekable zstd cam only needs its last frame decompressed
+	for frame := len(c.frames) - 1; frame >= 0; frame-- {
+		if c.frames[frame].decompressedSize > 0 {
+			if err := c.readFromFrame(frame); err != nil {
+				return CamPacket{}, fmt.Errorf("error reading file: %w", err)
+			}
+			break
+		}
+	}
 
 	for {
 		lines, err := c.retrieveLines()
 		if len(lines) > 0 {
-			lastLine = lines[len(lines)-1] // Keep the last line of each read
+			// Keep the last line of each read, the next read reuses the buffer it is in
+			lastLine = append(lastLine[:0], lines[len(lines)-1]...)
 		}
 
 		if err == io.EOF {
 			// End of file, return the last line as a packet
-			if lastLine == "" {
+			if len(lastLine) == 0 {
 				return CamPacket{}, io.EOF
 			}
-			return parseCamPacket(&lastLine)
+			return parseCamPacket(lastLine)
 		}
 
 		if err != nil {
@@ -151,81 +267,140 @@ func (c *CamFileReader) LastPacket() (CamPacket, error) {
 	}
 }
 
-func (c *CamFileReader) retrieveLines() ([]string, error) {
-	var bytesRead int
-	var err error
-	var lines []string
-	rawData := make([]byte, FILE_READ_CHUNK_SIZE)
+// retrieveLines reads until at least one line is complete, or the end of the file. The lines returned are only
+// valid until the next call, which reuses the buffer they are in.
+func (c *CamFileReader) retrieveLines() ([][]byte, error) {
+	lines := c.packetsBucket[:0]
 
-	if c.gzipReader != nil {
-		bytesRead, err = c.gzipReader.Read(rawData)
-	} else {
-		bytesRead, err = c.file.Read(rawData)
-	}
+	// the lines handed out before are parsed by now, the incomplete one moves to the front of the buffer
+	copy(c.readBuffer, c.readBuffer[c.readBufferUsed-c.partialLine:c.readBufferUsed])
+	c.readBufferUsed = c.partialLine
+	lineStart := 0
 
-	if bytesRead > 0 {
-		if len(c.fileBuffer) == 0 {
-			c.fileBuffer = rawData[:bytesRead]
-		} else {
-			// Use append when fileBuffer already has data (e.g., leftover from previous read)
-			c.fileBuffer = append(c.fileBuffer, rawData[:bytesRead]...)
+	for {
+		if c.readBufferUsed == len(c.readBuffer) {
+			// a line longer than the buffer
+			grown := make([]byte, 2*len(c.readBuffer))
+			copy(grown, c.readBuffer[:c.readBufferUsed])
+			c.readBuffer = grown
 		}
 
-		var lastNewlineOffset int
-		for i := 0; i < len(c.fileBuffer); i++ {
-			if c.fileBuffer[i] == '\n' {
-				line := string(c.fileBuffer[lastNewlineOffset : i+1]) // +1 to include the '\n'
-				lines = append(lines, line)
-				lastNewlineOffset = i + 1
+		bytesRead, err := c.stream.Read(c.readBuffer[c.readBufferUsed:])
+
+		// only the bytes just read can hold a newline, the incomplete line before them has none
+		data := c.readBuffer[:c.readBufferUsed+bytesRead]
+		for offset := c.readBufferUsed; ; {
+			newline := bytes.IndexByte(data[offset:], '\n')
+			if newline < 0 {
+				break
 			}
+			offset += newline + 1 // +1 to include the '\n'
+			lines = append(lines, data[lineStart:offset])
+			lineStart = offset
 		}
+		c.readBufferUsed = len(data)
 
-		// Keep only the incomplete line (if any) in fileBuffer
-		if lastNewlineOffset < len(c.fileBuffer) {
-			c.fileBuffer = c.fileBuffer[lastNewlineOffset:]
-		} else {
-			c.fileBuffer = c.fileBuffer[:0]
+		if err == io.EOF && lineStart < len(data) && !c.follow {
+			lines = append(lines, data[lineStart:])
+			lineStart = len(data)
 		}
-	}
 
-	if err == io.EOF && len(c.fileBuffer) > 0 {
-		lines = append(lines, string(c.fileBuffer))
-		c.fileBuffer = c.fileBuffer[:0]
-	}
+		if err != nil && err != io.EOF {
+			fmt.Println("Error reading file:", err)
+		}
 
-	if err != nil && err != io.EOF {
-		fmt.Println("Error reading file:", err)
+		// a decompressor or a download may hand out less than a line at a time, so keep reading until one is complete
+		if len(lines) > 0 || err != nil {
+			c.partialLine = len(data) - lineStart
+			return lines, err
+		}
 	}
-
-	return lines, err
 }
 
-func parseCamPacket(rawData *string) (CamPacket, error) {
+// parseCamPacket parses a line in place, only the packet data is allocated
+func parseCamPacket(line []byte) (CamPacket, error) {
 	var camPacket CamPacket
-	fields := strings.Fields(*rawData)
 
-	if len(fields) < 3 {
+	packetType, rest := nextField(line)
+	timestampField, rest := nextField(rest)
+	hexField, _ := nextField(rest)
+
+	if len(hexField) == 0 {
 		return camPacket, &ParseError{"parse error: invalid data format"}
 	}
 
-	packetType := fields[0]
-	if packetType != "<" && packetType != ">" {
+	if len(packetType) != 1 || (packetType[0] != '<' && packetType[0] != '>') {
 		return camPacket, &ParseError{fmt.Sprintf("parse error: invalid packet type (%s)", packetType)}
 	}
 
-	timestamp, err := strconv.ParseInt(fields[1], 10, 64)
-	if err != nil {
-		return camPacket, &ParseError{fmt.Sprintf("parse error: invalid timestamp format: %v", err)}
+	timestamp, ok := parseTimestamp(timestampField)
+	if !ok {
+		// the longest timestamps, or an invalid one strconv tells what is wrong with
+		var err error
+		if timestamp, err = strconv.ParseInt(string(timestampField), 10, 64); err != nil {
+			return camPacket, &ParseError{fmt.Sprintf("parse error: invalid timestamp format: %v", err)}
+		}
 	}
 
-	hexString := fields[2]
-	camPacket.Data = make([]byte, len(hexString)/2)
-	if _, err := hex.Decode(camPacket.Data, []byte(hexString)); err != nil {
+	camPacket.Data = make([]byte, len(hexField)/2)
+	if _, err := hex.Decode(camPacket.Data, hexField); err != nil {
 		return camPacket, &ParseError{fmt.Sprintf("parse error: error decoding hex string: %v", err)}
 	}
 
 	camPacket.Timestamp = timestamp
-	camPacket.Type = packetType
+	if packetType[0] == '<' {
+		camPacket.Type = "<"
+	} else {
+		camPacket.Type = ">"
+	}
 
 	return camPacket, nil
 }
+
+// nextField splits off the first whitespace separated field of data
+func nextField(data []byte) (field []byte, rest []byte) {
+	start := 0
+	for start < len(data) && isSpace(data[start]) {
+		start++
+	}
+	end := start
+	for end < len(data) && !isSpace(data[end]) {
+		end++
+	}
+	return data[start:end], data[end:]
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// parseTimestamp is strconv.ParseInt for base 10 without turning the field into a string, it gives up on
+// anything unusual
+func parseTimestamp(field []byte) (int64, bool) {
+	if len(field) == 0 {
+		return 0, false
+	}
+
+	negative := field[0] == '-'
+	digits := field
+	if field[0] == '-' || field[0] == '+' {
+		digits = field[1:]
+	}
+	if len(digits) == 0 || len(digits) > 18 {
+		// longer ones may overflow
+		return 0, false
+	}
+
+	var value int64
+	for _, digit := range digits {
+		if digit < '0' || digit > '9' {
+			return 0, false
+		}
+		value = value*10 + int64(digit-'0')
+	}
+
+	if negative {
+		value = -value
+	}
+	return value, true
+}
@@ -0,0 +1,49 @@
+package cam
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// a line of a typical game packet of a cam
+var benchmarkLine = fmt.Sprintf("< 1627391000 %s\n", strings.Repeat("0a1b2c3d4e5f", 30))
+
+func BenchmarkParseCamPacket(b *testing.B) {
+	line := []byte(benchmarkLine)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(line)))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := parseCamPacket(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCamFileReader(b *testing.B) {
+	const packets = 10000
+	filePath := writeCamFile(b, strings.Repeat(benchmarkLine, packets))
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkLine) * packets))
+
+	for i := 0; i < b.N; i++ {
+		reader := NewCamFileReader()
+		if err := reader.Open(filePath); err != nil {
+			b.Fatal(err)
+		}
+
+		for {
+			_, err := reader.NextPacket()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		reader.Close()
+	}
+}
@@ -1,8 +1,13 @@
 package cam
 
 import (
+	"bytes"
 	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -118,7 +123,7 @@ func TestParseCamPacket(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			_, err := parseCamPacket(&tt.input)
+			_, err := parseCamPacket([]byte(tt.input))
 			if err != nil {
 				if !strings.Contains(err.Error(), tt.expectedErr) {
 					t.Errorf("Expected error containing %s, but got %v", tt.expectedErr, err)
@@ -129,3 +134,81 @@ func TestParseCamPacket(t *testing.T) {
 		})
 	}
 }
+
+func TestCamFileReaderLongLines(t *testing.T) {
+	// a line longer than the read buffer, between short ones split across the gzip reads
+	long := strings.Repeat("ab", FILE_READ_CHUNK_SIZE)
+	var lines strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&lines, "< %d %02x", i, i%256)
+		if i == 500 {
+			lines.WriteString(long)
+		}
+		lines.WriteString("\n")
+	}
+
+	plainFile := filepath.Join(t.TempDir(), "long.cam")
+	if err := os.WriteFile(plainFile, []byte(lines.String()), 0644); err != nil {
+		t.Fatalf("Failed to write cam file: %v", err)
+	}
+	gzipFile, err := createGzipFile(lines.String())
+	if err != nil {
+		t.Fatalf("Failed to create temp gzip file: %v", err)
+	}
+	defer os.Remove(gzipFile.Name())
+	os.Rename(gzipFile.Name(), gzipFile.Name()+".gz")
+	defer os.Remove(gzipFile.Name() + ".gz")
+
+	for _, filePath := range []string{plainFile, gzipFile.Name() + ".gz"} {
+		reader := NewCamFileReader()
+		if err := reader.Open(filePath); err != nil {
+			t.Fatalf("Expected no error opening %s, got %v", filePath, err)
+		}
+
+		for i := 0; i < 1000; i++ {
+			packet, err := reader.NextPacket()
+			if err != nil {
+				t.Fatalf("%s: expected packet %d, got %v", filePath, i, err)
+			}
+			expectedLength := 1
+			if i == 500 {
+				expectedLength += FILE_READ_CHUNK_SIZE
+			}
+			if packet.Timestamp != int64(i) || packet.Data[0] != byte(i) || len(packet.Data) != expectedLength {
+				t.Fatalf("%s: unexpected packet %d at %d with %d bytes", filePath, i, packet.Timestamp, len(packet.Data))
+			}
+		}
+
+		if _, err := reader.NextPacket(); !errors.Is(err, io.EOF) {
+			t.Errorf("%s: expected the end of the file, got %v", filePath, err)
+		}
+		reader.Close()
+	}
+}
+
+func TestParseCamPacketFields(t *testing.T) {
+	packet, err := parseCamPacket([]byte("  >\t9223372036854775807 0A0b extra\r\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if packet.Type != ">" || packet.Timestamp != 9223372036854775807 || !bytes.Equal(packet.Data, []byte{0x0a, 0x0b}) {
+		t.Errorf("Unexpected packet %+v", packet)
+	}
+
+	for _, line := range []string{"", "<", "< 10", "<< 10 aa", "< 9223372036854775808 aa", "< 1x aa", "< 10 abc"} {
+		if _, err := parseCamPacket([]byte(line)); err == nil {
+			t.Errorf("Expected an error parsing %q", line)
+		}
+	}
+}
+
+func TestParseCamPacketAllocatesOnlyTheData(t *testing.T) {
+	line := []byte("< 1627391000 48656c6c6f\n")
+	allocations := testing.AllocsPerRun(100, func() {
+		parseCamPacket(line)
+	})
+
+	if allocations != 1 {
+		t.Errorf("Expected a single allocation for the packet data, got %v", allocations)
+	}
+}
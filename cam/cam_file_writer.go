@@ -0,0 +1,128 @@
+package cam
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Compression uint8
+
+const (
+	COMPRESSION_NONE  Compression = iota // plain text lines
+	COMPRESSION_GZIP                     // a single gzip stream, read from the start every time
+	COMPRESSION_ZSTD                     // seekable zstd frames, readers can start at any of them
+	COMPRESSION_BZIP2                    // only read
+	COMPRESSION_XZ                       // only read
+)
+
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return COMPRESSION_NONE, nil
+	case "gzip", "gz":
+		return COMPRESSION_GZIP, nil
+	case "zstd", "zst":
+		return COMPRESSION_ZSTD, nil
+	case "bzip2", "bz2":
+		return COMPRESSION_BZIP2, nil
+	case "xz":
+		return COMPRESSION_XZ, nil
+	}
+	return COMPRESSION_NONE, fmt.Errorf("unknown compression %q, expected none, gzip, zstd, bzip2 or xz", name)
+}
+
+// CompressionOf tells the compression of a cam file by its name
+func CompressionOf(fileName string) Compression {
+	lower := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(lower, ".gz"):
+		return COMPRESSION_GZIP
+	case strings.HasSuffix(lower, ".zst"):
+		return COMPRESSION_ZSTD
+	case strings.HasSuffix(lower, ".bz2"):
+		return COMPRESSION_BZIP2
+	case strings.HasSuffix(lower, ".xz"):
+		return COMPRESSION_XZ
+	}
+	return COMPRESSION_NONE
+}
+
+// CamFileWriter writes packets as the lines of a cam file, the same lines CamFileReader reads
+type CamFileWriter struct {
+	file       io.Closer // closed with the writer when it was created by CreateCamFile
+	buffered   *bufio.Writer
+	compressor io.WriteCloser
+	line       []byte
+}
+
+// CreateCamFile creates a cam file, CompressionOf tells the compression its name stands for
+func CreateCamFile(filePath string, compression Compression) (*CamFileWriter, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cam file %s: %w", filePath, err)
+	}
+
+	writer, err := NewCamFileWriter(file, compression)
+	if err != nil {
+		file.Close()
+		os.Remove(filePath)
+		return nil, err
+	}
+	writer.file = file
+	return writer, nil
+}
+
+func NewCamFileWriter(writer io.Writer, compression Compression) (*CamFileWriter, error) {
+	c := &CamFileWriter{buffered: bufio.NewWriterSize(writer, FILE_READ_CHUNK_SIZE)}
+
+	switch compression {
+	case COMPRESSION_GZIP:
+		c.compressor = gzip.NewWriter(c.buffered)
+	case COMPRESSION_ZSTD:
+		compressor, err := NewSeekableZstdWriter(c.buffered, SEEKABLE_FRAME_SIZE)
+		if err != nil {
+			return nil, fmt.Errorf("error creating zstd writer: %w", err)
+		}
+		c.compressor = compressor
+	case COMPRESSION_BZIP2, COMPRESSION_XZ:
+		return nil, errors.New("cams can be read from bzip2 and xz but not written to them")
+	}
+	return c, nil
+}
+
+func (c *CamFileWriter) WritePacket(packet CamPacket) error {
+	c.line = append(c.line[:0], packet.Type...)
+	c.line = append(c.line, ' ')
+	c.line = strconv.AppendInt(c.line, packet.Timestamp, 10)
+	c.line = append(c.line, ' ')
+	c.line = hex.AppendEncode(c.line, packet.Data)
+	c.line = append(c.line, '\n')
+
+	var err error
+	if c.compressor != nil {
+		_, err = c.compressor.Write(c.line)
+	} else {
+		_, err = c.buffered.Write(c.line)
+	}
+	return err
+}
+
+// Close finishes the compression and flushes the cam
+func (c *CamFileWriter) Close() error {
+	var err error
+	if c.compressor != nil {
+		err = c.compressor.Close()
+	}
+	err = errors.Join(err, c.buffered.Flush())
+	if c.file != nil {
+		err = errors.Join(err, c.file.Close())
+	}
+	return err
+}
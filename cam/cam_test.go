@@ -1,6 +1,22 @@
 package cam
 
-import "testing"
+import (
+	"encoding/binary"
+	"fmt"
+	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/clock"
+	"go-opentibia-camplayerserver/command"
+	"go-opentibia-camplayerserver/library"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestFormat(t *testing.T) {
 
@@ -66,3 +82,396 @@ func TestDecreaseSpeed(t *testing.T) {
 	}
 
 }
+
+// recordingConn counts the frames of every write by walking their length headers
+type recordingConn struct {
+	mutex  sync.Mutex
+	writes []int
+}
+
+func (r *recordingConn) Write(data []byte) (int, error) {
+	frames := 0
+	for offset := 0; offset+2 <= len(data); frames++ {
+		offset += 2 + int(binary.LittleEndian.Uint16(data[offset:]))
+	}
+
+	r.mutex.Lock()
+	r.writes = append(r.writes, frames)
+	r.mutex.Unlock()
+
+	return len(data), nil
+}
+
+func (r *recordingConn) Read(b []byte) (int, error)         { return 0, io.EOF }
+func (r *recordingConn) Close() error                       { return nil }
+func (r *recordingConn) LocalAddr() net.Addr                { return nil }
+func (r *recordingConn) RemoteAddr() net.Addr               { return nil }
+func (r *recordingConn) SetDeadline(t time.Time) error      { return nil }
+func (r *recordingConn) SetReadDeadline(t time.Time) error  { return nil }
+func (r *recordingConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func writeCamFile(t testing.TB, lines string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), "test.cam")
+	if err := os.WriteFile(filePath, []byte(lines), 0644); err != nil {
+		t.Fatalf("failed to write cam file: %v", err)
+	}
+	return filePath
+}
+
+func newTestPlayer(t testing.TB, filePath string, conn net.Conn) *camPlayer {
+	t.Helper()
+	return newTestPlaylistPlayer(t, library.Single(filePath), conn, PlayerOptions{})
+}
+
+func newTestPlaylistPlayer(t testing.TB, playlist library.Playlist, conn net.Conn, options PlayerOptions) *camPlayer {
+	t.Helper()
+
+	c := &client.Client{
+		Conn:      conn,
+		XteaKey:   [4]uint32{1, 2, 3, 4},
+		CommandCh: make(chan command.Command),
+	}
+
+	player := newCamPlayer(c, playlist, options)
+	player.closeDelay = 0
+	player.statusInterval = time.Hour
+	t.Cleanup(player.close)
+
+	if err := player.openEntry(playlist.Current); err != nil {
+		t.Fatalf("failed to open cam file: %v", err)
+	}
+	return player
+}
+
+func TestCamPlayerBatchesDuePackets(t *testing.T) {
+	filePath := writeCamFile(t, "< 1000 0a01\n< 1000 0a02\n> 1010 0b01\n< 1000 0a03\n< 1050 0a04\n< 1100 0a05\n")
+	conn := &recordingConn{}
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, library.Single(filePath), conn, PlayerOptions{Clock: fakeClock})
+
+	start := fakeClock.Now()
+	for !player.finished {
+		if err := player.sendDuePackets(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !player.finished {
+			fakeClock.Set(player.pendingDue)
+		}
+	}
+
+	if frames := recordedFrames(conn); !slices.Equal(frames, []int{3, 1, 1}) {
+		t.Errorf("expected the packets due together in a single write, got %v", frames)
+	}
+
+	// finished right after the last packet, 100ms after the first ones
+	if elapsed := player.finishedAt.Sub(start); elapsed != 100*time.Millisecond {
+		t.Errorf("expected playback to finish with the last packet, took %s", elapsed)
+	}
+}
+
+func TestCamPlayerPauseHoldsPackets(t *testing.T) {
+	filePath := writeCamFile(t, "< 1000 0a01\n< 1020 0a02\n")
+	conn := &recordingConn{}
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, library.Single(filePath), conn, PlayerOptions{Clock: fakeClock})
+
+	if err := player.sendDuePackets(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	player.handleCommand(command.Pause{})
+	fakeClock.Advance(time.Hour)
+	if err := player.sendDuePackets(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if frames := totalFrames(conn); frames != 1 {
+		t.Errorf("expected only the first packet before pausing, got %d frames", frames)
+	}
+
+	player.handleCommand(command.SpeedUp{})
+	if wait := fakeClock.Until(player.pendingDue); wait != 20*time.Millisecond {
+		t.Errorf("expected the second packet 20ms after resuming, got %s", wait)
+	}
+
+	fakeClock.Set(player.pendingDue)
+	if err := player.sendDuePackets(); err != nil || !player.finished || totalFrames(conn) != 2 {
+		t.Errorf("expected playback to resume and finish after unpausing, got %v", err)
+	}
+}
+
+func recordedFrames(conn *recordingConn) []int {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return slices.Clone(conn.writes)
+}
+
+func TestCamPlayerSeek(t *testing.T) {
+	filePath := writeCamFile(t, "< 0 0a01\n< 1000 0a02\n> 1500 0b01\n< 2000 0a03\n< 3000 0a04\n")
+	conn := &recordingConn{}
+	player := newTestPlayer(t, filePath, conn)
+
+	forward := command.Seek{Replies: command.NewReplies(), Delta: 2500 * time.Millisecond}
+	if !player.handleCommand(forward) {
+		t.Fatal("expected the session to go on after seeking")
+	}
+
+	reply := <-forward.ReplyCh
+	if reply.Err != nil || reply.Message != "Moved to 2.5." {
+		t.Errorf("unexpected reply to seeking forward: %+v", reply)
+	}
+
+	if frames := recordedFrames(conn); len(frames) != 1 || frames[0] != 3 {
+		t.Errorf("expected the skipped packets in a single write, got %v", frames)
+	}
+
+	if player.pending == nil || player.pending.Timestamp != 3000 {
+		t.Fatalf("expected the packet after the target to be pending, got %+v", player.pending)
+	}
+
+	if wait := time.Until(player.pendingDue); wait < 400*time.Millisecond || wait > 500*time.Millisecond {
+		t.Errorf("expected the pending packet to be due in 500ms, got %s", wait)
+	}
+
+	// going back replays the cam from its beginning
+	backward := command.Seek{Replies: command.NewReplies(), Delta: -2 * time.Second}
+	player.handleCommand(backward)
+	<-backward.ReplyCh
+
+	if frames := recordedFrames(conn); len(frames) != 2 || frames[1] != 1 {
+		t.Errorf("expected the first packet to be replayed, got %v", frames)
+	}
+
+	if player.stats.currentTime != 0.5 || player.pending.Timestamp != 1000 {
+		t.Errorf("expected playback at 0.5 with the second packet pending, got %.1f and %d", player.stats.currentTime, player.pending.Timestamp)
+	}
+}
+
+func TestCamPlayerSeekPastTheEnd(t *testing.T) {
+	filePath := writeCamFile(t, "< 0 0a01\n< 1000 0a02\n")
+	player := newTestPlayer(t, filePath, &recordingConn{})
+
+	player.handleCommand(command.Seek{Delta: time.Minute})
+
+	if !player.finished {
+		t.Error("expected playback to be finished")
+	}
+}
+
+func TestCamPlayerSetSpeed(t *testing.T) {
+	player := newTestPlayer(t, writeCamFile(t, "< 0 0a01\n"), &recordingConn{})
+
+	valid := command.SetSpeed{Replies: command.NewReplies(), Speed: 1.5}
+	player.handleCommand(valid)
+
+	if reply := <-valid.ReplyCh; reply.Err != nil || player.stats.speed != 1.5 {
+		t.Errorf("expected speed 1.5, got %.2f and reply %+v", player.stats.speed, reply)
+	}
+
+	tooFast := command.SetSpeed{Replies: command.NewReplies(), Speed: MAXIMUM_PLAY_SPEED * 2}
+	player.handleCommand(tooFast)
+
+	if reply := <-tooFast.ReplyCh; reply.Err == nil || player.stats.speed != 1.5 {
+		t.Errorf("expected an out of range speed to be refused, got %.2f and reply %+v", player.stats.speed, reply)
+	}
+}
+
+func TestCamPlayerHudToggle(t *testing.T) {
+	conn := &recordingConn{}
+	player := newTestPlayer(t, writeCamFile(t, "< 0 0a01\n"), conn)
+	player.welcomeSent = true
+
+	hide := command.SetHud{Replies: command.NewReplies(), Visible: false}
+	player.handleCommand(hide)
+	<-hide.ReplyCh
+
+	player.sendStatus()
+	if frames := recordedFrames(conn); len(frames) != 0 {
+		t.Errorf("expected no status line while the hud is off, got %v", frames)
+	}
+
+	player.handleCommand(command.SetHud{Visible: true})
+
+	player.sendStatus()
+	if frames := recordedFrames(conn); len(frames) != 1 {
+		t.Errorf("expected the status line once the hud is back on, got %v", frames)
+	}
+}
+
+func TestCamPlayerSkipsIdlePeriods(t *testing.T) {
+	// an hour of nothing but pings between the two packets
+	filePath := writeCamFile(t, "< 0 0a01\n< 1000 1e\n< 1800000 1e\n< 3600000 0a02\n")
+	conn := &recordingConn{}
+
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, library.Single(filePath), conn, PlayerOptions{
+		SkipIdle:  true,
+		IdleDelay: 50 * time.Millisecond,
+		Clock:     fakeClock,
+	})
+
+	if err := player.sendDuePackets(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if wait := fakeClock.Until(player.pendingDue); wait != 50*time.Millisecond || !player.stats.skippingIdle {
+		t.Fatalf("expected the idle period to last the idle delay, got %s", wait)
+	}
+
+	fakeClock.Set(player.pendingDue)
+	if err := player.sendDuePackets(); err != nil || !player.finished {
+		t.Fatalf("expected playback to be finished, got %v", err)
+	}
+
+	// the pings are left out
+	if frames := totalFrames(conn); frames != 2 {
+		t.Errorf("expected 2 frames, got %d", frames)
+	}
+}
+
+func TestCamPlayerKeepsThePingsOutsideIdlePeriods(t *testing.T) {
+	// the pings between the packets are played, and so are the ones after the last packet
+	filePath := writeCamFile(t, "< 0 0a01\n< 1000 1e\n< 2000 1e\n< 3000 0a02\n< 4000 1e\n")
+	conn := &recordingConn{}
+
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, library.Single(filePath), conn, PlayerOptions{
+		SkipIdle: true,
+		Clock:    fakeClock,
+	})
+
+	if !playFor(t, player, fakeClock, 5*time.Second) {
+		t.Fatal("expected playback to be finished")
+	}
+
+	if player.stats.currentTime != 4 {
+		t.Errorf("expected the last ping at 4.0, got %.1f", player.stats.currentTime)
+	}
+	if frames := totalFrames(conn); frames != 5 {
+		t.Errorf("expected 5 frames, got %d", frames)
+	}
+}
+
+func TestCamPlayerSkipIdleToggle(t *testing.T) {
+	filePath := writeCamFile(t, "< 0 0a01\n< 60000 0a02\n")
+	conn := &recordingConn{}
+	player := newTestPlayer(t, filePath, conn)
+
+	if err := player.sendDuePackets(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if wait := time.Until(player.pendingDue); wait < 59*time.Second || player.stats.skippingIdle {
+		t.Fatalf("expected to wait the whole minute, got %s", wait)
+	}
+
+	// turning it on in the middle of the idle period skips the rest of it
+	enable := command.SetSkipIdle{Replies: command.NewReplies(), Enabled: true}
+	player.handleCommand(enable)
+	<-enable.ReplyCh
+
+	if wait := time.Until(player.pendingDue); wait > DEFAULT_IDLE_DELAY || !player.stats.skippingIdle {
+		t.Errorf("expected the idle period to be cut down, still waiting %s", wait)
+	}
+
+	if status := player.stats.Format(); status != "0.0/60.0 | Speed: 1.00x, skipping idle" {
+		t.Errorf("expected the status line to tell the idle period is skipped, got %q", status)
+	}
+
+	player.handleCommand(command.SetSkipIdle{Enabled: false})
+
+	if wait := time.Until(player.pendingDue); wait < 59*time.Second || player.stats.skippingIdle {
+		t.Errorf("expected to wait for the whole gap again, got %s", wait)
+	}
+}
+
+func TestCamPlayerFractionalSpeedDoesNotDrift(t *testing.T) {
+	// packets 7ms apart over an hour, no delay between them divides evenly at 1.7x
+	var lines strings.Builder
+	lastTimestamp := 0
+	for timestamp := 0; timestamp <= 3600000; timestamp += 7 {
+		fmt.Fprintf(&lines, "< %d 0a01\n", timestamp)
+		lastTimestamp = timestamp
+	}
+
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, library.Single(writeCamFile(t, lines.String())), &recordingConn{}, PlayerOptions{Clock: fakeClock})
+
+	speed := command.SetSpeed{Replies: command.NewReplies(), Speed: 1.7}
+	player.handleCommand(speed)
+	<-speed.ReplyCh
+
+	start := fakeClock.Now()
+	for !player.finished {
+		if err := player.sendDuePackets(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if player.pending == nil {
+			continue
+		}
+
+		expected := start.Add(time.Duration(float64(player.pending.Timestamp) * float64(time.Millisecond) / 1.7))
+		if drift := player.pendingDue.Sub(expected); drift < -time.Nanosecond || drift > time.Nanosecond {
+			t.Fatalf("packet at %dms is due %s off", player.pending.Timestamp, drift)
+		}
+		fakeClock.Set(player.pendingDue)
+	}
+
+	if elapsed, expected := fakeClock.Since(start), time.Duration(float64(lastTimestamp)*float64(time.Millisecond)/1.7); elapsed != expected {
+		t.Errorf("expected playback to take %s, took %s", expected, elapsed)
+	}
+}
+
+func TestCamPlayerSpeedChangeKeepsPosition(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	filePath := writeCamFile(t, "< 0 0a01\n< 10000 0a02\n")
+	player := newTestPlaylistPlayer(t, library.Single(filePath), &recordingConn{}, PlayerOptions{Clock: fakeClock})
+
+	if err := player.sendDuePackets(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// 4 of the 10 seconds played, the other 6 take 3 at double speed
+	fakeClock.Advance(4 * time.Second)
+	double := command.SetSpeed{Replies: command.NewReplies(), Speed: 2}
+	player.handleCommand(double)
+	<-double.ReplyCh
+
+	if wait := fakeClock.Until(player.pendingDue); wait != 3*time.Second {
+		t.Errorf("expected the packet to be due in 3s, got %s", wait)
+	}
+
+	// paused at 6 seconds, however long the pause lasts 4 seconds are left on resuming
+	fakeClock.Advance(time.Second)
+	player.handleCommand(command.Pause{})
+	fakeClock.Advance(time.Hour)
+	player.handleCommand(command.SpeedUp{})
+
+	if wait := fakeClock.Until(player.pendingDue); wait != 4*time.Second {
+		t.Errorf("expected the packet to be due in 4s after resuming, got %s", wait)
+	}
+}
+
+func TestCamPlayerConfiguredSpeeds(t *testing.T) {
+	speeds, err := NewSpeedControl(0.5, 3, []float64{0.5, 1, 1.5, 3})
+	if err != nil {
+		t.Fatalf("expected a valid speed control, got %v", err)
+	}
+
+	player := newTestPlaylistPlayer(t, library.Single(writeCamFile(t, "< 0 0a01\n")), &recordingConn{}, PlayerOptions{Speed: speeds})
+
+	player.handleCommand(command.SpeedUp{})
+	if player.stats.speed != 1.5 {
+		t.Errorf("expected speed up to go to the next step 1.5, got %.2f", player.stats.speed)
+	}
+
+	tooFast := command.SetSpeed{Replies: command.NewReplies(), Speed: 4}
+	player.handleCommand(tooFast)
+
+	if reply := <-tooFast.ReplyCh; reply.Err == nil || reply.Err.Error() != "Speed must be between 0.5x and 3x." {
+		t.Errorf("expected the configured bounds in the refusal, got %+v", reply)
+	}
+}
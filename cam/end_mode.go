@@ -0,0 +1,152 @@
+package cam
+
+import (
+	"fmt"
+	"go-opentibia-camplayerserver/protocol"
+	"strings"
+)
+
+// EndMode decides what happens once a cam has been played to its end
+type EndMode uint8
+
+const (
+	END_CLOSE EndMode = iota // close the connection after END_OF_FILE_CLOSE_DELAY
+	END_LOOP                 // play the same cam again
+	END_NEXT                 // play the next cam of the playlist, closing after the last one
+	END_LOBBY                // keep the viewer connected with a message until they log out or seek back
+)
+
+const DEFAULT_LOBBY_MESSAGE = "The cam has ended. Seek back to watch it again or log out to pick another one."
+
+func ParseEndMode(mode string) (EndMode, error) {
+	switch strings.ToLower(mode) {
+	case "", "close":
+		return END_CLOSE, nil
+	case "loop":
+		return END_LOOP, nil
+	case "next":
+		return END_NEXT, nil
+	case "lobby":
+		return END_LOBBY, nil
+	}
+	return END_CLOSE, fmt.Errorf("unknown end of cam mode %q, expected close, loop, next or lobby", mode)
+}
+
+// openEntry switches playback to the playlist entry, starting at its start time
+func (p *camPlayer) openEntry(index int) error {
+	entry := p.playlist.Entries[index]
+
+	live := p.follow && IsRecording(entry.Path, p.followTimeout, p.clock.Now())
+	reader, err := p.openReader(entry.Path, live)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+
+	// the duration comes from the last packet, the cam is then played from the start
+	lastPacket, lastErr := reader.LastPacket()
+	if err := reader.Reset(); err != nil {
+		reader.Close()
+		return err
+	}
+
+	// an entry with nothing to play after its start would end as soon as it is opened, and loop without end
+	if start := entry.Start.Milliseconds(); start > 0 && !live {
+		if lastErr != nil || start >= lastPacket.Timestamp || (entry.End > 0 && entry.End <= entry.Start) {
+			reader.Close()
+			return fmt.Errorf("entry starts at %s, past the end of the cam", entry.Start)
+		}
+	}
+
+	p.close()
+	p.reader = reader
+	p.current = index
+	p.entryPackets = 0
+	p.pending = nil
+	p.readAhead = nil
+	p.readAheadErr = nil
+	p.pendingDue = p.clock.Now()
+	p.finished = false
+	p.inLobby = false
+	p.live = live
+	p.waitingLive = false
+
+	p.fileInfo = ParseCamFileName(entry.Path)
+	p.stats.recordedAt = p.fileInfo.RecordedAt
+	p.stats.currentTime = 0
+	p.stats.duration = 0
+	p.stats.skippingIdle = false
+
+	if lastErr == nil {
+		p.stats.duration = float64(lastPacket.Timestamp) / 1000.0
+	}
+	if end := entry.End.Seconds(); entry.End > 0 && (p.stats.duration == 0 || end < p.stats.duration) {
+		p.stats.duration = end
+	}
+
+	if entry.Start > 0 {
+		return p.seek(entry.Start.Milliseconds())
+	}
+	return nil
+}
+
+// openReader reads the compiled copy of the cam file when there is a cache, and the file itself otherwise. A cam
+// still being recorded is always read from the file, as it grows.
+func (p *camPlayer) openReader(filePath string, live bool) (PacketReader, error) {
+	if p.cache != nil && !live {
+		reader, err := p.cache.Open(filePath)
+		if err == nil {
+			return reader, nil
+		}
+		fmt.Printf("[openReader] - Reading %s without the cache: %v\n", filePath, err)
+	}
+
+	reader := NewCamFileReader()
+	if err := reader.Open(filePath); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if live {
+		reader.Follow()
+	}
+	return reader, nil
+}
+
+func (p *camPlayer) close() {
+	if p.reader != nil {
+		p.reader.Close()
+		p.reader = nil
+	}
+}
+
+// entryEnded moves on according to the end mode once the current entry has been played
+func (p *camPlayer) entryEnded() error {
+	switch p.onEnd {
+
+	case END_LOOP:
+		// an entry that did not get past its start, like a cam without packets or with all of them at the same
+		// time, would loop forever without ever waiting
+		if p.entryPackets > 0 && p.stats.currentTime > p.playlist.Entries[p.current].Start.Seconds() {
+			fmt.Printf("Looping cam file %s\n", p.reader.Filename())
+			return p.openEntry(p.current)
+		}
+
+	case END_NEXT:
+		for next := p.current + 1; next < len(p.playlist.Entries); next++ {
+			if err := p.openEntry(next); err != nil {
+				fmt.Printf("Skipping playlist %s entry %d: %v\n", p.playlist.Name, next+1, err)
+				continue
+			}
+
+			protocol.SendTextMessage(p.client, fmt.Sprintf("Now playing %s.", p.fileInfo.Name), protocol.MESSAGE_STATUS_CONSOLE_BLUE)
+			return nil
+		}
+
+	case END_LOBBY:
+		p.inLobby = true
+		protocol.SendTextMessage(p.client, p.lobbyMessage, protocol.MESSAGE_STATUS_CONSOLE_BLUE)
+		return nil
+	}
+
+	fmt.Printf("Finished to play cam file %s, closing Connection in few seconds\n", p.reader.Filename())
+	return nil
+}
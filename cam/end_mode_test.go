@@ -0,0 +1,248 @@
+package cam
+
+import (
+	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/clock"
+	"go-opentibia-camplayerserver/library"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePlaylistCams(t *testing.T, contents ...string) library.Playlist {
+	t.Helper()
+
+	dir := t.TempDir()
+	playlist := library.Playlist{Name: "test"}
+	for i, content := range contents {
+		filePath := filepath.Join(dir, string(rune('a'+i))+".cam")
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write cam file: %v", err)
+		}
+		playlist.Entries = append(playlist.Entries, library.Entry{Path: filePath})
+	}
+	return playlist
+}
+
+func totalFrames(conn *recordingConn) int {
+	total := 0
+	for _, frames := range recordedFrames(conn) {
+		total += frames
+	}
+	return total
+}
+
+// playFor moves the fake clock of the player from one due packet to the next, for at most limit, and tells if the
+// session ended meanwhile
+func playFor(t testing.TB, player *camPlayer, fakeClock *clock.Fake, limit time.Duration) bool {
+	t.Helper()
+
+	deadline := fakeClock.Now().Add(limit)
+	for {
+		if err := player.sendDuePackets(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if player.finished {
+			// the lobby keeps the session going until the viewer leaves
+			return !player.inLobby
+		}
+		if player.pendingDue.After(deadline) {
+			return false
+		}
+		if player.pendingDue.After(fakeClock.Now()) {
+			fakeClock.Set(player.pendingDue)
+		}
+	}
+}
+
+func TestEndModeNextPlaysTheWholePlaylist(t *testing.T) {
+	playlist := writePlaylistCams(t, "< 0 0a01\n< 10 0a02\n", "< 0 0a03\n", "< 0 0a04\n")
+	playlist.Current = 1
+
+	conn := &recordingConn{}
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, playlist, conn, PlayerOptions{OnEnd: END_NEXT, Clock: fakeClock})
+
+	if !playFor(t, player, fakeClock, time.Second) {
+		t.Fatal("expected playback to end after the last cam")
+	}
+
+	// one packet of each cam from the second on, and the "now playing" message between them
+	if frames := totalFrames(conn); frames != 3 {
+		t.Errorf("expected 3 frames, got %d", frames)
+	}
+
+	if player.current != 2 {
+		t.Errorf("expected to finish on the last entry, got %d", player.current)
+	}
+}
+
+func TestEndModeNextSkipsMissingCams(t *testing.T) {
+	playlist := writePlaylistCams(t, "< 0 0a01\n", "< 0 0a02\n")
+	playlist.Entries = append(playlist.Entries[:1], append([]library.Entry{{Path: "missing.cam"}}, playlist.Entries[1:]...)...)
+
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, playlist, &recordingConn{}, PlayerOptions{OnEnd: END_NEXT, Clock: fakeClock})
+	playFor(t, player, fakeClock, time.Second)
+
+	if player.current != 2 {
+		t.Errorf("expected to skip to the last entry, got %d", player.current)
+	}
+}
+
+func TestEndModeLoop(t *testing.T) {
+	playlist := writePlaylistCams(t, "< 0 0a01\n< 20 0a02\n")
+
+	conn := &recordingConn{}
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, playlist, conn, PlayerOptions{OnEnd: END_LOOP, Clock: fakeClock})
+
+	if playFor(t, player, fakeClock, 100*time.Millisecond) {
+		t.Fatal("expected a looping cam to play until cancelled")
+	}
+
+	if frames := totalFrames(conn); frames < 4 {
+		t.Errorf("expected the cam to be played more than once, got %d frames", frames)
+	}
+}
+
+func TestEndModeLoopGivesUpOnEmptyCams(t *testing.T) {
+	playlist := writePlaylistCams(t, "> 0 0b01\n")
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, playlist, &recordingConn{}, PlayerOptions{OnEnd: END_LOOP, Clock: fakeClock})
+
+	if !playFor(t, player, fakeClock, time.Second) {
+		t.Error("expected a cam without packets to close instead of looping")
+	}
+}
+
+func TestEndModeLoopGivesUpOnCamsWithoutDuration(t *testing.T) {
+	playlist := writePlaylistCams(t, "< 0 0a01\n< 0 0a02\n")
+	conn := &recordingConn{}
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, playlist, conn, PlayerOptions{OnEnd: END_LOOP, Clock: fakeClock})
+
+	if !playFor(t, player, fakeClock, time.Second) {
+		t.Error("expected a cam with all its packets at the same time to close instead of looping")
+	}
+	if frames := totalFrames(conn); frames != 2 {
+		t.Errorf("expected the cam to be played once, got %d frames", frames)
+	}
+}
+
+func TestEndModeLobby(t *testing.T) {
+	playlist := writePlaylistCams(t, "< 0 0a01\n")
+
+	conn := &recordingConn{}
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, playlist, conn, PlayerOptions{OnEnd: END_LOBBY, Clock: fakeClock})
+
+	if playFor(t, player, fakeClock, time.Hour) {
+		t.Fatal("expected the viewer to stay in the lobby")
+	}
+
+	if !player.inLobby {
+		t.Error("expected the player to be in the lobby")
+	}
+
+	// the packet and the lobby message
+	if frames := totalFrames(conn); frames != 2 {
+		t.Errorf("expected 2 frames, got %d", frames)
+	}
+}
+
+func TestEntryStartAndEnd(t *testing.T) {
+	playlist := writePlaylistCams(t, "< 0 0a01\n< 1000 0a02\n< 2000 0a03\n< 3000 0a04\n< 4000 0a05\n")
+	playlist.Entries[0].Start = 1500 * time.Millisecond
+	playlist.Entries[0].End = 3 * time.Second
+
+	conn := &recordingConn{}
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, playlist, conn, PlayerOptions{Clock: fakeClock})
+
+	// the packets before the start are sent right away to build the game state
+	if frames := totalFrames(conn); frames != 2 {
+		t.Errorf("expected 2 frames sent when opening, got %d", frames)
+	}
+
+	if player.stats.duration != 3 || player.stats.currentTime != 1.5 {
+		t.Errorf("expected to be at 1.5 of 3, got %.1f of %.1f", player.stats.currentTime, player.stats.duration)
+	}
+
+	if !playFor(t, player, fakeClock, 2*time.Second) {
+		t.Fatal("expected playback to end at the end time")
+	}
+
+	if frames := totalFrames(conn); frames != 4 {
+		t.Errorf("expected the packets up to 3000 only, got %d frames", frames)
+	}
+}
+
+func TestOpenEntryNeedsToRewind(t *testing.T) {
+	// the cam can be downloaded once only
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		if downloads > 1 {
+			http.Error(w, "gone", http.StatusGone)
+			return
+		}
+		w.Write([]byte("< 0 0a01\n< 10 0a02\n"))
+	}))
+	defer server.Close()
+
+	playlist := library.Playlist{Entries: []library.Entry{{Path: server.URL + "/test.cam"}}}
+	player := newCamPlayer(&client.Client{Conn: &recordingConn{}}, playlist, PlayerOptions{})
+
+	if err := player.openEntry(0); err == nil || player.reader != nil {
+		t.Errorf("expected a cam read to its end and not rewound not to be played, got %v", err)
+	}
+}
+
+func TestOpenEntryStartingPastTheEnd(t *testing.T) {
+	playlist := writePlaylistCams(t, "< 0 0a01\n< 1000 0a02\n")
+	player := newCamPlayer(&client.Client{Conn: &recordingConn{}}, playlist, PlayerOptions{OnEnd: END_LOOP})
+	defer player.close()
+
+	// seeking to the start would end the entry, and looping would open it again
+	for _, start := range []time.Duration{time.Second, time.Minute} {
+		player.playlist.Entries[0].Start = start
+		if err := player.openEntry(0); err == nil || player.reader != nil {
+			t.Errorf("expected an entry starting at %s not to be played, got %v", start, err)
+		}
+	}
+
+	player.playlist.Entries[0].Start = 500 * time.Millisecond
+	player.playlist.Entries[0].End = 500 * time.Millisecond
+	if err := player.openEntry(0); err == nil {
+		t.Error("expected an entry ending at its start not to be played")
+	}
+}
+
+func TestParseEndMode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected EndMode
+		valid    bool
+	}{
+		{"", END_CLOSE, true},
+		{"close", END_CLOSE, true},
+		{"Loop", END_LOOP, true},
+		{"next", END_NEXT, true},
+		{"lobby", END_LOBBY, true},
+		{"shuffle", END_CLOSE, false},
+	}
+
+	for _, tt := range tests {
+		mode, err := ParseEndMode(tt.input)
+		if (err == nil) != tt.valid {
+			t.Errorf("unexpected error for %q: %v", tt.input, err)
+		}
+		if mode != tt.expected {
+			t.Errorf("expected mode %d for %q, got %d", tt.expected, tt.input, mode)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package cam
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	// a recording system may mark the cam it is writing with an empty file of the same name and this extension
+	LOCK_MARKER_EXTENSION = ".lock"
+
+	// how often a followed cam is read again once every packet written so far was played
+	FOLLOW_POLL_INTERVAL = 250 * time.Millisecond
+	DEFAULT_FOLLOW_DELAY = 2 * time.Second
+)
+
+// IsRecording tells if a cam file is still being written: it has a lock marker, or it was written to within
+// timeout of now
+func IsRecording(filePath string, timeout time.Duration, now time.Time) bool {
+	if _, err := os.Stat(filePath + LOCK_MARKER_EXTENSION); err == nil {
+		return true
+	}
+
+	info, err := os.Stat(filePath)
+	return err == nil && now.Sub(info.ModTime()) < timeout
+}
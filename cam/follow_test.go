@@ -0,0 +1,159 @@
+package cam
+
+import (
+	"errors"
+	"go-opentibia-camplayerserver/clock"
+	"go-opentibia-camplayerserver/command"
+	"go-opentibia-camplayerserver/library"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func appendToCam(t *testing.T, filePath string, lines string) {
+	t.Helper()
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open cam file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(lines); err != nil {
+		t.Fatalf("failed to append to cam file: %v", err)
+	}
+}
+
+func TestCamFileReaderFollowKeepsPartialLines(t *testing.T) {
+	filePath := writeCamFile(t, "< 0 0a01\n< 10 0a")
+
+	reader := NewCamFileReader()
+	if err := reader.Open(filePath); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer reader.Close()
+	reader.Follow()
+
+	if packet, err := reader.NextPacket(); err != nil || packet.Timestamp != 0 {
+		t.Fatalf("expected the first packet, got %v and %v", packet, err)
+	}
+	if _, err := reader.NextPacket(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the line being written to be left for later, got %v", err)
+	}
+
+	appendToCam(t, filePath, "02\n< 20 0a03\n")
+
+	for _, expected := range []int64{10, 20} {
+		if packet, err := reader.NextPacket(); err != nil || packet.Timestamp != expected {
+			t.Errorf("expected the packet at %d once written, got %v and %v", expected, packet, err)
+		}
+	}
+	if _, err := reader.NextPacket(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the end of what was written, got %v", err)
+	}
+}
+
+func TestIsRecording(t *testing.T) {
+	filePath := writeCamFile(t, "< 0 0a01\n")
+	now := time.Now()
+
+	if !IsRecording(filePath, time.Minute, now) {
+		t.Error("expected a cam written just now to be recording")
+	}
+
+	old := now.Add(-time.Hour)
+	os.Chtimes(filePath, old, old)
+	if IsRecording(filePath, time.Minute, now) {
+		t.Error("expected a cam not written to for an hour to be finished")
+	}
+
+	os.WriteFile(filePath+LOCK_MARKER_EXTENSION, nil, 0644)
+	if !IsRecording(filePath, time.Minute, now) {
+		t.Error("expected a locked cam to be recording")
+	}
+}
+
+func TestCamPlayerFollowsARecording(t *testing.T) {
+	filePath := writeCamFile(t, "< 0 0a01\n< 100 0a02\n")
+	os.WriteFile(filePath+LOCK_MARKER_EXTENSION, nil, 0644)
+
+	fakeClock := clock.NewFake(time.Now())
+	conn := &recordingConn{}
+	player := newTestPlaylistPlayer(t, library.Single(filePath), conn, PlayerOptions{
+		Clock:       fakeClock,
+		Follow:      true,
+		FollowDelay: time.Second,
+	})
+
+	if _, ok := player.reader.(*CamFileReader); !ok || !player.live {
+		t.Fatalf("expected the recording to be followed from the file, got %T", player.reader)
+	}
+
+	player.sendDuePackets()
+	fakeClock.Advance(100 * time.Millisecond)
+	player.sendDuePackets()
+
+	// everything recorded so far is played, the player waits for more instead of ending
+	if player.finished || !player.waitingLive || player.stats.duration != 0.1 {
+		t.Fatalf("expected to wait for the recording at 0.1, finished %v at %.1f", player.finished, player.stats.duration)
+	}
+	if wait := fakeClock.Until(player.pendingDue); wait != FOLLOW_POLL_INTERVAL {
+		t.Errorf("expected to read the file again in %s, got %s", FOLLOW_POLL_INTERVAL, wait)
+	}
+
+	appendToCam(t, filePath, "< 400 0a03\n< 500 0a04\n")
+	fakeClock.Advance(FOLLOW_POLL_INTERVAL)
+	player.sendDuePackets()
+
+	// the packets written meanwhile are played the follow delay behind the recording
+	if player.waitingLive || player.pending == nil || player.pending.Timestamp != 400 {
+		t.Fatalf("expected the new packet to be pending, got %v", player.pending)
+	}
+	if wait := fakeClock.Until(player.pendingDue); wait != time.Second {
+		t.Errorf("expected the new packet a second later, got %s", wait)
+	}
+
+	fakeClock.Advance(time.Second)
+	player.sendDuePackets()
+	if wait := fakeClock.Until(player.pendingDue); wait != 100*time.Millisecond {
+		t.Errorf("expected the next packet at its recorded interval, got %s", wait)
+	}
+
+	// the recording is done once the marker is gone and the file is no longer written to
+	os.Remove(filePath + LOCK_MARKER_EXTENSION)
+	old := fakeClock.Now().Add(-time.Hour)
+	os.Chtimes(filePath, old, old)
+
+	fakeClock.Advance(100 * time.Millisecond)
+	player.sendDuePackets()
+	if !player.finished || player.stats.duration != 0.5 {
+		t.Errorf("expected the cam to end at 0.5, finished %v at %.1f", player.finished, player.stats.duration)
+	}
+
+	sent := 0
+	for _, frame := range recordedFrames(conn) {
+		sent += frame
+	}
+	if sent != 4 {
+		t.Errorf("expected the 4 packets recorded, got %d", sent)
+	}
+}
+
+func TestCamPlayerSeeksPastTheRecording(t *testing.T) {
+	filePath := writeCamFile(t, "< 0 0a01\n< 100 0a02\n")
+	os.WriteFile(filePath+LOCK_MARKER_EXTENSION, nil, 0644)
+
+	fakeClock := clock.NewFake(time.Now())
+	player := newTestPlaylistPlayer(t, library.Single(filePath), &recordingConn{}, PlayerOptions{Clock: fakeClock, Follow: true})
+
+	seek := command.Seek{Replies: command.NewReplies(), Delta: time.Minute}
+	if !player.handleCommand(seek) {
+		t.Fatal("expected the session to go on")
+	}
+	<-seek.ReplyCh
+
+	if player.finished || !player.waitingLive || player.stats.currentTime != 0.1 {
+		t.Errorf("expected to wait for the recording at its last packet, finished %v at %.1f", player.finished, player.stats.currentTime)
+	}
+}
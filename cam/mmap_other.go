@@ -0,0 +1,14 @@
+//go:build !unix
+
+package cam
+
+import "os"
+
+// mapFile reads the whole file where memory mapping is not available
+func mapFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, nil, nil
+}
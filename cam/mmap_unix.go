@@ -0,0 +1,33 @@
+//go:build unix
+
+package cam
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mapFile maps a file read only, the mapping stays valid after the file is closed or replaced
+func mapFile(path string) ([]byte, func() error, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() == 0 || int64(int(info.Size())) != info.Size() {
+		return nil, nil, fmt.Errorf("cannot map %s of %d bytes", path, info.Size())
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error mapping %s: %w", path, err)
+	}
+
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
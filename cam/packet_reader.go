@@ -0,0 +1,16 @@
+package cam
+
+// PacketReader reads the packets of a cam in order, from the text file or from its compiled copy in a CamCache
+type PacketReader interface {
+	NextPacket() (CamPacket, error)
+	// LastPacket reads the last packet, Reset has to be called before reading on
+	LastPacket() (CamPacket, error)
+	Reset() error
+	Close()
+	Filename() string
+}
+
+var (
+	_ PacketReader = (*CamFileReader)(nil)
+	_ PacketReader = (*BinaryCamReader)(nil)
+)
@@ -0,0 +1,193 @@
+package cam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// A seekable zstd cam is made of independent zstd frames, each starting at a line, followed by a skippable
+// frame with the size of every frame, so a reader can start decompressing at any frame. It is the seekable
+// format of the zstd project, any zstd decompressor reads the cam as a whole.
+//
+//	frames      zstd frames of about SEEKABLE_FRAME_SIZE decompressed bytes
+//	seek table  skippable frame magic uint32, content size uint32,
+//	            compressed size uint32 and decompressed size uint32 per frame,
+//	            frame count uint32, descriptor byte, seekable magic uint32
+//
+// Every number is little endian.
+const (
+	SEEKABLE_FRAME_SIZE = 1024 * 1024
+
+	SKIPPABLE_FRAME_MAGIC     = 0x184d2a5e
+	SEEKABLE_MAGIC            = 0x8f92eab1
+	SEEK_TABLE_FOOTER_SIZE    = 9
+	SEEK_TABLE_CHECKSUM_FLAG  = 0x80
+	SEEK_TABLE_RESERVED_FLAGS = 0x7c
+)
+
+var (
+	ErrNotSeekable      = errors.New("the cam has no seek table")
+	ErrInvalidSeekTable = errors.New("invalid seek table")
+)
+
+type seekFrame struct {
+	compressedOffset   int64
+	compressedSize     int64
+	decompressedOffset int64
+	decompressedSize   int64
+}
+
+// readSeekTable reads the frames of a seekable zstd file, ErrNotSeekable when it has no seek table
+func readSeekTable(filePath string) ([]seekFrame, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	footer := make([]byte, SEEK_TABLE_FOOTER_SIZE)
+	if info.Size() < SEEK_TABLE_FOOTER_SIZE+8 {
+		return nil, ErrNotSeekable
+	}
+	if _, err := file.ReadAt(footer, info.Size()-SEEK_TABLE_FOOTER_SIZE); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != SEEKABLE_MAGIC {
+		return nil, ErrNotSeekable
+	}
+
+	count := int64(binary.LittleEndian.Uint32(footer))
+	descriptor := footer[4]
+	if descriptor&SEEK_TABLE_RESERVED_FLAGS != 0 {
+		return nil, fmt.Errorf("%w: reserved descriptor bits set", ErrInvalidSeekTable)
+	}
+	entrySize := int64(8)
+	if descriptor&SEEK_TABLE_CHECKSUM_FLAG != 0 {
+		entrySize = 12
+	}
+
+	tableStart := info.Size() - SEEK_TABLE_FOOTER_SIZE - count*entrySize - 8
+	if tableStart < 0 {
+		return nil, fmt.Errorf("%w: %d frames do not fit in the file", ErrInvalidSeekTable, count)
+	}
+
+	table := make([]byte, 8+count*entrySize)
+	if _, err := file.ReadAt(table, tableStart); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(table) != SKIPPABLE_FRAME_MAGIC ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != count*entrySize+SEEK_TABLE_FOOTER_SIZE {
+		return nil, fmt.Errorf("%w: bad skippable frame", ErrInvalidSeekTable)
+	}
+
+	frames := make([]seekFrame, count)
+	var compressedOffset, decompressedOffset int64
+	for i := range frames {
+		entry := table[8+int64(i)*entrySize:]
+		frames[i] = seekFrame{
+			compressedOffset:   compressedOffset,
+			compressedSize:     int64(binary.LittleEndian.Uint32(entry)),
+			decompressedOffset: decompressedOffset,
+			decompressedSize:   int64(binary.LittleEndian.Uint32(entry[4:])),
+		}
+		compressedOffset += frames[i].compressedSize
+		decompressedOffset += frames[i].decompressedSize
+	}
+	if compressedOffset != tableStart {
+		return nil, fmt.Errorf("%w: the frames do not end at the seek table", ErrInvalidSeekTable)
+	}
+
+	return frames, nil
+}
+
+// SeekableZstdWriter compresses a cam into frames cut at the end of a line, and appends the seek table on Close
+type SeekableZstdWriter struct {
+	writer    io.Writer
+	encoder   *zstd.Encoder
+	frameSize int
+	pending   []byte
+	frame     []byte
+	table     []byte
+	frames    uint32
+}
+
+// NewSeekableZstdWriter writes frames of about frameSize decompressed bytes, SEEKABLE_FRAME_SIZE when 0
+func NewSeekableZstdWriter(writer io.Writer, frameSize int) (*SeekableZstdWriter, error) {
+	if frameSize <= 0 {
+		frameSize = SEEKABLE_FRAME_SIZE
+	}
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+
+	table := binary.LittleEndian.AppendUint32(nil, SKIPPABLE_FRAME_MAGIC)
+	table = binary.LittleEndian.AppendUint32(table, 0) // the size of the table, known on Close
+
+	return &SeekableZstdWriter{writer: writer, encoder: encoder, frameSize: frameSize, table: table}, nil
+}
+
+func (w *SeekableZstdWriter) Write(data []byte) (int, error) {
+	w.pending = append(w.pending, data...)
+
+	for len(w.pending) >= w.frameSize {
+		// the frame ends with the line it is full at, a line is never split between frames
+		end := bytes.IndexByte(w.pending[w.frameSize-1:], '\n')
+		if end < 0 {
+			break
+		}
+		end += w.frameSize
+
+		if err := w.writeFrame(w.pending[:end]); err != nil {
+			return 0, err
+		}
+		w.pending = w.pending[:copy(w.pending, w.pending[end:])]
+	}
+
+	return len(data), nil
+}
+
+func (w *SeekableZstdWriter) writeFrame(data []byte) error {
+	w.frame = w.encoder.EncodeAll(data, w.frame[:0])
+	if _, err := w.writer.Write(w.frame); err != nil {
+		return err
+	}
+
+	w.table = binary.LittleEndian.AppendUint32(w.table, uint32(len(w.frame)))
+	w.table = binary.LittleEndian.AppendUint32(w.table, uint32(len(data)))
+	w.frames++
+	return nil
+}
+
+// Close writes the last frame and the seek table, it does not close the underlying writer
+func (w *SeekableZstdWriter) Close() error {
+	defer w.encoder.Close()
+
+	// an empty cam still gets a frame, so it starts like any zstd file
+	if len(w.pending) > 0 || w.frames == 0 {
+		if err := w.writeFrame(w.pending); err != nil {
+			return err
+		}
+		w.pending = w.pending[:0]
+	}
+
+	w.table = binary.LittleEndian.AppendUint32(w.table, w.frames)
+	w.table = append(w.table, 0) // no checksums
+	w.table = binary.LittleEndian.AppendUint32(w.table, SEEKABLE_MAGIC)
+	binary.LittleEndian.PutUint32(w.table[4:], uint32(len(w.table)-8))
+
+	_, err := w.writer.Write(w.table)
+	return err
+}
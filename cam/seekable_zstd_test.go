@@ -0,0 +1,189 @@
+package cam
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/clock"
+	"go-opentibia-camplayerserver/command"
+	"go-opentibia-camplayerserver/library"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// writeSeekableCam writes count packets 10ms apart in frames of about frameSize bytes
+func writeSeekableCam(t *testing.T, count int, frameSize int) string {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	writer, err := NewSeekableZstdWriter(&buffer, frameSize)
+	if err != nil {
+		t.Fatalf("failed to create the writer: %v", err)
+	}
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(writer, "< %d %04x\n", i*10, i)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to finish the cam: %v", err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "test.cam.zst")
+	if err := os.WriteFile(filePath, buffer.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write cam file: %v", err)
+	}
+	return filePath
+}
+
+func TestSeekableZstdFrames(t *testing.T) {
+	filePath := writeSeekableCam(t, 100, 64)
+
+	frames, err := readSeekTable(filePath)
+	if err != nil {
+		t.Fatalf("failed to read the seek table: %v", err)
+	}
+	if len(frames) < 10 {
+		t.Fatalf("expected the cam cut in many frames, got %d", len(frames))
+	}
+
+	// every frame holds whole lines, so any of them can be decompressed on its own
+	data, _ := os.ReadFile(filePath)
+	decoder, _ := zstd.NewReader(nil)
+	defer decoder.Close()
+	for i, frame := range frames {
+		content, err := decoder.DecodeAll(data[frame.compressedOffset:frame.compressedOffset+frame.compressedSize], nil)
+		if err != nil {
+			t.Fatalf("failed to decompress frame %d: %v", i, err)
+		}
+		if int64(len(content)) != frame.decompressedSize || content[0] != '<' || content[len(content)-1] != '\n' {
+			t.Errorf("expected frame %d to hold %d bytes of whole lines, got %q", i, frame.decompressedSize, content)
+		}
+	}
+
+	// and any zstd reader reads the whole cam, skipping the seek table
+	all, err := decoder.DecodeAll(data, nil)
+	if err != nil || !bytes.HasSuffix(all, []byte("< 990 0063\n")) {
+		t.Errorf("expected the whole cam, got %v", err)
+	}
+}
+
+func TestCamFileReaderSeeksZstdFrames(t *testing.T) {
+	filePath := writeSeekableCam(t, 100, 64)
+
+	reader := NewCamFileReader()
+	if err := reader.Open(filePath); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer reader.Close()
+
+	if packets := readAllPackets(t, reader); len(packets) != 100 {
+		t.Fatalf("expected the 100 packets, got %d", len(packets))
+	}
+
+	last, err := reader.LastPacket()
+	if err != nil || last.Timestamp != 990 {
+		t.Errorf("expected the last packet at 990, got %v and %v", last, err)
+	}
+
+	for _, timestamp := range []int64{0, 5, 500, 990, 5000} {
+		if err := reader.SeekFrame(timestamp); err != nil {
+			t.Fatalf("failed to seek to %d: %v", timestamp, err)
+		}
+
+		// the frame starts at or before the timestamp and reaches it
+		first, _ := reader.NextPacket()
+		if first.Timestamp > timestamp {
+			t.Errorf("seeking to %d: expected a frame starting before it, got one at %d", timestamp, first.Timestamp)
+		}
+		packets := append([]CamPacket{first}, readAllPackets(t, reader)...)
+		if reached := packets[len(packets)-1].Timestamp; reached != 990 || (first.Timestamp > 0 && len(packets) >= 100) {
+			t.Errorf("seeking to %d: expected to read from the frame to the end, got %d packets up to %d", timestamp, len(packets), reached)
+		}
+	}
+
+	if err := reader.Reset(); err != nil {
+		t.Fatalf("failed to reset: %v", err)
+	}
+	if first, _ := reader.NextPacket(); first.Timestamp != 0 {
+		t.Errorf("expected to read from the start after a reset, got %d", first.Timestamp)
+	}
+}
+
+func TestCamPlayerSeeksBackInAZstdCam(t *testing.T) {
+	filePath := writeSeekableCam(t, 100, 64)
+	conn := &recordingConn{}
+
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	player := newTestPlaylistPlayer(t, library.Single(filePath), conn, PlayerOptions{Clock: fakeClock})
+	playFor(t, player, fakeClock, 900*time.Millisecond)
+
+	backward := command.Seek{Replies: command.NewReplies(), Delta: -400 * time.Millisecond}
+	player.handleCommand(backward)
+	<-backward.ReplyCh
+
+	// the frames before the one holding the target carry game state as well, the cam is replayed from its start
+	frames := recordedFrames(conn)
+	if replayed := frames[len(frames)-1]; replayed != 51 {
+		t.Errorf("expected the 51 packets up to the target to be replayed, got %d", replayed)
+	}
+	if player.stats.currentTime != 0.5 || player.pending == nil || player.pending.Timestamp != 510 {
+		t.Errorf("expected playback at 0.5 with the packet at 510 pending, got %.1f and %v", player.stats.currentTime, player.pending)
+	}
+}
+
+func TestCamFileReaderSeekFrameNeedsASeekTable(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "test.cam.zst")
+	os.WriteFile(filePath, compressed(t, "zstd", sourceTestLines), 0644)
+
+	reader := NewCamFileReader()
+	if err := reader.Open(filePath); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer reader.Close()
+
+	if err := reader.SeekFrame(1000); !errors.Is(err, ErrNotSeekable) {
+		t.Errorf("expected a plain zstd cam not to be seekable, got %v", err)
+	}
+	expectSourcePackets(t, reader, "zstd")
+}
+
+func TestCamFileWriterRoundTrip(t *testing.T) {
+	packets := []CamPacket{
+		{Timestamp: 1000, Type: "<", Data: []byte{0x0a, 0x01}},
+		{Timestamp: 1010, Type: ">", Data: []byte{0x96, 0xff}},
+		{Timestamp: 1627391000123, Type: "<", Data: []byte("Hello")},
+	}
+
+	for _, name := range []string{"test.cam", "test.cam.gz", "test.cam.zst"} {
+		filePath := filepath.Join(t.TempDir(), name)
+		writer, err := CreateCamFile(filePath, CompressionOf(name))
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		for _, packet := range packets {
+			writer.WritePacket(packet)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+
+		reader := NewCamFileReader()
+		if err := reader.Open(filePath); err != nil {
+			t.Fatalf("failed to open %s: %v", name, err)
+		}
+		if read := readAllPackets(t, reader); !samePackets(read, packets) {
+			t.Errorf("%s: expected the packets written, got %v", name, read)
+		}
+		if (reader.frames != nil) != (CompressionOf(name) == COMPRESSION_ZSTD) {
+			t.Errorf("%s: expected only zstd cams to be seekable", name)
+		}
+		reader.Close()
+	}
+
+	if _, err := CreateCamFile(filepath.Join(t.TempDir(), "test.cam.xz"), COMPRESSION_XZ); err == nil {
+		t.Error("expected xz cams not to be written")
+	}
+}
@@ -0,0 +1,251 @@
+package cam
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	// STDIN_SOURCE reads the cam from the standard input, which can only be read once
+	STDIN_SOURCE = "-"
+	// ARCHIVE_SEPARATOR separates an archive from the path of the cam inside it, as in cams.zip!/old/1.cam
+	ARCHIVE_SEPARATOR = "!/"
+
+	// the tar magic is at this offset of the first header
+	TAR_MAGIC_OFFSET = 257
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zipMagic   = []byte("PK\x03\x04")
+	tarMagic   = []byte("ustar")
+)
+
+var ErrNotRewindable = errors.New("the cam source can only be read once")
+
+// source is a decompressed stream of a cam and everything that has to be closed with it
+type source struct {
+	io.Reader
+	compression Compression // of the stream as sniffed
+	closers     []func() error
+}
+
+func (s *source) Close() error {
+	var err error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		err = errors.Join(err, s.closers[i]())
+	}
+	return err
+}
+
+func (s *source) closeWith(closer func() error) {
+	s.closers = append(s.closers, closer)
+}
+
+// OpenSource opens a cam from a location: a file path, a cam inside a zip or tar archive (archive.zip!/cam.cam),
+// an http or https URL, or STDIN_SOURCE. The compression of the cam is told by its content, not by its name.
+func OpenSource(location string) (io.ReadCloser, error) {
+	switch {
+	case location == STDIN_SOURCE:
+		return decompress(io.NopCloser(os.Stdin))
+	case isURL(location):
+		return openURL(location)
+	case strings.Contains(location, ARCHIVE_SEPARATOR):
+		archivePath, entryPath, _ := strings.Cut(location, ARCHIVE_SEPARATOR)
+		return openArchiveEntry(archivePath, entryPath)
+	}
+
+	file, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(file)
+}
+
+// isLocalFile tells if a location is a file path, which can be read from any offset
+func isLocalFile(location string) bool {
+	return location != STDIN_SOURCE && !isURL(location) && !strings.Contains(location, ARCHIVE_SEPARATOR)
+}
+
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// isRewindable tells if a location can be opened again to read the cam from the start
+func isRewindable(location string) bool {
+	return location != STDIN_SOURCE
+}
+
+func openURL(url string) (io.ReadCloser, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("error downloading %s: %s", url, response.Status)
+	}
+	return decompress(response.Body)
+}
+
+// openArchiveEntry finds the cam in a zip archive, or in a tar archive of any of the compressions
+func openArchiveEntry(archivePath string, entryPath string) (io.ReadCloser, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(file, magic); err == nil && bytes.Equal(magic, zipMagic) {
+		entry, err := openZipEntry(file, entryPath)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error reading %s in %s: %w", entryPath, archivePath, err)
+		}
+		return entry, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	archive, err := decompress(file)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := openTarEntry(archive, entryPath)
+	if err != nil {
+		archive.Close()
+		return nil, fmt.Errorf("error reading %s in %s: %w", entryPath, archivePath, err)
+	}
+	return entry, nil
+}
+
+func openZipEntry(file *os.File, entryPath string) (io.ReadCloser, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range archive.File {
+		if !sameEntry(entry.Name, entryPath) {
+			continue
+		}
+
+		content, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		stream, err := decompress(content)
+		if err != nil {
+			return nil, err
+		}
+		stream.(*source).closeWith(file.Close)
+		return stream, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// openTarEntry reads the archive up to the cam, tar archives have no index
+func openTarEntry(archive io.ReadCloser, entryPath string) (io.ReadCloser, error) {
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, os.ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg || !sameEntry(header.Name, entryPath) {
+			continue
+		}
+
+		stream, err := decompress(io.NopCloser(reader))
+		if err != nil {
+			return nil, err
+		}
+		stream.(*source).closeWith(archive.Close)
+		return stream, nil
+	}
+}
+
+func sameEntry(name string, entryPath string) bool {
+	return path.Clean("/"+name) == path.Clean("/"+entryPath)
+}
+
+// decompress looks at the first bytes of reader to pick the decompression, a cam that matches none is plain
+// text. Closing the returned reader closes reader as well.
+func decompress(reader io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	stream := &source{Reader: buffered, closers: []func() error{reader.Close}}
+
+	// a short cam is fine, it is plain text then
+	magic, err := buffered.Peek(TAR_MAGIC_OFFSET + len(tarMagic))
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		reader.Close()
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("error creating gzip reader: %w", err)
+		}
+		stream.Reader = gzipReader
+		stream.compression = COMPRESSION_GZIP
+		stream.closeWith(gzipReader.Close)
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("error creating zstd reader: %w", err)
+		}
+		stream.Reader = zstdReader
+		stream.compression = COMPRESSION_ZSTD
+		stream.closeWith(func() error {
+			zstdReader.Close()
+			return nil
+		})
+
+	case bytes.HasPrefix(magic, bzip2Magic) && len(magic) > len(bzip2Magic) && magic[3] >= '1' && magic[3] <= '9':
+		stream.Reader = bzip2.NewReader(buffered)
+		stream.compression = COMPRESSION_BZIP2
+
+	case bytes.HasPrefix(magic, xzMagic):
+		xzReader, err := xz.NewReader(buffered)
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("error creating xz reader: %w", err)
+		}
+		stream.Reader = xzReader
+		stream.compression = COMPRESSION_XZ
+	}
+
+	return stream, nil
+}
@@ -0,0 +1,202 @@
+package cam
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const sourceTestLines = "< 1000 0a01\n< 2000 0a02\n"
+
+// sourceTestBzip2 is sourceTestLines compressed by bzip2, the standard library has no bzip2 writer
+var sourceTestBzip2 = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x97, 0x53,
+	0x3a, 0x1d, 0x00, 0x00, 0x0a, 0x59, 0x00, 0x00, 0x10, 0x40, 0x00, 0x70,
+	0x04, 0x20, 0x00, 0x20, 0x00, 0x21, 0x29, 0x93, 0x4d, 0x08, 0x60, 0x24,
+	0x87, 0x08, 0x1e, 0xd2, 0x91, 0x25, 0x46, 0x78, 0xbb, 0x92, 0x29, 0xc2,
+	0x84, 0x84, 0xba, 0x99, 0xd0, 0xe8,
+}
+
+func compressed(t *testing.T, compression string, data string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	var err error
+	switch compression {
+	case "gzip":
+		writer = gzip.NewWriter(&buffer)
+	case "zstd":
+		writer, err = zstd.NewWriter(&buffer)
+	case "xz":
+		writer, err = xz.NewWriter(&buffer)
+	case "bzip2":
+		return sourceTestBzip2
+	default:
+		return []byte(data)
+	}
+	if err != nil {
+		t.Fatalf("failed to create %s writer: %v", compression, err)
+	}
+
+	writer.Write([]byte(data))
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to compress with %s: %v", compression, err)
+	}
+	return buffer.Bytes()
+}
+
+func expectSourcePackets(t *testing.T, reader *CamFileReader, location string) {
+	t.Helper()
+
+	packets := readAllPackets(t, reader)
+	if len(packets) != 2 || packets[0].Timestamp != 1000 || packets[1].Timestamp != 2000 {
+		t.Errorf("%s: expected the two packets of the cam, got %v", location, packets)
+	}
+}
+
+func TestCamFileReaderSniffsTheCompression(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, compression := range []string{"plain", "gzip", "zstd", "bzip2", "xz"} {
+		// the name tells nothing, the content does
+		filePath := filepath.Join(dir, compression+".cam")
+		if err := os.WriteFile(filePath, compressed(t, compression, sourceTestLines), 0644); err != nil {
+			t.Fatalf("failed to write cam file: %v", err)
+		}
+
+		reader := NewCamFileReader()
+		if err := reader.Open(filePath); err != nil {
+			t.Fatalf("%s: failed to open: %v", compression, err)
+		}
+		expectSourcePackets(t, reader, compression)
+
+		if err := reader.Reset(); err != nil {
+			t.Fatalf("%s: failed to reset: %v", compression, err)
+		}
+		expectSourcePackets(t, reader, compression)
+		reader.Close()
+	}
+}
+
+func TestCamFileReaderOpensArchiveEntries(t *testing.T) {
+	dir := t.TempDir()
+
+	zipPath := filepath.Join(dir, "cams.zip")
+	var zipped bytes.Buffer
+	zipWriter := zip.NewWriter(&zipped)
+	for name, content := range map[string][]byte{
+		"other.cam":      []byte("< 5 0a05\n"),
+		"old/first.cam":  []byte(sourceTestLines),
+		"old/second.cam": compressed(t, "gzip", sourceTestLines),
+	} {
+		entry, _ := zipWriter.Create(name)
+		entry.Write(content)
+	}
+	zipWriter.Close()
+	os.WriteFile(zipPath, zipped.Bytes(), 0644)
+
+	tarPath := filepath.Join(dir, "cams.tar.xz")
+	var tarred bytes.Buffer
+	tarWriter := tar.NewWriter(&tarred)
+	for name, content := range map[string][]byte{
+		"other.cam":       []byte("< 5 0a05\n"),
+		"./old/first.cam": compressed(t, "zstd", sourceTestLines),
+	} {
+		tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tarWriter.Write(content)
+	}
+	tarWriter.Close()
+	os.WriteFile(tarPath, compressed(t, "xz", tarred.String()), 0644)
+
+	for _, location := range []string{
+		zipPath + ARCHIVE_SEPARATOR + "old/first.cam",
+		zipPath + ARCHIVE_SEPARATOR + "old/second.cam",
+		tarPath + ARCHIVE_SEPARATOR + "old/first.cam",
+	} {
+		reader := NewCamFileReader()
+		if err := reader.Open(location); err != nil {
+			t.Fatalf("failed to open %s: %v", location, err)
+		}
+		expectSourcePackets(t, reader, location)
+
+		if err := reader.Reset(); err != nil {
+			t.Fatalf("failed to reset %s: %v", location, err)
+		}
+		expectSourcePackets(t, reader, location)
+
+		if reader.Filename() != location {
+			t.Errorf("expected the reader to be named after the location, got %s", reader.Filename())
+		}
+		reader.Close()
+	}
+
+	for _, location := range []string{zipPath + ARCHIVE_SEPARATOR + "missing.cam", tarPath + ARCHIVE_SEPARATOR + "old"} {
+		if err := NewCamFileReader().Open(location); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s not to be found, got %v", location, err)
+		}
+	}
+}
+
+func TestCamFileReaderOpensURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cams/1.cam" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(compressed(t, "gzip", sourceTestLines))
+	}))
+	defer server.Close()
+
+	reader := NewCamFileReader()
+	if err := reader.Open(server.URL + "/cams/1.cam"); err != nil {
+		t.Fatalf("failed to open the URL: %v", err)
+	}
+	defer reader.Close()
+	expectSourcePackets(t, reader, "url")
+
+	if err := reader.Reset(); err != nil {
+		t.Fatalf("failed to download the cam again: %v", err)
+	}
+	expectSourcePackets(t, reader, "url")
+
+	if err := NewCamFileReader().Open(server.URL + "/cams/2.cam"); err == nil {
+		t.Error("expected a missing cam to fail")
+	}
+}
+
+func TestCamFileReaderOpenReader(t *testing.T) {
+	reader := NewCamFileReader()
+
+	// a pipe like stdin is read once
+	if err := reader.OpenReader("stdin", io.MultiReader(bytes.NewReader(compressed(t, "xz", sourceTestLines)))); err != nil {
+		t.Fatalf("failed to open the reader: %v", err)
+	}
+	expectSourcePackets(t, reader, "stdin")
+	if err := reader.Reset(); !errors.Is(err, ErrNotRewindable) {
+		t.Errorf("expected a stream not to be read again, got %v", err)
+	}
+	if _, err := reader.LastPacket(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected nothing left to read, got %v", err)
+	}
+
+	if err := reader.OpenReader("memory", bytes.NewReader(compressed(t, "gzip", sourceTestLines))); err != nil {
+		t.Fatalf("failed to open the reader: %v", err)
+	}
+	expectSourcePackets(t, reader, "memory")
+	if err := reader.Reset(); err != nil {
+		t.Fatalf("expected a seekable reader to be read again, got %v", err)
+	}
+	expectSourcePackets(t, reader, "memory")
+}
@@ -0,0 +1,92 @@
+package cam
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// SpeedControl bounds the playback speeds and lists the steps speed up and speed down go through,
+// any speed between the bounds can still be set directly
+type SpeedControl struct {
+	Minimum float64
+	Maximum float64
+	Steps   []float64 // sorted
+}
+
+// speeds closer than this are the same step, so 0.1*3 lands on 0.3
+const speedEpsilon = 1e-9
+
+var defaultSpeedControl, _ = NewSpeedControl(MINIMUM_PLAY_SPEED, MAXIMUM_PLAY_SPEED, nil)
+
+// NewSpeedControl validates the bounds and the steps, which default to the powers of two between the bounds
+func NewSpeedControl(minimum, maximum float64, steps []float64) (SpeedControl, error) {
+	if minimum == 0 && maximum == 0 {
+		minimum, maximum = MINIMUM_PLAY_SPEED, MAXIMUM_PLAY_SPEED
+	}
+
+	if !(minimum > 0) || math.IsInf(maximum, 0) || !(maximum >= minimum) {
+		return SpeedControl{}, fmt.Errorf("invalid speed bounds %g to %g", minimum, maximum)
+	}
+
+	if len(steps) == 0 {
+		for step := math.Pow(2, math.Ceil(math.Log2(minimum))); step <= maximum; step *= 2 {
+			steps = append(steps, step)
+		}
+	}
+
+	sorted := append([]float64(nil), steps...)
+	sort.Float64s(sorted)
+
+	for i, step := range sorted {
+		if math.IsNaN(step) || step < minimum || step > maximum {
+			return SpeedControl{}, fmt.Errorf("speed step %g is outside of %g to %g", step, minimum, maximum)
+		}
+		if i > 0 && step-sorted[i-1] < speedEpsilon {
+			return SpeedControl{}, fmt.Errorf("speed step %g is repeated", step)
+		}
+	}
+
+	return SpeedControl{Minimum: minimum, Maximum: maximum, Steps: sorted}, nil
+}
+
+func (s SpeedControl) orDefault() SpeedControl {
+	if s.Maximum == 0 {
+		return defaultSpeedControl
+	}
+	return s
+}
+
+// Allows tells whether speed can be set directly
+func (s SpeedControl) Allows(speed float64) bool {
+	s = s.orDefault()
+	return speed >= s.Minimum-speedEpsilon && speed <= s.Maximum+speedEpsilon
+}
+
+// Up returns the first step above speed, resuming at normal speed when paused
+func (s SpeedControl) Up(speed float64) float64 {
+	s = s.orDefault()
+
+	if speed <= 0 {
+		return math.Min(math.Max(1, s.Minimum), s.Maximum)
+	}
+
+	for _, step := range s.Steps {
+		if step > speed+speedEpsilon {
+			return step
+		}
+	}
+	return math.Min(math.Max(speed, s.Minimum), s.Maximum)
+}
+
+// Down returns the last step below speed
+func (s SpeedControl) Down(speed float64) float64 {
+	s = s.orDefault()
+
+	for i := len(s.Steps) - 1; i >= 0; i-- {
+		if s.Steps[i] < speed-speedEpsilon {
+			return s.Steps[i]
+		}
+	}
+	return s.Minimum
+}
@@ -0,0 +1,70 @@
+package cam
+
+import "testing"
+
+func TestNewSpeedControl(t *testing.T) {
+	tests := []struct {
+		minimum  float64
+		maximum  float64
+		steps    []float64
+		expected []float64
+		valid    bool
+	}{
+		{0, 0, nil, []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64}, true},
+		{0.3, 5, nil, []float64{0.5, 1, 2, 4}, true},
+		{0.5, 3, []float64{3, 1, 1.5}, []float64{1, 1.5, 3}, true},
+		{0.5, 3, []float64{1, 4}, nil, false},
+		{0.5, 3, []float64{1, 1}, nil, false},
+		{-1, 3, nil, nil, false},
+		{4, 2, nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		control, err := NewSpeedControl(tt.minimum, tt.maximum, tt.steps)
+		if (err == nil) != tt.valid {
+			t.Errorf("NewSpeedControl(%g, %g, %v): unexpected error %v", tt.minimum, tt.maximum, tt.steps, err)
+			continue
+		}
+
+		if len(control.Steps) != len(tt.expected) {
+			t.Errorf("NewSpeedControl(%g, %g, %v): expected steps %v, got %v", tt.minimum, tt.maximum, tt.steps, tt.expected, control.Steps)
+			continue
+		}
+		for i := range tt.expected {
+			if control.Steps[i] != tt.expected[i] {
+				t.Errorf("NewSpeedControl(%g, %g, %v): expected steps %v, got %v", tt.minimum, tt.maximum, tt.steps, tt.expected, control.Steps)
+				break
+			}
+		}
+	}
+}
+
+func TestSpeedControlSteps(t *testing.T) {
+	control, _ := NewSpeedControl(0.5, 3, []float64{0.75, 1, 1.5, 2})
+
+	tests := []struct {
+		speed float64
+		up    float64
+		down  float64
+	}{
+		{0, 1, 0.5},
+		{1, 1.5, 0.75},
+		{1.2, 1.5, 1},
+		{2, 2, 1.5},
+		{3, 3, 2},
+		{0.5, 0.75, 0.5},
+	}
+
+	for _, tt := range tests {
+		if up := control.Up(tt.speed); up != tt.up {
+			t.Errorf("Up(%g): expected %g, got %g", tt.speed, tt.up, up)
+		}
+		if down := control.Down(tt.speed); down != tt.down {
+			t.Errorf("Down(%g): expected %g, got %g", tt.speed, tt.down, down)
+		}
+	}
+
+	if !control.Allows(2.7) || control.Allows(3.5) || control.Allows(0.4) {
+		t.Error("expected any speed between the bounds to be allowed, and only those")
+	}
+}
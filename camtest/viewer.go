@@ -0,0 +1,203 @@
+package camtest
+
+import (
+	"errors"
+	"go-opentibia-camplayerserver/clock"
+	"go-opentibia-camplayerserver/protocol"
+	"go-opentibia-camplayerserver/viewer"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SYNC_TIMEOUT bounds the real time waited for the server, it is only reached when a test is broken
+const SYNC_TIMEOUT = 5 * time.Second
+
+// a speed of zero is always refused by the player without changing anything, so its answer tells that
+// every packet and command before it has been handled
+const (
+	syncCommand     = "/speed 0"
+	syncReplyPrefix = "Speed must be between"
+)
+
+var ErrClosed = errors.New("connection closed by the server")
+
+// Packet is a packet the server sent, decrypted, with the time of the fake clock it arrived at
+type Packet struct {
+	At   time.Time
+	Data []byte
+}
+
+// TextMessage decodes a text message packet
+func (p Packet) TextMessage() (protocol.MessageType, string, bool) {
+	message, ok := viewer.ParseTextMessage(p.Data)
+	return message.Type, message.Text, ok
+}
+
+// Viewer is the client end of a test session: it collects what the server sends while driving the fake
+// clock the server plays with
+type Viewer struct {
+	*viewer.Client
+	clock *clock.Fake
+
+	mutex    sync.Mutex
+	received []Packet
+	closed   bool
+	closedAt time.Time
+	err      error         // why the connection is closed, ErrClosed unless a packet could not be decoded
+	notify   chan struct{} // signalled when a packet arrives
+	done     chan struct{} // closed with the connection
+}
+
+// NewViewer logs in over conn to play the cam named character, with the login block sent for PlainRSA
+func NewViewer(conn net.Conn, fakeClock *clock.Fake, character string, xteaKey [4]uint32, protocolVersion uint16) (*Viewer, error) {
+	client, err := viewer.Login(conn, character, viewer.Options{
+		ProtocolVersion: protocolVersion,
+		Encrypter:       PlainRSA{},
+		XteaKey:         xteaKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Viewer{
+		Client: client,
+		clock:  fakeClock,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	go v.read()
+	return v, nil
+}
+
+func (v *Viewer) read() {
+	for {
+		data, err := v.ReadPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				err = ErrClosed
+			}
+			v.close(err)
+			v.Close()
+			return
+		}
+
+		v.mutex.Lock()
+		v.received = append(v.received, Packet{At: v.clock.Now(), Data: data})
+		v.mutex.Unlock()
+		v.signal()
+	}
+}
+
+func (v *Viewer) close(err error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if !v.closed {
+		v.closed = true
+		v.closedAt = v.clock.Now()
+		v.err = err
+		close(v.done)
+	}
+}
+
+func (v *Viewer) signal() {
+	select {
+	case v.notify <- struct{}{}:
+	default:
+	}
+}
+
+// send reports a failed write as the connection being closed once the reader has seen it too
+func (v *Viewer) send(text string) error {
+	v.Conn().SetWriteDeadline(time.Now().Add(SYNC_TIMEOUT))
+	err := v.Say(text)
+	if err == nil {
+		return nil
+	}
+
+	select {
+	case <-v.done:
+	case <-time.After(SYNC_TIMEOUT):
+		return err
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.err
+}
+
+// Sync waits until the server has handled everything sent to it and sent everything that was due, the
+// answer it waits for is left out of the received packets
+func (v *Viewer) Sync() error {
+	if err := v.send(syncCommand); err != nil {
+		return err
+	}
+
+	deadline := time.After(SYNC_TIMEOUT)
+	for {
+		v.mutex.Lock()
+		for i, p := range v.received {
+			if _, text, ok := p.TextMessage(); ok && strings.HasPrefix(text, syncReplyPrefix) {
+				v.received = append(v.received[:i], v.received[i+1:]...)
+				v.mutex.Unlock()
+				return nil
+			}
+		}
+		closed, err := v.closed, v.err
+		v.mutex.Unlock()
+
+		if closed {
+			return err
+		}
+
+		select {
+		case <-v.notify:
+		case <-v.done:
+		case <-deadline:
+			return errors.New("timed out waiting for the server to answer")
+		}
+	}
+}
+
+// Advance moves the fake clock forward by d one due timer at a time, letting the server handle each of
+// them before the next, so every packet arrives at the virtual time it was due. It returns ErrClosed when
+// the server closes the connection on the way.
+func (v *Viewer) Advance(d time.Duration) error {
+	target := v.clock.Now().Add(d)
+
+	for {
+		if err := v.Sync(); err != nil {
+			return err
+		}
+
+		next, ok := v.clock.NextDue()
+		if !ok || next.After(target) {
+			v.clock.Set(target)
+			return nil
+		}
+
+		v.clock.Set(next)
+		v.clock.WaitDelivered()
+	}
+}
+
+// Take returns the packets received since the last call
+func (v *Viewer) Take() []Packet {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	packets := v.received
+	v.received = nil
+	return packets
+}
+
+// Closed tells whether the server closed the connection and at which time of the fake clock
+func (v *Viewer) Closed() (time.Time, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.closedAt, v.closed
+}
@@ -1,11 +1,45 @@
 package client
 
-import "net"
+import (
+	"go-opentibia-camplayerserver/command"
+	"go-opentibia-camplayerserver/crypt"
+	"go-opentibia-camplayerserver/packet"
+	"net"
+	"sync"
+)
 
 type Client struct {
-	Conn      net.Conn
-	FileId    string
-	CancelCh  <-chan struct{}
-	CommandCh chan string // Channel for receiving commands
-	XteaKey   [4]uint32
+	Conn             net.Conn
+	Writer           *Writer // optional, packets are written straight to Conn without it
+	FileId           string
+	CommandCh        chan command.Command // commands parsed from the client packets, applied by the player
+	XteaKey          [4]uint32
+	Framing          packet.FramingMode
+	Compression      bool   // deflate outgoing packets, only for sequenced framing
+	SendSequence     uint32 // next sequence number sent to the client
+	ReceiveSequence  uint32 // last sequence number received from the client
+	ChecksumFailures int    // packets rejected due to a checksum mismatch
+	SequenceFailures int    // packets rejected due to a sequence mismatch
+
+	// packets can be sent from more than one goroutine, encoding and writing must happen together so sequence numbers arrive in order
+	SendMutex sync.Mutex
+
+	expandXteaKey   sync.Once
+	expandedXteaKey [64]uint32
+}
+
+// ExpandedXteaKey expands XteaKey the first time it is needed, the key must not change afterwards
+func (c *Client) ExpandedXteaKey() *[64]uint32 {
+	c.expandXteaKey.Do(func() {
+		c.expandedXteaKey = crypt.ExpandXteaKey(c.XteaKey)
+	})
+	return &c.expandedXteaKey
+}
+
+// Close flushes the pending packets and closes the connection
+func (c *Client) Close() {
+	if c.Writer != nil {
+		c.Writer.Close()
+	}
+	c.Conn.Close()
 }
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/packet"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FullQueuePolicy decides what happens when a client does not read fast enough and its send queue fills up
+type FullQueuePolicy uint8
+
+const (
+	QUEUE_FULL_DISCONNECT FullQueuePolicy = iota // drop the session
+	QUEUE_FULL_PAUSE                             // block the sender, pausing playback until the client catches up
+)
+
+const (
+	DEFAULT_SEND_QUEUE_SIZE = 256
+	DEFAULT_WRITE_TIMEOUT   = 10 * time.Second
+
+	// queued frames are merged into one write up to this size
+	MAX_COALESCED_WRITE = 64 * 1024
+)
+
+var (
+	ErrQueueFull    = errors.New("send queue is full")
+	ErrWriterClosed = errors.New("writer is closed")
+)
+
+type WriterOptions struct {
+	QueueSize    int // frames
+	WriteTimeout time.Duration
+	Policy       FullQueuePolicy
+}
+
+// Writer owns the outbound side of a connection: frames are queued by the player and written by a
+// dedicated goroutine, which coalesces whatever is queued into a single write
+type Writer struct {
+	conn      net.Conn
+	options   WriterOptions
+	queue     chan queuedFrame
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+	errMutex  sync.Mutex
+	err       error
+	coalesced []byte
+}
+
+// queuedFrame is a frame waiting to be written, with the pooled packet holding it if there is one
+type queuedFrame struct {
+	data   []byte
+	packet *packet.Outgoing
+}
+
+func NewWriter(conn net.Conn, options WriterOptions) *Writer {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DEFAULT_SEND_QUEUE_SIZE
+	}
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = DEFAULT_WRITE_TIMEOUT
+	}
+
+	w := &Writer{
+		conn:    conn,
+		options: options,
+		queue:   make(chan queuedFrame, options.QueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go w.run()
+	return w
+}
+
+func ParseFullQueuePolicy(policy string) (FullQueuePolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", "disconnect":
+		return QUEUE_FULL_DISCONNECT, nil
+	case "pause":
+		return QUEUE_FULL_PAUSE, nil
+	}
+	return QUEUE_FULL_DISCONNECT, fmt.Errorf("unknown send queue policy %q, expected disconnect or pause", policy)
+}
+
+// Write queues an encoded frame, the frame must not be modified afterwards
+func (w *Writer) Write(frame []byte) error {
+	return w.enqueue(queuedFrame{data: frame})
+}
+
+// WritePacket queues the frame of an encoded packet, the packet is released once its frame was copied
+// for writing, or right away when it could not be queued
+func (w *Writer) WritePacket(outgoing *packet.Outgoing) error {
+	err := w.enqueue(queuedFrame{data: outgoing.Get(), packet: outgoing})
+	if err != nil {
+		outgoing.Release()
+	}
+	return err
+}
+
+func (w *Writer) enqueue(frame queuedFrame) error {
+	select {
+	case <-w.stop:
+		return w.closedErr()
+	default:
+	}
+
+	if w.options.Policy == QUEUE_FULL_PAUSE {
+		select {
+		case w.queue <- frame:
+			return nil
+		case <-w.stop:
+			return w.closedErr()
+		}
+	}
+
+	select {
+	case w.queue <- frame:
+		return nil
+	default:
+		w.fail(ErrQueueFull)
+		return ErrQueueFull
+	}
+}
+
+// Close flushes the queued frames and stops the writer goroutine, waiting at most one write timeout
+func (w *Writer) Close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *Writer) Done() <-chan struct{} {
+	return w.done
+}
+
+func (w *Writer) Err() error {
+	w.errMutex.Lock()
+	defer w.errMutex.Unlock()
+	return w.err
+}
+
+func (w *Writer) closedErr() error {
+	if err := w.Err(); err != nil {
+		return err
+	}
+	return ErrWriterClosed
+}
+
+// fail records the first error and closes the connection, which also ends the reading side of the session
+func (w *Writer) fail(err error) {
+	w.errMutex.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.errMutex.Unlock()
+
+	w.stopOnce.Do(func() { close(w.stop) })
+	w.conn.Close()
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	for {
+		select {
+		case frame := <-w.queue:
+			if err := w.writeCoalesced(frame); err != nil {
+				w.fail(err)
+				return
+			}
+
+		case <-w.stop:
+			if w.Err() != nil {
+				return
+			}
+
+			// flush what was queued before closing
+			for len(w.queue) > 0 {
+				if err := w.writeCoalesced(<-w.queue); err != nil {
+					w.fail(err)
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+func (w *Writer) writeCoalesced(frame queuedFrame) error {
+	w.coalesced = w.coalesced[:0]
+	w.coalesce(frame)
+
+	for pending := len(w.queue); pending > 0 && len(w.coalesced) < MAX_COALESCED_WRITE; pending-- {
+		w.coalesce(<-w.queue)
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(w.options.WriteTimeout))
+	if _, err := w.conn.Write(w.coalesced); err != nil {
+		return fmt.Errorf("failed to send data: %w", err)
+	}
+
+	return nil
+}
+
+func (w *Writer) coalesce(frame queuedFrame) {
+	w.coalesced = append(w.coalesced, frame.data...)
+	if frame.packet != nil {
+		frame.packet.Release()
+	}
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedConn blocks every write until the test opens the gate, telling on started each time a write reaches it
+type gatedConn struct {
+	net.Conn
+	mutex   sync.Mutex
+	gate    chan struct{}
+	started chan struct{}
+	writes  [][]byte
+	closed  bool
+}
+
+func newGatedConn() *gatedConn {
+	return &gatedConn{gate: make(chan struct{}), started: make(chan struct{}, 1)}
+}
+
+func (g *gatedConn) Write(data []byte) (int, error) {
+	select {
+	case g.started <- struct{}{}:
+	default:
+		// nobody is waiting for this one
+	}
+	<-g.gate
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.closed {
+		return 0, net.ErrClosed
+	}
+	g.writes = append(g.writes, append([]byte(nil), data...))
+	return len(data), nil
+}
+
+func (g *gatedConn) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.closed = true
+	return nil
+}
+
+func (g *gatedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (g *gatedConn) writeCount() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return len(g.writes)
+}
+
+func TestWriterCoalescesQueuedFrames(t *testing.T) {
+	conn := newGatedConn()
+	writer := NewWriter(conn, WriterOptions{QueueSize: 8})
+
+	// the first frame is taken by the writer goroutine and blocks on the gate, the rest queue up behind it
+	writer.Write([]byte{0x01})
+	<-conn.started
+	writer.Write([]byte{0x02})
+	writer.Write([]byte{0x03})
+	writer.Write([]byte{0x04})
+
+	close(conn.gate)
+	writer.Close()
+
+	if len(conn.writes) != 2 {
+		t.Fatalf("expected 2 writes, got %d: %v", len(conn.writes), conn.writes)
+	}
+
+	if !bytes.Equal(conn.writes[1], []byte{0x02, 0x03, 0x04}) {
+		t.Errorf("expected queued frames in a single write, got %v", conn.writes[1])
+	}
+}
+
+func TestWriterDisconnectsWhenQueueIsFull(t *testing.T) {
+	conn := newGatedConn()
+	writer := NewWriter(conn, WriterOptions{QueueSize: 2, Policy: QUEUE_FULL_DISCONNECT})
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = writer.Write([]byte{byte(i)})
+	}
+
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	close(conn.gate)
+
+	select {
+	case <-writer.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected writer to stop after the queue filled up")
+	}
+
+	if !conn.closed {
+		t.Error("expected connection to be closed")
+	}
+
+	if err := writer.Write([]byte{0xFF}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected later writes to report the original error, got %v", err)
+	}
+}
+
+func TestWriterPauseBlocksUntilDrained(t *testing.T) {
+	conn := newGatedConn()
+	writer := NewWriter(conn, WriterOptions{QueueSize: 1, Policy: QUEUE_FULL_PAUSE})
+
+	writer.Write([]byte{0x01}) // taken by the writer goroutine
+	<-conn.started
+	writer.Write([]byte{0x02}) // fills the queue
+
+	unblocked := make(chan error)
+	go func() {
+		unblocked <- writer.Write([]byte{0x03})
+	}()
+
+	select {
+	case <-unblocked:
+		t.Fatal("expected write to block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(conn.gate)
+
+	select {
+	case err := <-unblocked:
+		if err != nil {
+			t.Errorf("expected no error once drained, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected write to resume once the queue drained")
+	}
+
+	writer.Close()
+
+	var written []byte
+	for _, data := range conn.writes {
+		written = append(written, data...)
+	}
+	if !bytes.Equal(written, []byte{0x01, 0x02, 0x03}) {
+		t.Errorf("expected every frame in order, got %v", written)
+	}
+}
+
+func TestWriterWriteTimeout(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+
+	writer := NewWriter(server, WriterOptions{WriteTimeout: 50 * time.Millisecond})
+	writer.Write([]byte{0x01}) // nobody reads the other end of the pipe
+
+	select {
+	case <-writer.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected writer to give up after the write timeout")
+	}
+
+	var netErr net.Error
+	if !errors.As(writer.Err(), &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a timeout error, got %v", writer.Err())
+	}
+}
+
+func TestParseFullQueuePolicy(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected FullQueuePolicy
+		valid    bool
+	}{
+		{"", QUEUE_FULL_DISCONNECT, true},
+		{"disconnect", QUEUE_FULL_DISCONNECT, true},
+		{"Pause", QUEUE_FULL_PAUSE, true},
+		{"drop", QUEUE_FULL_DISCONNECT, false},
+	}
+
+	for _, tt := range tests {
+		policy, err := ParseFullQueuePolicy(tt.input)
+		if (err == nil) != tt.valid {
+			t.Errorf("unexpected error for %q: %v", tt.input, err)
+		}
+		if policy != tt.expected {
+			t.Errorf("expected policy %d for %q, got %d", tt.expected, tt.input, policy)
+		}
+	}
+}
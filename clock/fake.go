@@ -0,0 +1,232 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only moves when told to, firing the timers and tickers that came due on the way.
+// A tick is handed over to a receiver instead of being buffered: a Stop or Reset withdraws one nobody took
+// yet, and a ticker drops the ticks that come due while the previous one is still waiting.
+type Fake struct {
+	mutex       sync.Mutex
+	delivered   *sync.Cond
+	now         time.Time
+	waiters     []*fakeWaiter
+	undelivered int // ticks fired and not yet received or withdrawn
+}
+
+// fakeWaiter is a timer, or a ticker when period is set
+type fakeWaiter struct {
+	clock    *Fake
+	ch       chan time.Time
+	due      time.Time
+	period   time.Duration
+	active   bool
+	withdraw chan struct{} // closed to take back the tick being handed over, nil when there is none
+}
+
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start}
+	f.delivered = sync.NewCond(&f.mutex)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration { return f.Now().Sub(t) }
+func (f *Fake) Until(t time.Time) time.Duration { return t.Sub(f.Now()) }
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.addWaiter(d, 0)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.addWaiter(d, d)}
+}
+
+func (f *Fake) addWaiter(d time.Duration, period time.Duration) *fakeWaiter {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	w := &fakeWaiter{
+		clock:  f,
+		ch:     make(chan time.Time),
+		due:    f.now.Add(d),
+		period: period,
+		active: true,
+	}
+	f.waiters = append(f.waiters, w)
+	f.fireDue()
+	return w
+}
+
+// Advance moves the clock forward by d, firing what came due in order
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t, which must not be in its past
+func (f *Fake) Set(t time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for {
+		next, ok := f.nextDue()
+		if !ok || next.After(t) {
+			break
+		}
+		f.now = next
+		f.fireDue()
+	}
+
+	if t.After(f.now) {
+		f.now = t
+	}
+}
+
+// NextDue returns when the earliest active timer or ticker fires
+func (f *Fake) NextDue() (time.Time, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.nextDue()
+}
+
+func (f *Fake) nextDue() (time.Time, bool) {
+	var next time.Time
+	found := false
+
+	for _, w := range f.waiters {
+		if w.active && (!found || w.due.Before(next)) {
+			next = w.due
+			found = true
+		}
+	}
+	return next, found
+}
+
+// WaitDelivered blocks until every tick fired so far has been received or withdrawn, so whoever
+// waits on the timers has taken them before the clock moves on
+func (f *Fake) WaitDelivered() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for f.undelivered > 0 {
+		f.delivered.Wait()
+	}
+}
+
+// fireDue hands a tick to every waiter due by now
+func (f *Fake) fireDue() {
+	sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].due.Before(f.waiters[j].due) })
+
+	active := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.active && !w.due.After(f.now) {
+			w.deliver(f.now)
+
+			if w.period > 0 {
+				for !w.due.After(f.now) {
+					w.due = w.due.Add(w.period)
+				}
+			} else {
+				w.active = false
+			}
+		}
+
+		if w.active {
+			active = append(active, w)
+		}
+	}
+	f.waiters = active
+}
+
+// deliver hands the tick over in the background, unless the previous one is still waiting for a receiver
+func (w *fakeWaiter) deliver(at time.Time) {
+	if w.withdraw != nil {
+		return
+	}
+
+	f := w.clock
+	withdraw := make(chan struct{})
+	w.withdraw = withdraw
+	f.undelivered++
+
+	go func() {
+		select {
+		case w.ch <- at:
+		case <-withdraw:
+		}
+
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		if w.withdraw == withdraw {
+			w.withdraw = nil
+		}
+		f.undelivered--
+		f.delivered.Broadcast()
+	}()
+}
+
+func (w *fakeWaiter) withdrawTick() {
+	if w.withdraw != nil {
+		close(w.withdraw)
+		w.withdraw = nil
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.ch
+}
+
+func (w *fakeWaiter) Stop() bool {
+	f := w.clock
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	wasActive := w.active
+	w.active = false
+	w.withdrawTick()
+
+	for i, waiter := range f.waiters {
+		if waiter == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			break
+		}
+	}
+	return wasActive
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	f := w.clock
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	wasActive := w.active
+	w.withdrawTick()
+	w.due = f.now.Add(d)
+
+	if !wasActive {
+		w.active = true
+		f.waiters = append(f.waiters, w)
+	}
+	f.fireDue()
+	return wasActive
+}
+
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeWaiter.Stop()
+}
@@ -0,0 +1,113 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// fired waits for a tick handed over by the clock, a tick fired before the call is always received
+func fired(ch <-chan time.Time) (time.Time, bool) {
+	select {
+	case at := <-ch:
+		return at, true
+	case <-time.After(100 * time.Millisecond):
+		return time.Time{}, false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewFake(start)
+	timer := clock.NewTimer(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	if _, ok := fired(timer.C()); ok {
+		t.Fatal("expected the timer not to fire before it is due")
+	}
+
+	clock.Advance(time.Second)
+	if at, ok := fired(timer.C()); !ok || !at.Equal(start.Add(time.Second)) {
+		t.Fatalf("expected the timer to fire at its due time, got %s %v", at, ok)
+	}
+
+	if timer.Stop() {
+		t.Error("expected Stop to report the timer already fired")
+	}
+
+	if timer.Reset(time.Second) {
+		t.Error("expected Reset to report the timer was not active")
+	}
+	if !timer.Stop() {
+		t.Error("expected Stop to report the reset timer was active")
+	}
+
+	clock.Advance(time.Hour)
+	if _, ok := fired(timer.C()); ok {
+		t.Error("expected a stopped timer never to fire")
+	}
+}
+
+func TestFakeTimerResetWithdrawsTick(t *testing.T) {
+	clock := NewFake(time.Unix(0, 0))
+	timer := clock.NewTimer(time.Second)
+
+	clock.Advance(time.Second)
+	timer.Reset(time.Second)
+
+	// nobody received the tick, so it only counts as delivered once withdrawn
+	clock.WaitDelivered()
+
+	if due, ok := clock.NextDue(); !ok || !due.Equal(time.Unix(2, 0)) {
+		t.Errorf("expected the timer to be due at 2s, got %s %v", due, ok)
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewFake(start)
+	ticker := clock.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	// the channel holds one tick, the rest are dropped like with a real ticker
+	clock.Advance(350 * time.Millisecond)
+	if at, ok := fired(ticker.C()); !ok || !at.Equal(start.Add(100*time.Millisecond)) {
+		t.Fatalf("expected the first tick, got %s %v", at, ok)
+	}
+	if _, ok := fired(ticker.C()); ok {
+		t.Fatal("expected the ticks nobody read to be dropped")
+	}
+
+	clock.Advance(50 * time.Millisecond)
+	if at, ok := fired(ticker.C()); !ok || !at.Equal(start.Add(400*time.Millisecond)) {
+		t.Errorf("expected the ticker to keep its period, got %s %v", at, ok)
+	}
+}
+
+func TestFakeZeroTimerFiresImmediately(t *testing.T) {
+	clock := NewFake(time.Unix(0, 0))
+	timer := clock.NewTimer(0)
+
+	if _, ok := fired(timer.C()); !ok {
+		t.Error("expected a timer of zero to fire right away")
+	}
+}
+
+func TestFakeWaitDelivered(t *testing.T) {
+	clock := NewFake(time.Unix(0, 0))
+	timer := clock.NewTimer(time.Second)
+	clock.Advance(time.Second)
+
+	received := make(chan time.Time, 1)
+	go func() { received <- <-timer.C() }()
+
+	clock.WaitDelivered()
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("expected WaitDelivered to return once the tick was received")
+	}
+
+	// a withdrawn tick counts as delivered as well
+	clock.NewTimer(0).Stop()
+	clock.WaitDelivered()
+}
@@ -0,0 +1,88 @@
+// Command camconvert rewrites a cam in another compression, .cam.zst files are written as seekable zstd so the
+// server can read them from any frame. The input is anything the server reads: a file of any compression, a
+// cam inside an archive, a URL or - for stdin. The output compression is told by its name, or by -compression.
+//
+//	camconvert Knight_1_25-10-2024-18-36-45.cam.gz Knight_1_25-10-2024-18-36-45.cam.zst
+//	curl -s https://example.org/1.cam | camconvert -compression zstd - - > 1.cam.zst
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go-opentibia-camplayerserver/cam"
+	"io"
+	"os"
+)
+
+func main() {
+	compression := flag.String("compression", "", "none, gzip or zstd, told by the output name when empty")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: camconvert [-compression none|gzip|zstd] input output")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input, output := flag.Arg(0), flag.Arg(1)
+
+	reader := cam.NewCamFileReader()
+	if err := reader.Open(input); err != nil {
+		fail(err)
+	}
+	defer reader.Close()
+
+	writer, err := createOutput(output, *compression)
+	if err != nil {
+		fail(err)
+	}
+
+	packets, skipped := 0, 0
+	for {
+		packet, err := reader.NextPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if parseErr := new(cam.ParseError); errors.As(err, &parseErr) {
+			fmt.Fprintln(os.Stderr, "camconvert: skipping", parseErr)
+			skipped++
+			continue
+		}
+		if err != nil {
+			fail(err)
+		}
+
+		if err := writer.WritePacket(packet); err != nil {
+			fail(err)
+		}
+		packets++
+	}
+
+	if err := writer.Close(); err != nil {
+		fail(err)
+	}
+	fmt.Fprintf(os.Stderr, "camconvert: wrote %d packets to %s, skipped %d lines\n", packets, output, skipped)
+}
+
+func createOutput(output string, compressionName string) (*cam.CamFileWriter, error) {
+	compression := cam.CompressionOf(output)
+	if compressionName != "" {
+		var err error
+		if compression, err = cam.ParseCompression(compressionName); err != nil {
+			return nil, err
+		}
+	}
+
+	if output == "-" {
+		return cam.NewCamFileWriter(os.Stdout, compression)
+	}
+	return cam.CreateCamFile(output, compression)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "camconvert:", err)
+	os.Exit(1)
+}
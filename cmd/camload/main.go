@@ -0,0 +1,125 @@
+// Command camload keeps many viewers connected to a cam server to find out how many it can serve: it ramps
+// the viewers up, sends them random speed and seek commands, and reports how late the packets arrive
+// compared to their recorded timestamps along with the throughput and the errors.
+//
+//	camload -address 127.0.0.1:7171 -library ./cams -viewers 300 -rampup 1m -duration 10m
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-opentibia-camplayerserver/crypt"
+	"go-opentibia-camplayerserver/loadtest"
+	"go-opentibia-camplayerserver/viewer"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	address := flag.String("address", "127.0.0.1:7171", "cam server address")
+	keyFile := flag.String("key", "key.pem", "PEM file with the server RSA public key, or its private key")
+	protocolVersion := flag.Uint("protocol", viewer.DEFAULT_PROTOCOL_VERSION, "client protocol version, which decides the framing")
+	libraryDir := flag.String("library", ".", "directory with the cams, read to know when each packet is due")
+	camNames := flag.String("cams", "", "comma separated cams to pick from, every cam of -library when empty")
+	source := flag.String("source", "", "read the single cam of -cams from a file, an archive entry (cams.zip!/1.cam), a URL or - for stdin instead of -library")
+	viewers := flag.Int("viewers", 100, "viewers connected at once")
+	rampUp := flag.Duration("rampup", 30*time.Second, "time over which the viewers connect")
+	duration := flag.Duration("duration", 5*time.Minute, "length of the test, 0 runs until interrupted")
+	commandInterval := flag.Duration("commands", 30*time.Second, "mean time between the commands of a viewer, 0 sends none")
+	speeds := flag.String("speeds", "0.5,1,2,4", "comma separated speeds the /speed commands pick from")
+	seed := flag.Uint64("seed", uint64(time.Now().UnixNano()), "seed of the random choices")
+	interval := flag.Duration("interval", 10*time.Second, "time between the progress reports")
+	flag.Parse()
+
+	encrypter, err := crypt.NewRSAEncrypter(*keyFile)
+	if err != nil {
+		fail(err)
+	}
+
+	var names []string
+	if *camNames != "" {
+		names = strings.Split(*camNames, ",")
+	}
+	var cams []loadtest.Cam
+	if *source != "" {
+		if len(names) != 1 {
+			fail(fmt.Errorf("-source needs the name of its cam in -cams"))
+		}
+		camToPlay, err := loadtest.LoadCam(names[0], *source)
+		if err != nil {
+			fail(err)
+		}
+		cams = []loadtest.Cam{camToPlay}
+	} else if cams, err = loadtest.LoadCams(*libraryDir, names); err != nil {
+		fail(err)
+	}
+
+	speedList, err := parseSpeeds(*speeds)
+	if err != nil {
+		fail(err)
+	}
+
+	test := loadtest.New(loadtest.Options{
+		Address: *address,
+		Viewer: viewer.Options{
+			ProtocolVersion: uint16(*protocolVersion),
+			Encrypter:       encrypter,
+			AccountNumber:   1,
+		},
+		Cams:            cams,
+		Viewers:         *viewers,
+		RampUp:          *rampUp,
+		Duration:        *duration,
+		CommandInterval: *commandInterval,
+		Speeds:          speedList,
+		Seed:            *seed,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("%d viewers on %d cams against %s, seed %d\n", *viewers, len(cams), *address, *seed)
+
+	go func() {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				test.Report().Print(os.Stdout)
+			}
+		}
+	}()
+
+	report, err := test.Run(ctx)
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Println("final report:")
+	report.Print(os.Stdout)
+}
+
+func parseSpeeds(list string) ([]float64, error) {
+	var speeds []float64
+	for _, field := range strings.Split(list, ",") {
+		speed, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || speed <= 0 {
+			return nil, fmt.Errorf("invalid speed %q", field)
+		}
+		speeds = append(speeds, speed)
+	}
+	return speeds, nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "camload:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,131 @@
+// Command camviewer logs in to a cam server like a game client and prints what it receives, lines typed on
+// stdin are sent as commands. With -expect it exits with status 0 once a text message containing the text
+// arrives, and 1 if the connection ends or -duration passes first, so scripts can check a running server.
+//
+//	camviewer -address 127.0.0.1:7171 -character Knight_1_25-10-2024-18-36-45 -expect "Welcome" -duration 10s
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"go-opentibia-camplayerserver/crypt"
+	"go-opentibia-camplayerserver/viewer"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	address := flag.String("address", "127.0.0.1:7171", "cam server address")
+	character := flag.String("character", "", "cam to play, as typed in the character field")
+	keyFile := flag.String("key", "key.pem", "PEM file with the server RSA public key, or its private key")
+	protocolVersion := flag.Uint("protocol", viewer.DEFAULT_PROTOCOL_VERSION, "client protocol version, which decides the framing")
+	account := flag.Uint("account", 1, "account number")
+	password := flag.String("password", "", "account password")
+	duration := flag.Duration("duration", 0, "disconnect after this long, 0 stays until the server closes the connection")
+	expect := flag.String("expect", "", "exit once a text message containing this arrives, failing if it never does")
+	quiet := flag.Bool("quiet", false, "print the text messages only")
+	flag.Parse()
+
+	if *character == "" {
+		fmt.Fprintln(os.Stderr, "camviewer: -character is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	encrypter, err := crypt.NewRSAEncrypter(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "camviewer:", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	c, err := viewer.Dial(ctx, *address, *character, viewer.Options{
+		ProtocolVersion: uint16(*protocolVersion),
+		Encrypter:       encrypter,
+		AccountNumber:   uint32(*account),
+		Password:        *password,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "camviewer:", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	// the deadline interrupts the read loop once the duration is over
+	context.AfterFunc(ctx, func() { c.Conn().SetDeadline(time.Now()) })
+
+	go sendCommands(c)
+
+	if found := printPackets(c, *expect, *quiet); *expect != "" && !found {
+		fmt.Fprintf(os.Stderr, "camviewer: never received %q\n", *expect)
+		os.Exit(1)
+	}
+}
+
+// sendCommands sends what is typed on stdin: up, down, forward, back and logout are the client shortcuts,
+// anything else is said, so chat commands such as /seek +30 work as in the game
+func sendCommands(c *viewer.Client) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		var err error
+		switch line {
+		case "":
+			continue
+		case "up":
+			err = c.SpeedUp()
+		case "down":
+			err = c.SpeedDown()
+		case "forward":
+			err = c.SeekForward()
+		case "back":
+			err = c.SeekBackward()
+		case "logout":
+			err = c.Logout()
+		default:
+			err = c.Say(line)
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "camviewer:", err)
+			return
+		}
+	}
+}
+
+// printPackets prints what the server sends until the connection ends, or the expected text arrives
+func printPackets(c *viewer.Client, expect string, quiet bool) bool {
+	start := time.Now()
+	var last []byte
+
+	for {
+		data, err := c.ReadPacket()
+		if err != nil {
+			if text, ok := viewer.ParseLoginError(last); ok {
+				fmt.Printf("[%8.3fs] login error: %s\n", time.Since(start).Seconds(), text)
+			}
+			fmt.Printf("[%8.3fs] connection ended: %v\n", time.Since(start).Seconds(), err)
+			return false
+		}
+		last = data
+
+		if message, ok := viewer.ParseTextMessage(data); ok {
+			fmt.Printf("[%8.3fs] message 0x%02x: %s\n", time.Since(start).Seconds(), uint8(message.Type), message.Text)
+			if expect != "" && strings.Contains(message.Text, expect) {
+				return true
+			}
+		} else if !quiet {
+			fmt.Printf("[%8.3fs] packet 0x%02x, %d bytes\n", time.Since(start).Seconds(), data[0], len(data))
+		}
+	}
+}
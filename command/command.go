@@ -0,0 +1,125 @@
+package command
+
+import (
+	"fmt"
+	"time"
+)
+
+// Command is something the viewer asked for, parsed from its packets and applied by the cam player
+type Command interface {
+	String() string
+}
+
+// Reply is the outcome of a command, reported back to the viewer
+type Reply struct {
+	Message string
+	Err     error
+}
+
+// Replies is embedded by commands whose outcome is reported back to the viewer
+type Replies struct {
+	ReplyCh chan Reply // buffered, the player never waits on it
+}
+
+func NewReplies() Replies {
+	return Replies{ReplyCh: make(chan Reply, 1)}
+}
+
+func (r Replies) Reply(message string, err error) {
+	if r.ReplyCh == nil {
+		return
+	}
+
+	select {
+	case r.ReplyCh <- Reply{Message: message, Err: err}:
+	default:
+	}
+}
+
+func (r Replies) replyChannel() chan Reply {
+	return r.ReplyCh
+}
+
+// replier is implemented by every command embedding Replies
+type replier interface {
+	replyChannel() chan Reply
+}
+
+// ReplyChannel returns where the outcome of cmd is reported, nil for commands that report nothing
+func ReplyChannel(cmd Command) chan Reply {
+	if r, ok := cmd.(replier); ok {
+		return r.replyChannel()
+	}
+	return nil
+}
+
+type Logout struct{}
+
+type SpeedUp struct{}
+
+type SpeedDown struct{}
+
+type Pause struct{}
+
+// Stop pauses playback and rewinds to the beginning of the cam
+type Stop struct{}
+
+// Seek moves playback by Delta, backwards when negative
+type Seek struct {
+	Replies
+	Delta time.Duration
+}
+
+type SetSpeed struct {
+	Replies
+	Speed float64
+}
+
+// SetHud shows or hides the status line
+type SetHud struct {
+	Replies
+	Visible bool
+}
+
+// SetSkipIdle turns the skipping of idle periods on or off
+type SetSkipIdle struct {
+	Replies
+	Enabled bool
+}
+
+// Say is chat typed by the viewer that is not a command
+type Say struct {
+	Text string
+}
+
+func (Logout) String() string    { return "logout" }
+func (SpeedUp) String() string   { return "speedUp" }
+func (SpeedDown) String() string { return "speedDown" }
+func (Pause) String() string     { return "pause" }
+func (Stop) String() string      { return "stop" }
+
+func (s Seek) String() string {
+	return fmt.Sprintf("seek %s", s.Delta)
+}
+
+func (s SetSpeed) String() string {
+	return fmt.Sprintf("speed %.2f", s.Speed)
+}
+
+func (s SetHud) String() string {
+	if s.Visible {
+		return "hud on"
+	}
+	return "hud off"
+}
+
+func (s SetSkipIdle) String() string {
+	if s.Enabled {
+		return "skipidle on"
+	}
+	return "skipidle off"
+}
+
+func (s Say) String() string {
+	return fmt.Sprintf("say %q", s.Text)
+}
@@ -1,14 +1,52 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"go-opentibia-camplayerserver/status"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
 )
 
+// the config file is read again once it was not written to for this long, so a file being saved is not read half way
+const RELOAD_SETTLE_DELAY = 500 * time.Millisecond
+
+const (
+	LOG_LEVEL_INFO  = "info"
+	LOG_LEVEL_DEBUG = "debug"
+)
+
+// the fields of the config, by their path in the config file, that a running server takes on when the config is
+// reloaded; any other field that changes needs a restart
+var reloadableFields = []string{
+	"motd",
+	"loglevel",
+	"welcome",
+	"status",
+	"access",
+	"camserver.servername",
+	"camserver.camdirectory",
+	"camserver.defaultcam",
+	"camserver.onend",
+	"camserver.lobbymessage",
+	"camserver.follow",
+	"camserver.followdelay",
+	"camserver.skipidle",
+	"camserver.skipidlethreshold",
+	"camserver.skipidledelay",
+	"camserver.minspeed",
+	"camserver.maxspeed",
+	"camserver.speedsteps",
+}
+
 type World struct {
 	Name     string `yaml:"name"`
 	ID       int    `yaml:"id"`
@@ -23,8 +61,75 @@ type LoginServer struct {
 }
 
 type CamServer struct {
-	HostName string `yaml:"hostname"`
-	Port     int    `yaml:"port"`
+	ServerName      string `yaml:"servername"` // shown to the viewers
+	HostName        string `yaml:"hostname"`
+	Port            int    `yaml:"port"`
+	LoginTimeout    int    `yaml:"logintimeout"`    // seconds to receive the login message
+	Compression     bool   `yaml:"compression"`     // deflate packets sent to clients using sequenced framing
+	WriteTimeout    int    `yaml:"writetimeout"`    // seconds a write to a client may block
+	SendQueueSize   int    `yaml:"sendqueuesize"`   // packets queued per client
+	SendQueuePolicy string `yaml:"sendqueuepolicy"` // "disconnect" or "pause" when the queue is full
+
+	ShutdownGracePeriod int `yaml:"shutdowngraceperiod"` // seconds viewers are given to finish once the server is stopping
+
+	// viewers pick a cam or a .playlist file of the directory by typing its name as the character
+	CamDirectory string `yaml:"camdirectory"`
+	DefaultCam   string `yaml:"defaultcam"`   // played when the name matches nothing, unknown names are rejected when empty
+	OnEnd        string `yaml:"onend"`        // "close", "loop", "next" or "lobby"
+	LobbyMessage string `yaml:"lobbymessage"` // shown by the lobby mode
+
+	// the directory is watched so new recordings can be played as soon as they appear, without reading it
+	// at every login; a recording not written to for the settle delay is finished and compiled into the cache
+	WatchCamDirectory bool `yaml:"watchcamdirectory"`
+	SettleDelay       int  `yaml:"settledelay"` // seconds, library.DEFAULT_SETTLE_DELAY when 0
+
+	// cams still being recorded, with a .lock marker or written to within the settle delay, are played as
+	// they are written instead of ending at their last packet, followdelay milliseconds behind the recording
+	Follow      bool `yaml:"follow"`
+	FollowDelay int  `yaml:"followdelay"`
+
+	// cams are compiled into a binary copy in this directory the first time they are played, and read from it
+	// as long as they do not change; empty reads the cam files every time
+	CacheDirectory string `yaml:"cachedirectory"`
+
+	SkipIdle          bool    `yaml:"skipidle"`          // idle periods are skipped until the viewer turns it off
+	SkipIdleThreshold float64 `yaml:"skipidlethreshold"` // seconds without packets that make an idle period
+	SkipIdleDelay     int     `yaml:"skipidledelay"`     // milliseconds an idle period is cut down to
+
+	// any speed between the bounds can be set with /speed, speed up and down go through the steps,
+	// zero bounds and no steps keep the power of two steps between 0.25x and 64x
+	MinSpeed   float64   `yaml:"minspeed"`
+	MaxSpeed   float64   `yaml:"maxspeed"`
+	SpeedSteps []float64 `yaml:"speedsteps"`
+}
+
+// limits applied to incoming cam connections, a zero value disables the limit
+type Access struct {
+	ConnectionsPerMinute int    `yaml:"connectionsperminute"`
+	MaxSessionsPerIp     int    `yaml:"maxsessionsperip"`
+	MaxSessions          int    `yaml:"maxsessions"`
+	BanListFile          string `yaml:"banlistfile"`
+	LoginFailureLimit    int    `yaml:"loginfailurelimit"`
+	LoginFailureLockout  int    `yaml:"loginfailurelockout"` // seconds
+}
+
+// status line shown during playback, see the status package for the tokens of Format
+type Status struct {
+	Format          string `yaml:"format"`          // e.g. "{position_clock}/{duration_clock} {bar:20} {percent} | {speed}"
+	RefreshInterval int    `yaml:"refreshinterval"` // milliseconds
+	MessageType     string `yaml:"messagetype"`     // status_small when empty
+}
+
+// messages sent to the viewers, the text of each line is a Go text/template
+type Welcome struct {
+	Lines                []WelcomeLine `yaml:"lines"` // sent when playback starts, the built-in welcome when empty
+	Announcements        []WelcomeLine `yaml:"announcements"`
+	AnnouncementInterval int           `yaml:"announcementinterval"` // seconds between announcements, 0 disables them
+}
+
+type WelcomeLine struct {
+	Text string `yaml:"text"`
+	Type string `yaml:"type"` // message type name like console_blue or status_small
 }
 
 type GameServer struct {
@@ -37,9 +142,13 @@ type Config struct {
 	LoginServer  LoginServer    `yaml:"loginserver"`
 	CamServer    CamServer      `yaml:"camserver"`
 	Database     DatabaseConfig `yaml:"database"`
+	Access       Access         `yaml:"access"`
+	Welcome      Welcome        `yaml:"welcome"`
+	Status       Status         `yaml:"status"`
 	RSAKeyFile   string         `yaml:"rsakeyfile"`
 	Motd         string         `yaml:"motd"`
 	QueryVersion string         `yaml:"queryversion"`
+	LogLevel     string         `yaml:"loglevel"` // "info" when empty, "debug" logs every login request as well
 }
 
 type DatabaseConfig struct {
@@ -51,11 +160,9 @@ type DatabaseConfig struct {
 }
 
 func LoadConfig() (Config, error) {
-	var config Config
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file")
+	// the .env file only adds to the environment, the config can be given by the environment alone
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("error loading .env file: %w", err)
 	}
 
 	viper.SetConfigName("config")
@@ -65,6 +172,13 @@ func LoadConfig() (Config, error) {
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
+	return Reload()
+}
+
+// Reload reads the config file found by LoadConfig again, the environment read from the .env file stays as it was
+func Reload() (Config, error) {
+	var config Config
+
 	if err := viper.ReadInConfig(); err != nil {
 		return config, fmt.Errorf("error reading config file: %w", err)
 	}
@@ -75,9 +189,136 @@ func LoadConfig() (Config, error) {
 
 	//convertConfigWorldHostnameToIp(&config)
 
+	if err := validate(&config); err != nil {
+		return config, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return config, nil
 }
 
+func validate(config *Config) error {
+	if _, err := status.ParseFormat(config.Status.Format); err != nil {
+		return err
+	}
+
+	if level := config.LogLevel; level != "" && level != LOG_LEVEL_INFO && level != LOG_LEVEL_DEBUG {
+		return fmt.Errorf("unknown log level %q, expected %s or %s", level, LOG_LEVEL_INFO, LOG_LEVEL_DEBUG)
+	}
+
+	if config.Status.RefreshInterval < 0 {
+		return fmt.Errorf("status refresh interval must not be negative, got %d", config.Status.RefreshInterval)
+	}
+
+	return nil
+}
+
+// Reloaded gives the config a running server takes on from a reloaded config: the reloadable fields of loaded and
+// the others of running, along with the fields that changed but need a restart
+func Reloaded(running Config, loaded Config) (Config, []string) {
+	var restartRequired []string
+	keepRunning(reflect.ValueOf(&running).Elem(), reflect.ValueOf(&loaded).Elem(), "", &restartRequired)
+	return loaded, restartRequired
+}
+
+// keepRunning puts back the running value of the fields of loaded that are not reloadable
+func keepRunning(running reflect.Value, loaded reflect.Value, path string, restartRequired *[]string) {
+	for i := 0; i < loaded.NumField(); i++ {
+		field := loaded.Type().Field(i)
+		name := field.Tag.Get("yaml")
+		if name == "" {
+			// not read from the config file
+			continue
+		}
+		if path != "" {
+			name = path + "." + name
+		}
+
+		if isReloadable(name) {
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct && hasReloadableField(name) {
+			keepRunning(running.Field(i), loaded.Field(i), name, restartRequired)
+			continue
+		}
+
+		if !reflect.DeepEqual(running.Field(i).Interface(), loaded.Field(i).Interface()) {
+			*restartRequired = append(*restartRequired, name)
+			loaded.Field(i).Set(running.Field(i))
+		}
+	}
+}
+
+func isReloadable(name string) bool {
+	for _, reloadable := range reloadableFields {
+		if name == reloadable {
+			return true
+		}
+	}
+	return false
+}
+
+func hasReloadableField(name string) bool {
+	for _, reloadable := range reloadableFields {
+		if strings.HasPrefix(reloadable, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch calls onChange each time the config file read by LoadConfig changes, until ctx is done; onChange is
+// called from the watching goroutine
+func Watch(ctx context.Context, onChange func()) error {
+	filePath, err := filepath.Abs(viper.ConfigFileUsed())
+	if err != nil {
+		return fmt.Errorf("error watching config file: %w", err)
+	}
+
+	events, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error watching config file %s: %w", filePath, err)
+	}
+	// editors often save by replacing the file, which is only seen from its directory
+	if err := events.Add(filepath.Dir(filePath)); err != nil {
+		events.Close()
+		return fmt.Errorf("error watching config file %s: %w", filePath, err)
+	}
+
+	go func() {
+		defer events.Close()
+
+		settled := time.NewTimer(time.Hour)
+		settled.Stop()
+		defer settled.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case event, ok := <-events.Events:
+				if !ok {
+					return
+				}
+				if event.Name == filePath && event.Has(fsnotify.Create|fsnotify.Write) {
+					settled.Reset(RELOAD_SETTLE_DELAY)
+				}
+
+			case err, ok := <-events.Errors:
+				if !ok {
+					return
+				}
+				fmt.Printf("[config.Watch] - Error watching %s: %v\n", filePath, err)
+
+			case <-settled.C:
+				onChange()
+			}
+		}
+	}()
+
+	return nil
+}
+
 func GetWorldById(config Config, worldId int) (World, error) {
 	var world World
 
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReloaded(t *testing.T) {
+	running := Config{
+		GameServer: GameServer{Worlds: []World{{Name: "Cam", Port: 7172}}},
+		CamServer:  CamServer{Port: 7171, CamDirectory: "cams", OnEnd: "close"},
+		Database:   DatabaseConfig{Password: "secret"},
+		Motd:       "Welcome",
+	}
+
+	loaded := running
+	loaded.GameServer = GameServer{Worlds: []World{{Name: "Cam", Port: 7272}}}
+	loaded.CamServer.Port = 7271
+	loaded.CamServer.CamDirectory = "recordings"
+	loaded.CamServer.OnEnd = "loop"
+	loaded.Database.Password = "other secret"
+	loaded.Access.MaxSessions = 10
+	loaded.Motd = "Welcome back"
+	loaded.LogLevel = LOG_LEVEL_DEBUG
+
+	effective, restartRequired := Reloaded(running, loaded)
+
+	if expected := []string{"gameserver", "camserver.port", "database"}; !slices.Equal(restartRequired, expected) {
+		t.Errorf("expected %v to need a restart, got %v", expected, restartRequired)
+	}
+	if effective.GameServer.Worlds[0].Port != 7172 || effective.CamServer.Port != 7171 || effective.Database.Password != "secret" {
+		t.Errorf("expected the fields needing a restart to keep their running value, got %+v", effective)
+	}
+	if effective.CamServer.CamDirectory != "recordings" || effective.CamServer.OnEnd != "loop" || effective.Access.MaxSessions != 10 || effective.Motd != "Welcome back" || effective.LogLevel != LOG_LEVEL_DEBUG {
+		t.Errorf("expected the reloadable fields to be taken, got %+v", effective)
+	}
+
+	if _, restartRequired := Reloaded(running, running); len(restartRequired) != 0 {
+		t.Errorf("expected nothing to need a restart without changes, got %v", restartRequired)
+	}
+}
+
+func TestLoadConfigWatchesTheFile(t *testing.T) {
+	workingDirectory, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(workingDirectory) })
+	os.Chdir(t.TempDir())
+
+	// without a .env file
+	os.WriteFile("config.yaml", []byte("motd: Welcome\n"), 0644)
+	config, err := LoadConfig()
+	if err != nil || config.Motd != "Welcome" {
+		t.Fatalf("expected the config to load, got %q and %v", config.Motd, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan struct{}, 1)
+	if err := Watch(ctx, func() { changes <- struct{}{} }); err != nil {
+		t.Fatalf("failed to watch the config: %v", err)
+	}
+
+	// written twice in a row, told once it settled
+	os.WriteFile("config.yaml", []byte("motd: Welcome"), 0644)
+	os.WriteFile("config.yaml", []byte("motd: Welcome back\n"), 0644)
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the change to be told")
+	}
+
+	if config, err := Reload(); err != nil || config.Motd != "Welcome back" {
+		t.Errorf("expected the changed config, got %q and %v", config.Motd, err)
+	}
+	select {
+	case <-changes:
+		t.Error("expected the writes to be told once")
+	case <-time.After(2 * RELOAD_SETTLE_DELAY):
+	}
+
+	os.WriteFile("config.yaml", []byte("status:\n  refreshinterval: -1\n"), 0644)
+	if _, err := Reload(); err == nil {
+		t.Error("expected an invalid config to be rejected")
+	}
+}
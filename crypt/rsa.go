@@ -69,3 +69,83 @@ func (r *RSA) DecryptNoPadding(ciphertext []byte) ([]byte, error) {
 
 	return plaintext, nil
 }
+
+type Encrypter interface {
+	EncryptNoPadding(plaintext []byte) ([]byte, error)
+}
+
+// RSAEncrypter is the client side of the login: it encrypts with the public key matching the server key
+type RSAEncrypter struct {
+	publicKey *rsa.PublicKey
+}
+
+func NewRSAEncrypter(pemFile string) (*RSAEncrypter, error) {
+	r := &RSAEncrypter{}
+	err := r.LoadPEM(pemFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load RSA public key from %s: %w", pemFile, err)
+	}
+	return r, nil
+}
+
+// LoadPEM reads a PKCS1 or PKIX public key, or takes the public half of the server private key
+func (r *RSAEncrypter) LoadPEM(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %v", filename, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("failed to decode PEM block containing the key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse RSA private key: %v", err)
+		}
+		r.publicKey = &privateKey.PublicKey
+
+	case "RSA PUBLIC KEY":
+		publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse RSA public key: %v", err)
+		}
+		r.publicKey = publicKey
+
+	case "PUBLIC KEY":
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse public key: %v", err)
+		}
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("public key is not an RSA key")
+		}
+		r.publicKey = rsaKey
+
+	default:
+		return fmt.Errorf("unexpected PEM block type %q", block.Type)
+	}
+
+	return nil
+}
+
+func (r *RSAEncrypter) EncryptNoPadding(plaintext []byte) ([]byte, error) {
+	if len(plaintext) != 128 {
+		return nil, fmt.Errorf("invalid plaintext length: %d", len(plaintext))
+	}
+
+	// the raw RSA encryption: c = m^e mod n, the login block starts with a zero byte so m is below n
+	m := new(big.Int).SetBytes(plaintext)
+	if m.Cmp(r.publicKey.N) >= 0 {
+		return nil, fmt.Errorf("plaintext is too large for the key")
+	}
+	c := new(big.Int).Exp(m, big.NewInt(int64(r.publicKey.E)), r.publicKey.N)
+
+	ciphertext := make([]byte, 128)
+	c.FillBytes(ciphertext)
+	return ciphertext, nil
+}
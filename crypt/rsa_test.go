@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -100,3 +101,58 @@ func TestDecryptNoPadding_ValidCiphertext(t *testing.T) {
 		t.Errorf("Expected plaintext length to be 128, got: %d", len(plaintext))
 	}
 }
+
+func TestRSAEncrypterRoundTrip(t *testing.T) {
+	decrypter, err := NewRSADecrypter("../key.pem")
+	if err != nil {
+		t.Fatalf("Failed to load the server key: %v", err)
+	}
+
+	// the server private key doubles as the public one
+	encrypter, err := NewRSAEncrypter("../key.pem")
+	if err != nil {
+		t.Fatalf("Expected no error when loading the public key, got: %v", err)
+	}
+
+	plaintext := make([]byte, 128)
+	copy(plaintext[1:], "login block")
+
+	ciphertext, err := encrypter.EncryptNoPadding(plaintext)
+	if err != nil {
+		t.Fatalf("Expected no error when encrypting, got: %v", err)
+	}
+
+	decrypted, err := decrypter.DecryptNoPadding(ciphertext)
+	if err != nil {
+		t.Fatalf("Expected no error when decrypting, got: %v", err)
+	}
+	if string(decrypted) != string(plaintext) {
+		t.Errorf("Expected the plaintext back, got %x", decrypted)
+	}
+}
+
+func TestRSAEncrypterPublicKeyPEM(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate test private key: %v", err)
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal the public key: %v", err)
+	}
+
+	tempPEMFile := filepath.Join(t.TempDir(), "public.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+	if err := os.WriteFile(tempPEMFile, pemData, 0644); err != nil {
+		t.Fatalf("Failed to write test PEM file: %v", err)
+	}
+
+	encrypter, err := NewRSAEncrypter(tempPEMFile)
+	if err != nil {
+		t.Fatalf("Expected no error when loading PEM file, got: %v", err)
+	}
+	if encrypter.publicKey.N.Cmp(privateKey.N) != 0 {
+		t.Error("Expected the public key to be loaded")
+	}
+}
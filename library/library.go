@@ -0,0 +1,199 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const PLAYLIST_EXTENSION = ".playlist"
+
+// extensions of the files played as cams, a file id may leave them out
+var camExtensions = []string{".cam", ".cam.gz", ".cam.zst"}
+
+var ErrNotFound = errors.New("Cam not found.")
+
+// Library resolves the file id a viewer logs in with to what gets played, looking in one directory
+type Library struct {
+	root string
+
+	// the playable files of the directory sorted by name, kept by Watch; the directory is read every time
+	// when it is not watched
+	mutex    sync.RWMutex
+	catalog  []string
+	watching bool
+}
+
+func New(root string) *Library {
+	if root == "" {
+		root = "."
+	}
+	return &Library{root: root}
+}
+
+func (l *Library) Root() string {
+	return l.root
+}
+
+// Resolve returns the playlist named by fileId, or the cams of the library starting at the one named by it,
+// so playback can carry on to the next cam of the folder
+func (l *Library) Resolve(fileId string) (Playlist, error) {
+	name := filepath.Clean(strings.TrimSpace(fileId))
+	if name == "." || !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) {
+		return Playlist{}, ErrNotFound
+	}
+
+	fileName, err := l.findFile(name)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	if strings.HasSuffix(strings.ToLower(fileName), PLAYLIST_EXTENSION) {
+		return LoadPlaylist(filepath.Join(l.root, fileName))
+	}
+
+	cams, err := l.Cams()
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	playlist := Playlist{Name: CamName(fileName)}
+	for i, cam := range cams {
+		if cam == fileName {
+			playlist.Current = i
+		}
+		playlist.Entries = append(playlist.Entries, Entry{Path: filepath.Join(l.root, cam)})
+	}
+
+	return playlist, nil
+}
+
+// findFile looks for name as given, then with the playlist and cam extensions; a name differing only in case
+// is accepted when there is no exact match, since viewers type it in the character field
+func (l *Library) findFile(name string) (string, error) {
+	candidates := []string{name + PLAYLIST_EXTENSION, name}
+	for _, extension := range camExtensions {
+		candidates = append(candidates, name+extension)
+	}
+
+	if catalog, ok := l.cataloged(); ok {
+		return findCandidate(catalog, candidates)
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(filepath.Join(l.root, candidate)); err == nil && info.Mode().IsRegular() && isPlayable(candidate) {
+			return candidate, nil
+		}
+	}
+
+	entries, err := os.ReadDir(l.root)
+	if err != nil {
+		return "", fmt.Errorf("error reading cam directory %s: %w", l.root, err)
+	}
+
+	for _, candidate := range candidates {
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && strings.EqualFold(entry.Name(), candidate) && isPlayable(entry.Name()) {
+				return entry.Name(), nil
+			}
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// findCandidate is findFile on the catalog
+func findCandidate(catalog []string, candidates []string) (string, error) {
+	for _, candidate := range candidates {
+		if _, found := slices.BinarySearch(catalog, candidate); found {
+			return candidate, nil
+		}
+	}
+
+	for _, candidate := range candidates {
+		for _, name := range catalog {
+			if strings.EqualFold(name, candidate) {
+				return name, nil
+			}
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// Cams lists the cam files of the library sorted by name
+func (l *Library) Cams() ([]string, error) {
+	catalog, ok := l.cataloged()
+	if !ok {
+		var err error
+		if catalog, err = l.scan(); err != nil {
+			return nil, err
+		}
+	}
+
+	var cams []string
+	for _, name := range catalog {
+		if IsCamFile(name) {
+			cams = append(cams, name)
+		}
+	}
+	return cams, nil
+}
+
+// cataloged returns the catalog while the directory is watched, it must not be modified
+func (l *Library) cataloged() ([]string, bool) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.catalog, l.watching
+}
+
+// scan lists the playable files of the directory sorted by name
+func (l *Library) scan() ([]string, error) {
+	entries, err := os.ReadDir(l.root)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cam directory %s: %w", l.root, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isPlayable(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func IsCamFile(fileName string) bool {
+	lower := strings.ToLower(fileName)
+	for _, extension := range camExtensions {
+		if strings.HasSuffix(lower, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPlayable(fileName string) bool {
+	return IsCamFile(fileName) || strings.HasSuffix(strings.ToLower(fileName), PLAYLIST_EXTENSION)
+}
+
+// CamName is the file name without directory and cam extensions
+func CamName(filePath string) string {
+	name := filepath.Base(filePath)
+	lower := strings.ToLower(name)
+
+	// longest extension first, so .cam.gz is not left as .cam
+	for i := len(camExtensions) - 1; i >= 0; i-- {
+		if strings.HasSuffix(lower, camExtensions[i]) {
+			return name[:len(name)-len(camExtensions[i])]
+		}
+	}
+	return name
+}
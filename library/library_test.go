@@ -0,0 +1,127 @@
+package library
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, names ...string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("< 0 0a01\n"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return root
+}
+
+func TestResolveCam(t *testing.T) {
+	root := writeFiles(t, "b.cam", "a.cam.gz", "Knight_2_25-10-2024-18-36-45.cam", "notes.txt")
+	library := New(root)
+
+	tests := []struct {
+		fileId  string
+		current string
+	}{
+		{"b.cam", "b.cam"},
+		{"b", "b.cam"},
+		{"a", "a.cam.gz"},
+		{"knight_2_25-10-2024-18-36-45", "Knight_2_25-10-2024-18-36-45.cam"},
+	}
+
+	for _, tt := range tests {
+		playlist, err := library.Resolve(tt.fileId)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", tt.fileId, err)
+			continue
+		}
+
+		// the whole folder is queued so playback can go on to the next cam
+		if len(playlist.Entries) != 3 {
+			t.Errorf("expected the 3 cams of the folder for %q, got %v", tt.fileId, playlist.Entries)
+			continue
+		}
+
+		if current := filepath.Base(playlist.Entries[playlist.Current].Path); current != tt.current {
+			t.Errorf("expected %q to start at %s, got %s", tt.fileId, tt.current, current)
+		}
+	}
+}
+
+func TestResolveOrder(t *testing.T) {
+	library := New(writeFiles(t, "c.cam", "a.cam", "b.cam"))
+
+	playlist, _ := library.Resolve("b")
+
+	var names []string
+	for _, entry := range playlist.Entries {
+		names = append(names, filepath.Base(entry.Path))
+	}
+
+	if len(names) != 3 || names[0] != "a.cam" || names[1] != "b.cam" || names[2] != "c.cam" || playlist.Current != 1 {
+		t.Errorf("expected cams sorted by name starting at b.cam, got %v from %d", names, playlist.Current)
+	}
+}
+
+func TestResolveNotFound(t *testing.T) {
+	root := writeFiles(t, "a.cam", "notes.txt")
+	os.WriteFile(filepath.Join(filepath.Dir(root), "outside.cam"), []byte("< 0 0a01\n"), 0644)
+
+	library := New(root)
+
+	for _, fileId := range []string{"", "missing", "notes.txt", "notes", "../outside", "../outside.cam", "/etc/passwd", `..\outside`} {
+		if _, err := library.Resolve(fileId); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for %q, got %v", fileId, err)
+		}
+	}
+}
+
+func TestResolvePlaylist(t *testing.T) {
+	root := writeFiles(t, "a.cam", "b.cam")
+	os.WriteFile(filepath.Join(root, "Best Of.playlist"), []byte("# highlights\nb.cam 1:30 2:00\n\na.cam\n"), 0644)
+
+	playlist, err := New(root).Resolve("best of")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if playlist.Name != "Best Of" || len(playlist.Entries) != 2 || playlist.Current != 0 {
+		t.Fatalf("unexpected playlist %+v", playlist)
+	}
+
+	if playlist.Entries[0].Path != filepath.Join(root, "b.cam") {
+		t.Errorf("expected paths relative to the playlist, got %s", playlist.Entries[0].Path)
+	}
+}
+
+func TestResolveBrokenPlaylist(t *testing.T) {
+	root := writeFiles(t)
+	os.WriteFile(filepath.Join(root, "empty.playlist"), []byte("# nothing yet\n"), 0644)
+
+	_, err := New(root).Resolve("empty")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the playlist error, got %v", err)
+	}
+}
+
+func TestCamName(t *testing.T) {
+	tests := map[string]string{
+		"dir/a.cam":    "a",
+		"a.CAM.GZ":     "a",
+		"c.cam.zst":    "c",
+		"a.cam.gz.cam": "a.cam.gz",
+		"noextension":  "noextension",
+		"b.playlist":   "b.playlist",
+		"dir/x.y.cam":  "x.y",
+	}
+
+	for input, expected := range tests {
+		if got := CamName(input); got != expected {
+			t.Errorf("expected %q for %q, got %q", expected, input, got)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package library
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is one cam of a playlist, optionally cut to the part between Start and End
+type Entry struct {
+	Path  string
+	Start time.Duration
+	End   time.Duration // zero plays until the end of the cam
+}
+
+type Playlist struct {
+	Name    string
+	Entries []Entry
+	Current int // entry playback starts from
+}
+
+// Single is a playlist of just one cam
+func Single(filePath string) Playlist {
+	return Playlist{
+		Name:    CamName(filePath),
+		Entries: []Entry{{Path: filePath}},
+	}
+}
+
+// LoadPlaylist reads a playlist file: one cam per line, optionally followed by the start and end times as
+// seconds or [h:]mm:ss, blank lines and lines starting with # are ignored. Relative paths are taken from the
+// playlist directory.
+func LoadPlaylist(filePath string) (Playlist, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return Playlist{}, fmt.Errorf("error opening playlist %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	entries, err := ParsePlaylist(file, filepath.Dir(filePath))
+	if err != nil {
+		return Playlist{}, fmt.Errorf("error in playlist %s: %w", filePath, err)
+	}
+
+	if len(entries) == 0 {
+		return Playlist{}, fmt.Errorf("playlist %s has no cams", filePath)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	return Playlist{Name: name, Entries: entries}, nil
+}
+
+func ParsePlaylist(r io.Reader, baseDir string) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := parseEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		if !filepath.IsAbs(entry.Path) {
+			entry.Path = filepath.Join(baseDir, entry.Path)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// parseEntry takes the times from the end of the line, so cam names may contain spaces
+func parseEntry(line string) (Entry, error) {
+	var times []time.Duration
+
+	for len(times) < 2 {
+		separator := strings.LastIndexAny(line, " \t")
+		if separator < 0 {
+			break
+		}
+
+		value, err := ParseTime(line[separator+1:])
+		if err != nil {
+			break
+		}
+
+		times = append([]time.Duration{value}, times...)
+		line = strings.TrimSpace(line[:separator])
+	}
+
+	entry := Entry{Path: line}
+	if len(times) > 0 {
+		entry.Start = times[0]
+	}
+	if len(times) > 1 {
+		entry.End = times[1]
+		if entry.End <= entry.Start {
+			return entry, fmt.Errorf("end %s is not after start %s", entry.End, entry.Start)
+		}
+	}
+
+	return entry, nil
+}
+
+// ParseTime reads seconds, with an optional fraction, or [h:]mm:ss
+func ParseTime(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	var seconds float64
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil || math.IsNaN(number) || number < 0 || (i > 0 && number >= 60) || (i < len(parts)-1 && strings.Contains(part, ".")) {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		seconds = seconds*60 + number
+	}
+
+	if seconds > float64(24*time.Hour/time.Second)*365 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
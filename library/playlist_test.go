@@ -0,0 +1,72 @@
+package library
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePlaylist(t *testing.T) {
+	input := `# comment
+first.cam
+ second cam with spaces.cam 90
+third.cam.gz 1:30 1:02:03.5
+
+/abs/fourth.cam 0 10
+`
+
+	entries, err := ParsePlaylist(strings.NewReader(input), "base")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := []Entry{
+		{Path: "base/first.cam"},
+		{Path: "base/second cam with spaces.cam", Start: 90 * time.Second},
+		{Path: "base/third.cam.gz", Start: 90 * time.Second, End: time.Hour + 2*time.Minute + 3500*time.Millisecond},
+		{Path: "/abs/fourth.cam", End: 10 * time.Second},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %+v", len(expected), len(entries), entries)
+	}
+
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Errorf("expected entry %d to be %+v, got %+v", i, expected[i], entries[i])
+		}
+	}
+}
+
+func TestParsePlaylistErrors(t *testing.T) {
+	for _, input := range []string{
+		"a.cam 20 10",
+		"a.cam 10 10",
+	} {
+		if _, err := ParsePlaylist(strings.NewReader(input), "."); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	valid := map[string]time.Duration{
+		"0":       0,
+		"90":      90 * time.Second,
+		"1.5":     1500 * time.Millisecond,
+		"1:30":    90 * time.Second,
+		"1:02:03": time.Hour + 2*time.Minute + 3*time.Second,
+	}
+
+	for input, expected := range valid {
+		if got, err := ParseTime(input); err != nil || got != expected {
+			t.Errorf("expected %s for %q, got %s and %v", expected, input, got, err)
+		}
+	}
+
+	for _, input := range []string{"", "-1", "1:60", "1.5:00", "1:2:3:4", "nan", "inf", "abc"} {
+		if _, err := ParseTime(input); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/clock"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// a recording not written to for this long is taken as finished
+const DEFAULT_SETTLE_DELAY = 30 * time.Second
+
+type ChangeType uint8
+
+const (
+	CAM_ADDED    ChangeType = iota // a new cam or playlist, a recording may still be going on
+	CAM_WRITTEN                    // a finished file is written to again
+	CAM_FINISHED                   // not written to for the settle delay since it was added or written to
+	CAM_REMOVED                    // deleted or renamed away, a rename adds the new name as well
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case CAM_ADDED:
+		return "added"
+	case CAM_WRITTEN:
+		return "written"
+	case CAM_FINISHED:
+		return "finished"
+	case CAM_REMOVED:
+		return "removed"
+	}
+	return fmt.Sprintf("ChangeType(%d)", t)
+}
+
+// Change is a playable file of the library that changed
+type Change struct {
+	Type ChangeType
+	Name string // the file name in the library
+	Path string
+}
+
+type WatchOptions struct {
+	SettleDelay time.Duration // DEFAULT_SETTLE_DELAY when 0
+	Clock       clock.Clock   // clock.Real when nil
+	// OnChange is called from the watching goroutine, one change at a time
+	OnChange func(Change)
+}
+
+// watcher follows the events of the directory, it is only used from its goroutine
+type watcher struct {
+	library *Library
+	events  *fsnotify.Watcher
+	options WatchOptions
+	timer   clock.Timer
+	// when the files being written to are taken as finished
+	settling map[string]time.Time
+}
+
+// Watch keeps the catalog of the library up to date with the directory until ctx is done, so cams are found
+// without reading the directory and the changes are told to options.OnChange as they happen
+func (l *Library) Watch(ctx context.Context, options WatchOptions) error {
+	if options.SettleDelay <= 0 {
+		options.SettleDelay = DEFAULT_SETTLE_DELAY
+	}
+	if options.Clock == nil {
+		options.Clock = clock.Real
+	}
+
+	events, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error watching cam directory %s: %w", l.root, err)
+	}
+	if err := events.Add(l.root); err != nil {
+		events.Close()
+		return fmt.Errorf("error watching cam directory %s: %w", l.root, err)
+	}
+
+	// added after the watch, so no file is missed between the scan and the first event
+	catalog, err := l.scan()
+	if err != nil {
+		events.Close()
+		return err
+	}
+
+	l.mutex.Lock()
+	l.catalog = catalog
+	l.watching = true
+	l.mutex.Unlock()
+
+	w := &watcher{
+		library:  l,
+		events:   events,
+		options:  options,
+		timer:    options.Clock.NewTimer(time.Hour),
+		settling: make(map[string]time.Time),
+	}
+	w.timer.Stop()
+
+	go w.run(ctx)
+	return nil
+}
+
+func (w *watcher) run(ctx context.Context) {
+	defer func() {
+		w.timer.Stop()
+		w.events.Close()
+
+		w.library.mutex.Lock()
+		w.library.catalog = nil
+		w.library.watching = false
+		w.library.mutex.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-w.events.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+
+		case err, ok := <-w.events.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// events were lost, the directory tells what changed
+				w.rescan()
+				continue
+			}
+			fmt.Printf("[Library.Watch] - Error watching %s: %v\n", w.library.root, err)
+
+		case <-w.timer.C():
+			w.settle()
+		}
+	}
+}
+
+func (w *watcher) handle(event fsnotify.Event) {
+	name := filepath.Base(event.Name)
+	if filepath.Dir(event.Name) != filepath.Clean(w.library.root) || !isPlayable(name) {
+		return
+	}
+
+	switch {
+	case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+		if info, err := os.Lstat(event.Name); err != nil || !info.Mode().IsRegular() {
+			return
+		}
+
+		changeType := CAM_WRITTEN
+		if w.add(name) {
+			changeType = CAM_ADDED
+		} else if _, writing := w.settling[name]; writing {
+			// told once per recording, not for every write
+			w.startSettling(name)
+			return
+		}
+		w.startSettling(name)
+		w.notify(changeType, name)
+
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		if w.remove(name) {
+			delete(w.settling, name)
+			w.schedule()
+			w.notify(CAM_REMOVED, name)
+		}
+	}
+}
+
+// rescan replaces the catalog with the directory, telling the files added and removed since
+func (w *watcher) rescan() {
+	scanned, err := w.library.scan()
+	if err != nil {
+		fmt.Printf("[Library.Watch] - %v\n", err)
+		return
+	}
+
+	catalog, _ := w.library.cataloged()
+	for _, name := range catalog {
+		if _, found := slices.BinarySearch(scanned, name); !found && w.remove(name) {
+			delete(w.settling, name)
+			w.notify(CAM_REMOVED, name)
+		}
+	}
+	for _, name := range scanned {
+		if w.add(name) {
+			w.startSettling(name)
+			w.notify(CAM_ADDED, name)
+		}
+	}
+	w.schedule()
+}
+
+// settle tells the files not written to for the settle delay are finished
+func (w *watcher) settle() {
+	now := w.options.Clock.Now()
+
+	var finished []string
+	for name, at := range w.settling {
+		if !at.After(now) {
+			finished = append(finished, name)
+			delete(w.settling, name)
+		}
+	}
+	w.schedule()
+
+	slices.Sort(finished)
+	for _, name := range finished {
+		w.notify(CAM_FINISHED, name)
+	}
+}
+
+func (w *watcher) startSettling(name string) {
+	w.settling[name] = w.options.Clock.Now().Add(w.options.SettleDelay)
+	w.schedule()
+}
+
+// schedule sets the timer to the first file to settle
+func (w *watcher) schedule() {
+	var next time.Time
+	for _, at := range w.settling {
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+
+	if next.IsZero() {
+		w.timer.Stop()
+		return
+	}
+	w.timer.Reset(w.options.Clock.Until(next))
+}
+
+// add puts name in the catalog, false when it already is; the catalog is copied, readers may hold the old one
+func (w *watcher) add(name string) bool {
+	w.library.mutex.Lock()
+	defer w.library.mutex.Unlock()
+
+	index, found := slices.BinarySearch(w.library.catalog, name)
+	if found {
+		return false
+	}
+	w.library.catalog = slices.Insert(slices.Clip(w.library.catalog), index, name)
+	return true
+}
+
+func (w *watcher) remove(name string) bool {
+	w.library.mutex.Lock()
+	defer w.library.mutex.Unlock()
+
+	index, found := slices.BinarySearch(w.library.catalog, name)
+	if !found {
+		return false
+	}
+	w.library.catalog = slices.Delete(slices.Clone(w.library.catalog), index, index+1)
+	return true
+}
+
+func (w *watcher) notify(changeType ChangeType, name string) {
+	if w.options.OnChange != nil {
+		w.options.OnChange(Change{Type: changeType, Name: name, Path: filepath.Join(w.library.root, name)})
+	}
+}
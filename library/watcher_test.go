@@ -0,0 +1,131 @@
+package library
+
+import (
+	"context"
+	"go-opentibia-camplayerserver/clock"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func watchTestLibrary(t *testing.T, root string, fakeClock *clock.Fake) (*Library, <-chan Change) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	changes := make(chan Change, 16)
+	library := New(root)
+	err := library.Watch(ctx, WatchOptions{
+		SettleDelay: time.Minute,
+		Clock:       fakeClock,
+		OnChange:    func(change Change) { changes <- change },
+	})
+	if err != nil {
+		t.Fatalf("failed to watch the library: %v", err)
+	}
+	return library, changes
+}
+
+func expectChange(t *testing.T, changes <-chan Change, changeType ChangeType, name string) {
+	t.Helper()
+
+	select {
+	case change := <-changes:
+		if change.Type != changeType || change.Name != name {
+			t.Fatalf("expected %s %s, got %s %s", name, changeType, change.Name, change.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %s %s, got nothing", name, changeType)
+	}
+}
+
+func expectCams(t *testing.T, library *Library, expected ...string) {
+	t.Helper()
+
+	if cams, err := library.Cams(); err != nil || !slices.Equal(cams, expected) {
+		t.Errorf("expected the cams %v, got %v and %v", expected, cams, err)
+	}
+}
+
+func TestWatchFollowsARecording(t *testing.T) {
+	root := writeFiles(t, "a.cam", "notes.txt")
+	fakeClock := clock.NewFake(time.Unix(1000, 0))
+	library, changes := watchTestLibrary(t, root, fakeClock)
+
+	expectCams(t, library, "a.cam")
+
+	// the recording system creates the file and appends to it as the game goes on
+	recordingPath := filepath.Join(root, "b.cam")
+	recording, err := os.Create(recordingPath)
+	if err != nil {
+		t.Fatalf("failed to create the recording: %v", err)
+	}
+	expectChange(t, changes, CAM_ADDED, "b.cam")
+	expectCams(t, library, "a.cam", "b.cam")
+
+	if playlist, err := library.Resolve("B"); err != nil || playlist.Current != 1 {
+		t.Errorf("expected the new recording to be found from the catalog, got %+v and %v", playlist, err)
+	}
+
+	fakeClock.Advance(time.Minute)
+	expectChange(t, changes, CAM_FINISHED, "b.cam")
+
+	// written to again once finished
+	recording.WriteString("< 0 0a01\n")
+	recording.Close()
+	expectChange(t, changes, CAM_WRITTEN, "b.cam")
+	fakeClock.Advance(time.Minute)
+	expectChange(t, changes, CAM_FINISHED, "b.cam")
+
+	if err := os.Rename(recordingPath, filepath.Join(root, "c.cam")); err != nil {
+		t.Fatalf("failed to rename the recording: %v", err)
+	}
+	expectChange(t, changes, CAM_REMOVED, "b.cam")
+	expectChange(t, changes, CAM_ADDED, "c.cam")
+	expectCams(t, library, "a.cam", "c.cam")
+
+	os.Remove(filepath.Join(root, "a.cam"))
+	expectChange(t, changes, CAM_REMOVED, "a.cam")
+	expectCams(t, library, "c.cam")
+
+	// files that are not played are left out
+	os.WriteFile(filepath.Join(root, "more notes.txt"), []byte("-"), 0644)
+	os.WriteFile(filepath.Join(root, "best.playlist"), []byte("c.cam\n"), 0644)
+	expectChange(t, changes, CAM_ADDED, "best.playlist")
+	expectCams(t, library, "c.cam")
+}
+
+func TestWatchStopsWithTheContext(t *testing.T) {
+	root := writeFiles(t, "a.cam")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	library := New(root)
+	if err := library.Watch(ctx, WatchOptions{}); err != nil {
+		t.Fatalf("failed to watch the library: %v", err)
+	}
+	cancel()
+
+	// the directory is read again once the catalog is gone
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, watching := library.cataloged(); !watching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the watch to stop")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	os.WriteFile(filepath.Join(root, "b.cam"), []byte("< 0 0a01\n"), 0644)
+	expectCams(t, library, "a.cam", "b.cam")
+}
+
+func TestWatchMissingDirectory(t *testing.T) {
+	if err := New(filepath.Join(t.TempDir(), "missing")).Watch(context.Background(), WatchOptions{}); err == nil {
+		t.Error("expected watching a missing directory to fail")
+	}
+}
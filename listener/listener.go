@@ -0,0 +1,106 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_HANDSHAKE_TIMEOUT = 10 * time.Second
+)
+
+// Handler performs the handshake of a new connection and hands it over to whoever serves it; ctx expires
+// after the handshake timeout, and the connection is interrupted if the handler has not called HandOver by then.
+// The handler owns conn and must close it when the handshake fails.
+type Handler func(ctx context.Context, conn net.Conn)
+
+type interruptKey struct{}
+
+type Options struct {
+	HandshakeTimeout time.Duration
+}
+
+// Listener accepts TCP connections until its context is cancelled, running every handshake in its own goroutine
+// so one slow client does not hold back the others
+type Listener struct {
+	tcpListener net.Listener
+	options     Options
+	handshakes  sync.WaitGroup
+}
+
+func Listen(address string, options Options) (*Listener, error) {
+	if options.HandshakeTimeout <= 0 {
+		options.HandshakeTimeout = DEFAULT_HANDSHAKE_TIMEOUT
+	}
+
+	tcpListener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("[Listen] - error listening to %s: %w", address, err)
+	}
+
+	return &Listener{
+		tcpListener: tcpListener,
+		options:     options,
+	}, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.tcpListener.Addr()
+}
+
+// Serve blocks until ctx is done, then closes the listener and waits for the running handshakes to return
+func (l *Listener) Serve(ctx context.Context, handler Handler) error {
+	stop := context.AfterFunc(ctx, func() {
+		l.tcpListener.Close()
+	})
+	defer stop()
+	defer l.handshakes.Wait()
+
+	for {
+		conn, err := l.tcpListener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			fmt.Println("[Listener.Serve] - Error accepting connection:", err)
+			continue
+		}
+
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetNoDelay(true)
+		}
+
+		l.handshakes.Add(1)
+		go l.handshake(ctx, conn, handler)
+	}
+}
+
+func (l *Listener) handshake(ctx context.Context, conn net.Conn, handler Handler) {
+	defer l.handshakes.Done()
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, l.options.HandshakeTimeout)
+	defer cancel()
+
+	// a deadline in the past wakes up any read or write the handler is blocked on
+	interrupt := context.AfterFunc(handshakeCtx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer interrupt()
+
+	handler(context.WithValue(handshakeCtx, interruptKey{}, interrupt), conn)
+}
+
+// HandOver ends the handshake of the connection served with ctx, for the handler to call right before passing the
+// connection on; false when the handshake timeout already interrupted it, the connection must then be closed
+func HandOver(ctx context.Context) bool {
+	interrupt, ok := ctx.Value(interruptKey{}).(func() bool)
+	if !ok {
+		return ctx.Err() == nil
+	}
+	return interrupt()
+}
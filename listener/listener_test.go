@@ -0,0 +1,211 @@
+package listener
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, options Options) *Listener {
+	t.Helper()
+
+	l, err := Listen("127.0.0.1:0", options)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return l
+}
+
+func serve(ctx context.Context, l *Listener, handler Handler) <-chan error {
+	served := make(chan error, 1)
+	go func() {
+		served <- l.Serve(ctx, handler)
+	}()
+	return served
+}
+
+func dial(t *testing.T, l *Listener) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServeStopsWhenContextIsCancelled(t *testing.T) {
+	l := listen(t, Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	served := serve(ctx, l, func(ctx context.Context, conn net.Conn) { conn.Close() })
+	cancel()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Serve to return right after cancellation")
+	}
+
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("expected the listener to be closed")
+	}
+}
+
+func TestSlowHandshakeDoesNotBlockOthers(t *testing.T) {
+	l := listen(t, Options{HandshakeTimeout: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handled := make(chan string, 2)
+	serve(ctx, l, func(ctx context.Context, conn net.Conn) {
+		defer conn.Close()
+
+		buffer := make([]byte, 1)
+		if _, err := io.ReadFull(conn, buffer); err != nil {
+			return
+		}
+		handled <- string(buffer)
+	})
+
+	dial(t, l) // never sends anything
+	fast := dial(t, l)
+	fast.Write([]byte("x"))
+
+	select {
+	case got := <-handled:
+		if got != "x" {
+			t.Errorf("expected the second client to be handled, got %q", got)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected the second handshake to run while the first one is stalled")
+	}
+}
+
+func TestHandshakeTimeoutInterruptsHandler(t *testing.T) {
+	l := listen(t, Options{HandshakeTimeout: 50 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	readErr := make(chan error, 1)
+	serve(ctx, l, func(ctx context.Context, conn net.Conn) {
+		defer conn.Close()
+
+		_, err := conn.Read(make([]byte, 1))
+		readErr <- err
+	})
+
+	dial(t, l)
+
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("expected the read to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the handshake timeout to interrupt the read")
+	}
+}
+
+func TestServeWaitsForRunningHandshakes(t *testing.T) {
+	l := listen(t, Options{HandshakeTimeout: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	served := serve(ctx, l, func(ctx context.Context, conn net.Conn) {
+		defer conn.Close()
+		close(started)
+
+		<-ctx.Done()
+		close(finished)
+	})
+
+	dial(t, l)
+	<-started
+	cancel()
+
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("expected Serve to return once the handshake saw the cancellation")
+	}
+
+	select {
+	case <-finished:
+	default:
+		t.Error("expected Serve to wait for the running handshake")
+	}
+}
+
+func TestConnectionSurvivesFinishedHandshake(t *testing.T) {
+	l := listen(t, Options{HandshakeTimeout: 50 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handedOver := make(chan net.Conn, 1)
+	serve(ctx, l, func(ctx context.Context, conn net.Conn) {
+		handedOver <- conn
+	})
+
+	viewer := dial(t, l)
+	conn := <-handedOver
+	defer conn.Close()
+
+	// well past the handshake timeout, the connection now belongs to whoever it was handed to
+	time.Sleep(100 * time.Millisecond)
+	viewer.Write([]byte("x"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Errorf("expected the connection to stay usable, got %v", err)
+	}
+}
+
+func TestHandOverStopsTheHandshakeTimeout(t *testing.T) {
+	l := listen(t, Options{HandshakeTimeout: 50 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handedOver := make(chan bool, 2)
+	serve(ctx, l, func(ctx context.Context, conn net.Conn) {
+		handedOver <- HandOver(ctx)
+		conn.Close()
+	})
+
+	dial(t, l)
+	if !<-handedOver {
+		t.Error("expected a connection handed over in time to be kept")
+	}
+}
+
+func TestHandOverAfterTheHandshakeTimeout(t *testing.T) {
+	l := listen(t, Options{HandshakeTimeout: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handedOver := make(chan bool, 1)
+	serve(ctx, l, func(ctx context.Context, conn net.Conn) {
+		defer conn.Close()
+
+		// the timeout interrupts the handler right before it passes the connection on
+		conn.Read(make([]byte, 1))
+		handedOver <- HandOver(ctx)
+	})
+
+	dial(t, l)
+	select {
+	case ok := <-handedOver:
+		if ok {
+			t.Error("expected a connection interrupted by the timeout not to be handed over")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the handshake to time out")
+	}
+}
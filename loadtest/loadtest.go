@@ -0,0 +1,338 @@
+// Package loadtest opens many viewer sessions against a cam server at once, sends them random speed and seek
+// commands and measures how late the packets arrive compared to their recorded timestamps
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/cam"
+	"go-opentibia-camplayerserver/library"
+	"go-opentibia-camplayerserver/viewer"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DIAL_TIMEOUT = 10 * time.Second
+	// wait of a viewer whose session failed before it tries again
+	RETRY_DELAY = time.Second
+)
+
+// Cam is what a viewer logs in to, with the recorded packets when the cam file could be read locally
+type Cam struct {
+	Name    string
+	Packets []cam.CamPacket
+}
+
+type Options struct {
+	Address  string
+	Viewer   viewer.Options
+	Cams     []Cam
+	Viewers  int           // sessions kept open at once
+	RampUp   time.Duration // the viewers connect evenly spread over it
+	Duration time.Duration // the test runs until the context is done when zero
+
+	// mean time between two commands of a viewer, no commands when zero
+	CommandInterval time.Duration
+	// the /speed commands pick one of these, besides the seek shortcuts
+	Speeds []float64
+	// seed of the random choices, so a run can be repeated
+	Seed uint64
+}
+
+// Test is one load test run, its report can be read while it runs
+type Test struct {
+	options Options
+	start   time.Time
+
+	mutex     sync.Mutex
+	report    Report
+	histogram latencyHistogram
+}
+
+func New(options Options) *Test {
+	if len(options.Speeds) == 0 {
+		options.Speeds = []float64{0.5, 1, 2, 4}
+	}
+	return &Test{options: options, report: Report{Errors: make(map[string]int)}}
+}
+
+// Run starts the viewers and keeps them connected until the duration is over or ctx is done, a viewer
+// whose session ends connects again to another cam
+func (t *Test) Run(ctx context.Context) (Report, error) {
+	if len(t.options.Cams) == 0 {
+		return Report{}, errors.New("no cams to play")
+	}
+	if t.options.Viewers <= 0 {
+		return Report{}, errors.New("no viewers to start")
+	}
+
+	if t.options.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.options.Duration)
+		defer cancel()
+	}
+
+	t.mutex.Lock()
+	t.start = time.Now()
+	t.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < t.options.Viewers; i++ {
+		delay := t.options.RampUp * time.Duration(i) / time.Duration(t.options.Viewers)
+		random := rand.New(rand.NewPCG(t.options.Seed, uint64(i)))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !sleep(ctx, delay) {
+				return
+			}
+			t.runViewer(ctx, random)
+		}()
+	}
+	wg.Wait()
+
+	return t.Report(), nil
+}
+
+// Report returns what was measured so far
+func (t *Test) Report() Report {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	report := t.report
+	report.Errors = make(map[string]int, len(t.report.Errors))
+	for kind, count := range t.report.Errors {
+		report.Errors[kind] = count
+	}
+	if !t.start.IsZero() {
+		report.Elapsed = time.Since(t.start)
+	}
+	report.Latency = t.histogram.stats()
+	return report
+}
+
+func (t *Test) runViewer(ctx context.Context, random *rand.Rand) {
+	for ctx.Err() == nil {
+		camToPlay := t.options.Cams[random.IntN(len(t.options.Cams))]
+		if !t.runSession(ctx, camToPlay, random) {
+			sleep(ctx, RETRY_DELAY)
+		}
+	}
+}
+
+// runSession plays one cam until the server closes the connection or ctx is done, returning false when it
+// failed
+func (t *Test) runSession(ctx context.Context, camToPlay Cam, random *rand.Rand) bool {
+	dialCtx, cancel := context.WithTimeout(ctx, DIAL_TIMEOUT)
+	c, err := viewer.Dial(dialCtx, t.options.Address, camToPlay.Name, t.options.Viewer)
+	cancel()
+	if err != nil {
+		if ctx.Err() != nil {
+			return true
+		}
+		t.failed("connect")
+		return false
+	}
+	defer c.Close()
+
+	t.update(func(r *Report) {
+		r.Sessions++
+		r.Connected++
+	})
+	defer t.update(func(r *Report) { r.Connected-- })
+
+	sessionCtx, stop := context.WithCancel(ctx)
+	// the read loop ends on the deadline once the test is over
+	context.AfterFunc(sessionCtx, func() { c.Conn().SetReadDeadline(time.Now()) })
+
+	tracker := newTracker(camToPlay.Packets)
+
+	var commands sync.WaitGroup
+	if t.options.CommandInterval > 0 {
+		commandRandom := rand.New(rand.NewPCG(random.Uint64(), random.Uint64()))
+		commands.Add(1)
+		go func() {
+			defer commands.Done()
+			t.sendCommands(sessionCtx, c, tracker, commandRandom)
+		}()
+	}
+
+	lastPacket, err := t.readPackets(c, tracker)
+	stopped := ctx.Err() != nil
+	stop()
+	commands.Wait()
+
+	switch {
+	case stopped:
+		return true
+	case errors.Is(err, io.EOF):
+		if text, ok := viewer.ParseLoginError(lastPacket); ok {
+			t.failed("login error: " + text)
+			return false
+		}
+		t.update(func(r *Report) { r.Completed++ })
+		return true
+	default:
+		t.failed("connection lost")
+		return false
+	}
+}
+
+// readPackets reads until the connection ends, returning the last packet received
+func (t *Test) readPackets(c *viewer.Client, tracker *tracker) ([]byte, error) {
+	var last []byte
+	for {
+		data, err := c.ReadPacket()
+		if err != nil {
+			return last, err
+		}
+		last = data
+		at := time.Now()
+
+		if message, ok := viewer.ParseTextMessage(data); ok && tracker.reply(message) {
+			continue
+		}
+		latency, matched, measured := tracker.packet(at, data)
+
+		t.mutex.Lock()
+		t.report.Packets++
+		t.report.Bytes += int64(len(data))
+		if !matched && tracker.packets != nil {
+			if _, isText := viewer.ParseTextMessage(data); !isText {
+				t.report.Unmatched++
+			}
+		}
+		if measured {
+			t.histogram.add(latency)
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// sendCommands sends a random command every now and then: a /speed to one of the speeds, or an arrow key seek
+func (t *Test) sendCommands(ctx context.Context, c *viewer.Client, tracker *tracker, random *rand.Rand) {
+	for {
+		wait := time.Duration(random.ExpFloat64() * float64(t.options.CommandInterval))
+		if !sleep(ctx, wait) {
+			return
+		}
+
+		tracker.commandSent()
+		var err error
+		switch random.IntN(4) {
+		case 0:
+			err = c.SeekForward()
+		case 1:
+			err = c.SeekBackward()
+		default:
+			err = c.Say(fmt.Sprintf("/speed %g", t.options.Speeds[random.IntN(len(t.options.Speeds))]))
+		}
+		if err != nil {
+			return
+		}
+		t.update(func(r *Report) { r.Commands++ })
+	}
+}
+
+func (t *Test) failed(kind string) {
+	t.update(func(r *Report) {
+		r.Failed++
+		r.Errors[kind]++
+	})
+}
+
+func (t *Test) update(change func(r *Report)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	change(&t.report)
+}
+
+// sleep waits for d, returning false when ctx was done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// LoadCams reads the named cams from the library directory, or all of its cams when no name is given. A
+// named cam missing from the directory is still played, without measuring its latency.
+func LoadCams(root string, names []string) ([]Cam, error) {
+	if len(names) == 0 {
+		files, err := library.New(root).Cams()
+		if err != nil {
+			return nil, err
+		}
+		names = files
+	}
+
+	var cams []Cam
+	for _, name := range names {
+		camToPlay := Cam{Name: library.CamName(name)}
+
+		for _, candidate := range []string{name, name + ".cam", name + ".cam.gz", name + ".cam.zst"} {
+			path := filepath.Join(root, candidate)
+			if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() || !library.IsCamFile(candidate) {
+				continue
+			}
+
+			packets, err := readPackets(path)
+			if err != nil {
+				return nil, err
+			}
+			camToPlay.Packets = packets
+			break
+		}
+
+		cams = append(cams, camToPlay)
+	}
+	return cams, nil
+}
+
+// LoadCam reads the cam played under name from a location, see cam.OpenSource for the locations understood
+func LoadCam(name string, location string) (Cam, error) {
+	packets, err := readPackets(location)
+	if err != nil {
+		return Cam{}, err
+	}
+	return Cam{Name: name, Packets: packets}, nil
+}
+
+func readPackets(path string) ([]cam.CamPacket, error) {
+	reader := cam.NewCamFileReader()
+	if err := reader.Open(path); err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var packets []cam.CamPacket
+	for {
+		packet, err := reader.NextPacket()
+		if errors.Is(err, io.EOF) {
+			return packets, nil
+		}
+		if parseErr := new(cam.ParseError); errors.As(err, &parseErr) {
+			continue // the server skips them as well
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+
+		// only the packets sent by the server are played
+		if packet.Type == "<" {
+			packets = append(packets, packet)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	// latencies are counted per millisecond up to the last bucket, which takes everything above
+	LATENCY_BUCKETS = 10001
+)
+
+// latencyHistogram counts packet latencies with millisecond resolution, early packets count as on time
+type latencyHistogram struct {
+	buckets [LATENCY_BUCKETS]int64
+	count   int64
+	total   time.Duration
+	max     time.Duration
+}
+
+func (h *latencyHistogram) add(latency time.Duration) {
+	latency = max(latency, 0)
+	bucket := min(int(latency/time.Millisecond), LATENCY_BUCKETS-1)
+
+	h.buckets[bucket]++
+	h.count++
+	h.total += latency
+	h.max = max(h.max, latency)
+}
+
+// percentile is the upper bound of the bucket holding the given fraction of the latencies
+func (h *latencyHistogram) percentile(fraction float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	target := int64(fraction*float64(h.count) + 0.5)
+	target = min(max(target, 1), h.count)
+
+	var seen int64
+	for bucket, count := range h.buckets {
+		seen += count
+		if seen >= target {
+			if bucket == LATENCY_BUCKETS-1 {
+				return h.max
+			}
+			return min(time.Duration(bucket+1)*time.Millisecond, h.max)
+		}
+	}
+	return h.max
+}
+
+type LatencyStats struct {
+	Samples int64
+	Mean    time.Duration
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+func (h *latencyHistogram) stats() LatencyStats {
+	stats := LatencyStats{
+		Samples: h.count,
+		P50:     h.percentile(0.50),
+		P90:     h.percentile(0.90),
+		P99:     h.percentile(0.99),
+		Max:     h.max,
+	}
+	if h.count > 0 {
+		stats.Mean = h.total / time.Duration(h.count)
+	}
+	return stats
+}
+
+// Report is what a load test measured so far
+type Report struct {
+	Elapsed time.Duration
+
+	Connected int // viewers with a session right now
+	Sessions  int // sessions started, including the ones that ended
+	Completed int // sessions closed by the server, at the end of their cam
+	Failed    int // sessions that could not start or ended with an error
+
+	Packets   int64 // game packets received
+	Unmatched int64 // game packets that are not in the recording of the cam
+	Bytes     int64 // decrypted payload bytes received
+	Commands  int64 // speed and seek commands sent
+
+	Latency LatencyStats
+
+	// failures by what went wrong
+	Errors map[string]int
+}
+
+func (r Report) PacketsPerSecond() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Packets) / r.Elapsed.Seconds()
+}
+
+func (r Report) BytesPerSecond() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+// Print writes the report in a few human readable lines
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "elapsed %s, %d connected, %d sessions (%d completed, %d failed), %d commands\n",
+		r.Elapsed.Round(time.Second), r.Connected, r.Sessions, r.Completed, r.Failed, r.Commands)
+	fmt.Fprintf(w, "received %d packets (%d unmatched), %.1f packets/s, %.1f KB/s\n",
+		r.Packets, r.Unmatched, r.PacketsPerSecond(), r.BytesPerSecond()/1024)
+
+	if r.Latency.Samples > 0 {
+		fmt.Fprintf(w, "latency over %d packets: mean %s, p50 %s, p90 %s, p99 %s, max %s\n",
+			r.Latency.Samples, r.Latency.Mean.Round(time.Microsecond), r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max.Round(time.Microsecond))
+	}
+
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "  %d x %s\n", r.Errors[kind], kind)
+	}
+}
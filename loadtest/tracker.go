@@ -0,0 +1,120 @@
+package loadtest
+
+import (
+	"bytes"
+	"fmt"
+	"go-opentibia-camplayerserver/cam"
+	"go-opentibia-camplayerserver/viewer"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracker follows where a session is in its cam to tell how late each packet arrives. The first packet
+// after the login or a command reply is the reference, later ones are expected at its arrival plus their
+// recorded distance to it divided by the speed; commands change what the server plays next, so nothing is
+// measured while one is waiting for its reply.
+type tracker struct {
+	packets []cam.CamPacket // nil when the cam could not be read, nothing is measured then
+
+	mutex   sync.Mutex
+	waiting int // commands sent without a reply yet
+
+	cursor       int // index of the last packet matched, -1 before the first
+	speed        float64
+	hasReference bool
+	refArrival   time.Time
+	refTimestamp int64
+}
+
+func newTracker(packets []cam.CamPacket) *tracker {
+	return &tracker{packets: packets, cursor: -1, speed: 1.0}
+}
+
+// commandSent is called before sending a command that gets a reply
+func (t *tracker) commandSent() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.waiting++
+}
+
+// reply handles a text message, returning whether it was the reply to a command
+func (t *tracker) reply(message viewer.TextMessage) bool {
+	var speed float64
+	switch {
+	case strings.HasPrefix(message.Text, "Speed set to"):
+		if _, err := fmt.Sscanf(message.Text, "Speed set to %fx.", &speed); err != nil {
+			return false
+		}
+	case strings.HasPrefix(message.Text, "Speed must be between"),
+		strings.HasPrefix(message.Text, "Moved to"),
+		strings.HasPrefix(message.Text, "Failed to seek"):
+	default:
+		return false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.waiting > 0 {
+		t.waiting--
+	}
+	if speed > 0 {
+		t.speed = speed
+	}
+	t.hasReference = false
+	return true
+}
+
+// packet handles a game packet arriving at, returning how late it is when it can be told
+func (t *tracker) packet(at time.Time, data []byte) (latency time.Duration, matched bool, measured bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	index, ok := t.match(data)
+	if !ok {
+		// the server messages are not in the recording, anything else is another cam of a playlist and
+		// the reference is lost
+		if _, isText := viewer.ParseTextMessage(data); !isText {
+			t.hasReference = false
+		}
+		return 0, false, false
+	}
+	restarted := index <= t.cursor
+	t.cursor = index
+	timestamp := t.packets[index].Timestamp
+
+	if t.waiting > 0 {
+		return 0, true, false
+	}
+	if !t.hasReference || restarted {
+		t.hasReference = true
+		t.refArrival = at
+		t.refTimestamp = timestamp
+		return 0, true, false
+	}
+
+	due := t.refArrival.Add(time.Duration(float64(timestamp-t.refTimestamp) * float64(time.Millisecond) / t.speed))
+	return at.Sub(due), true, true
+}
+
+// match finds the cam packet data is: the one after the last, the first one when the server started over
+// for a backward seek or a loop, or else the next one with the same data
+func (t *tracker) match(data []byte) (int, bool) {
+	if len(t.packets) == 0 {
+		return 0, false
+	}
+
+	if next := t.cursor + 1; next < len(t.packets) && bytes.Equal(t.packets[next].Data, data) {
+		return next, true
+	}
+	if bytes.Equal(t.packets[0].Data, data) {
+		return 0, true
+	}
+	for i := t.cursor + 2; i < len(t.packets); i++ {
+		if bytes.Equal(t.packets[i].Data, data) {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,109 @@
+package loadtest
+
+import (
+	"go-opentibia-camplayerserver/cam"
+	"go-opentibia-camplayerserver/protocol"
+	"go-opentibia-camplayerserver/viewer"
+	"testing"
+	"time"
+)
+
+func testPackets() []cam.CamPacket {
+	return []cam.CamPacket{
+		{Timestamp: 0, Type: "<", Data: []byte{0xa1}},
+		{Timestamp: 100, Type: "<", Data: []byte{0xa2}},
+		{Timestamp: 200, Type: "<", Data: []byte{0xa3}},
+		{Timestamp: 300, Type: "<", Data: []byte{0xa4}},
+		{Timestamp: 400, Type: "<", Data: []byte{0xa5}},
+	}
+}
+
+func TestTrackerMeasuresAgainstTheFirstPacket(t *testing.T) {
+	tracker := newTracker(testPackets())
+	start := time.Unix(1000, 0)
+
+	if _, matched, measured := tracker.packet(start, []byte{0xa1}); !matched || measured {
+		t.Fatalf("expected the first packet to be the reference, got matched %v measured %v", matched, measured)
+	}
+
+	latency, matched, measured := tracker.packet(start.Add(130*time.Millisecond), []byte{0xa2})
+	if !matched || !measured || latency != 30*time.Millisecond {
+		t.Errorf("expected the second packet 30ms late, got %s (matched %v measured %v)", latency, matched, measured)
+	}
+
+	// a skipped packet, like a ping left out by the idle skipping, is matched further on
+	latency, _, measured = tracker.packet(start.Add(290*time.Millisecond), []byte{0xa4})
+	if !measured || latency != -10*time.Millisecond {
+		t.Errorf("expected the fourth packet 10ms early, got %s", latency)
+	}
+}
+
+func TestTrackerWaitsForCommandReplies(t *testing.T) {
+	tracker := newTracker(testPackets())
+	start := time.Unix(1000, 0)
+
+	tracker.packet(start, []byte{0xa1})
+	tracker.commandSent()
+
+	// the packets of a seek come in a burst before the reply
+	if _, matched, measured := tracker.packet(start.Add(time.Millisecond), []byte{0xa2}); !matched || measured {
+		t.Errorf("expected no measure while waiting for the reply, got matched %v measured %v", matched, measured)
+	}
+
+	if !tracker.reply(viewer.TextMessage{Text: "Speed set to 2.00x."}) {
+		t.Fatal("expected the speed reply to be recognized")
+	}
+	if tracker.reply(viewer.TextMessage{Text: "Speed 2.00x, 00:01 of 00:10"}) {
+		t.Error("expected a status line not to be taken for a reply")
+	}
+
+	tracker.packet(start.Add(time.Second), []byte{0xa3})
+	latency, _, measured := tracker.packet(start.Add(time.Second+60*time.Millisecond), []byte{0xa4})
+	if !measured || latency != 10*time.Millisecond {
+		t.Errorf("expected the packet 10ms late at 2x, got %s (measured %v)", latency, measured)
+	}
+}
+
+func TestTrackerFollowsARestart(t *testing.T) {
+	tracker := newTracker(testPackets())
+	start := time.Unix(1000, 0)
+
+	tracker.packet(start, []byte{0xa1})
+	tracker.packet(start.Add(100*time.Millisecond), []byte{0xa2})
+
+	// a backward seek or a loop starts over with the first packet
+	if _, matched, measured := tracker.packet(start.Add(150*time.Millisecond), []byte{0xa1}); !matched || measured || tracker.cursor != 0 {
+		t.Errorf("expected the first packet to match again as the new reference, got matched %v measured %v at %d", matched, measured, tracker.cursor)
+	}
+	if latency, _, _ := tracker.packet(start.Add(250*time.Millisecond), []byte{0xa2}); latency != 0 {
+		t.Errorf("expected the packets after the restart on time, got %s", latency)
+	}
+
+	// text messages of the server are not in the recording but keep the reference
+	message := protocol.MESSAGE_STATUS_SMALL
+	if _, matched, _ := tracker.packet(start, append([]byte{viewer.OPCODE_TEXT_MESSAGE, byte(message), 2, 0}, "hi"...)); matched || !tracker.hasReference {
+		t.Errorf("expected a text message to be unmatched and keep the reference, got matched %v", matched)
+	}
+
+	// packets of another cam lose it
+	if _, matched, _ := tracker.packet(start, []byte{0xff}); matched || tracker.hasReference {
+		t.Errorf("expected an unknown packet to lose the reference, got matched %v", matched)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var histogram latencyHistogram
+	for i := 1; i <= 100; i++ {
+		histogram.add(time.Duration(i) * time.Millisecond)
+	}
+	histogram.add(-5 * time.Millisecond)
+	histogram.add(time.Minute)
+
+	stats := histogram.stats()
+	if stats.Samples != 102 || stats.Max != time.Minute {
+		t.Errorf("expected 102 samples up to a minute, got %+v", stats)
+	}
+	if stats.P50 != 51*time.Millisecond || stats.P90 != 92*time.Millisecond || stats.P99 != 101*time.Millisecond {
+		t.Errorf("unexpected percentiles %+v", stats)
+	}
+}
@@ -0,0 +1,129 @@
+package login
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go-opentibia-camplayerserver/crypt"
+	"go-opentibia-camplayerserver/packet"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// a game login is 1 protocol byte, 4 bytes of os and version and one 128 bytes RSA block; the rest is headroom for newer clients
+	MAX_LOGIN_PACKET_SIZE = 1024
+	RSA_BLOCK_SIZE        = 128
+
+	// clients from 10.00 send their full client version between the protocol version and the RSA block, clients
+	// from 10.71 their dat revision and preview state as well
+	CLIENT_VERSION_PROTOCOL_VERSION   = 1000
+	CONTENT_REVISION_PROTOCOL_VERSION = 1071
+
+	DEFAULT_LOGIN_TIMEOUT = 10 * time.Second
+)
+
+type Request struct {
+	ClientOs          uint16
+	ProtocolVersion   uint16
+	XteaKey           [4]uint32
+	GamemasterFlag    uint8
+	AccountNumber     uint32
+	Character         string
+	Password          string
+	OTCv8StringLength uint16
+	OTCv8String       string
+	OTCv8Version      uint16
+	IsValid           bool
+}
+
+// ReadRequest reads one framed login message from conn, giving up after timeout
+func ReadRequest(conn net.Conn, decrypter crypt.Decrypter, timeout time.Duration) (Request, error) {
+	if timeout <= 0 {
+		timeout = DEFAULT_LOGIN_TIMEOUT
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	header := make([]byte, packet.HEADER_LENGTH)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return Request{}, fmt.Errorf("[ReadRequest] - error reading header: %w", err)
+	}
+
+	messageLength := int(binary.LittleEndian.Uint16(header))
+	if messageLength == 0 || messageLength > MAX_LOGIN_PACKET_SIZE {
+		return Request{}, fmt.Errorf("[ReadRequest] - invalid login message length %d", messageLength)
+	}
+
+	message := packet.NewIncoming(messageLength)
+	if _, err := io.ReadFull(conn, message.PeekBuffer()); err != nil {
+		return Request{}, fmt.Errorf("[ReadRequest] - error reading message: %w", err)
+	}
+
+	return ParseRequest(message, decrypter)
+}
+
+// ParseRequest parses a login message without its length header
+func ParseRequest(message *packet.Incoming, decrypter crypt.Decrypter) (Request, error) {
+	var request Request
+	request.IsValid = false
+
+	// clients from 8.30 on start with a checksum, it is detected by value since the protocol version comes after it
+	message.SkipChecksum()
+
+	message.GetUint8() // protocol id
+
+	request.ClientOs = message.GetUint16()
+	request.ProtocolVersion = message.GetUint16()
+
+	if err := message.Err(); err != nil {
+		return request, fmt.Errorf("[ParseRequest] - truncated login header: %w", err)
+	}
+
+	if message.Remaining() < RSA_BLOCK_SIZE {
+		return request, fmt.Errorf("[ParseRequest] - expected %d encrypted bytes, got %d", RSA_BLOCK_SIZE, message.Remaining())
+	}
+
+	// the RSA block ends the message, what newer clients send ahead of it is not needed to play a cam
+	message.SkipBytes(message.Remaining() - RSA_BLOCK_SIZE)
+
+	decryptedMsg, err := decrypter.DecryptNoPadding(message.PeekBuffer())
+	if err != nil {
+		return request, fmt.Errorf("[ParseRequest] - error while decrypting packet: %w", err)
+	}
+
+	copy(message.PeekBuffer(), decryptedMsg)
+
+	if message.GetUint8() != 0 {
+		return request, fmt.Errorf("[ParseRequest] - error decrypted packet's first byte is not zero")
+	}
+
+	request.XteaKey[0] = message.GetUint32()
+	request.XteaKey[1] = message.GetUint32()
+	request.XteaKey[2] = message.GetUint32()
+	request.XteaKey[3] = message.GetUint32()
+
+	request.GamemasterFlag = message.GetUint8()
+	request.AccountNumber = message.GetUint32()
+	request.Character = message.GetString()
+	request.Password = message.GetString()
+
+	if err := message.Err(); err != nil {
+		return request, fmt.Errorf("[ParseRequest] - malformed login data: %w", err)
+	}
+
+	// the OTCv8 extension is optional, a regular client ends the message with RSA padding
+	request.OTCv8StringLength = message.GetUint16()
+	if request.OTCv8StringLength == 5 {
+		request.OTCv8String = message.GetStringSlice(int(request.OTCv8StringLength))
+		if request.OTCv8String == "OTCv8" {
+			request.OTCv8Version = message.GetUint16()
+		}
+	}
+
+	///TODO: add validations: empty account number, wrong client version, account locked, ip banned and wrong account id
+
+	request.IsValid = true
+	return request, nil
+}
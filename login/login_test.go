@@ -0,0 +1,225 @@
+package login
+
+import (
+	"encoding/binary"
+	"errors"
+	"go-opentibia-camplayerserver/packet"
+	"hash/adler32"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// plainDecrypter lets tests and fuzzing build login blocks without RSA
+type plainDecrypter struct{}
+
+func (plainDecrypter) DecryptNoPadding(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) != RSA_BLOCK_SIZE {
+		return nil, errors.New("invalid ciphertext length")
+	}
+	return append([]byte(nil), ciphertext...), nil
+}
+
+func buildRsaBlock(xteaKey [4]uint32, account uint32, character string, password string, otcv8 bool) []byte {
+	block := make([]byte, 0, RSA_BLOCK_SIZE)
+	block = append(block, 0x00)
+	for _, k := range xteaKey {
+		block = binary.LittleEndian.AppendUint32(block, k)
+	}
+	block = append(block, 0x00) // gamemaster flag
+	block = binary.LittleEndian.AppendUint32(block, account)
+	block = binary.LittleEndian.AppendUint16(block, uint16(len(character)))
+	block = append(block, character...)
+	block = binary.LittleEndian.AppendUint16(block, uint16(len(password)))
+	block = append(block, password...)
+	if otcv8 {
+		block = binary.LittleEndian.AppendUint16(block, 5)
+		block = append(block, "OTCv8"...)
+		block = binary.LittleEndian.AppendUint16(block, 3)
+	}
+
+	padded := make([]byte, RSA_BLOCK_SIZE)
+	copy(padded, block)
+	return padded
+}
+
+func buildLoginMessage(rsaBlock []byte) []byte {
+	message := []byte{0x0A}
+	message = binary.LittleEndian.AppendUint16(message, 2)   // client os
+	message = binary.LittleEndian.AppendUint16(message, 860) // protocol version
+	return append(message, rsaBlock...)
+}
+
+func newIncoming(data []byte) *packet.Incoming {
+	message := packet.NewIncoming(len(data))
+	copy(message.PeekBuffer(), data)
+	return message
+}
+
+func TestParseRequest(t *testing.T) {
+	xteaKey := [4]uint32{1, 2, 3, 4}
+	message := buildLoginMessage(buildRsaBlock(xteaKey, 1234, "Test_2_25-10-2024-18-36-45", "secret", true))
+
+	request, err := ParseRequest(newIncoming(message), plainDecrypter{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !request.IsValid {
+		t.Error("expected request to be valid")
+	}
+	if request.ProtocolVersion != 860 || request.ClientOs != 2 {
+		t.Errorf("unexpected os %d and version %d", request.ClientOs, request.ProtocolVersion)
+	}
+	if request.XteaKey != xteaKey {
+		t.Errorf("expected xtea key %v, got %v", xteaKey, request.XteaKey)
+	}
+	if request.AccountNumber != 1234 || request.Character != "Test_2_25-10-2024-18-36-45" || request.Password != "secret" {
+		t.Errorf("unexpected credentials %d %s %s", request.AccountNumber, request.Character, request.Password)
+	}
+	if request.OTCv8String != "OTCv8" || request.OTCv8Version != 3 {
+		t.Errorf("expected OTCv8 version 3, got %s %d", request.OTCv8String, request.OTCv8Version)
+	}
+}
+
+func TestParseRequestWithChecksum(t *testing.T) {
+	message := buildLoginMessage(buildRsaBlock([4]uint32{1, 2, 3, 4}, 1, "cam", "pw", false))
+	withChecksum := binary.LittleEndian.AppendUint32(nil, adler32.Checksum(message))
+	withChecksum = append(withChecksum, message...)
+
+	request, err := ParseRequest(newIncoming(withChecksum), plainDecrypter{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if request.ProtocolVersion != 860 || request.Character != "cam" {
+		t.Errorf("unexpected request %+v", request)
+	}
+}
+
+func TestParseRequestSkipsTheClientVersion(t *testing.T) {
+	message := []byte{0x0A}
+	message = binary.LittleEndian.AppendUint16(message, 2)    // client os
+	message = binary.LittleEndian.AppendUint16(message, 1100) // protocol version
+	message = binary.LittleEndian.AppendUint32(message, 1100) // client version
+	message = binary.LittleEndian.AppendUint16(message, 0x3f) // dat revision
+	message = append(message, 0x00)                           // preview state
+	message = append(message, buildRsaBlock([4]uint32{1, 2, 3, 4}, 1, "cam", "pw", false)...)
+
+	request, err := ParseRequest(newIncoming(message), plainDecrypter{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if request.ProtocolVersion != 1100 || request.XteaKey != [4]uint32{1, 2, 3, 4} || request.Character != "cam" {
+		t.Errorf("unexpected request %+v", request)
+	}
+}
+
+func TestParseRequestMalformed(t *testing.T) {
+	validBlock := buildRsaBlock([4]uint32{1, 2, 3, 4}, 1, "cam", "pw", false)
+
+	oversizedString := append([]byte(nil), validBlock...)
+	binary.LittleEndian.PutUint16(oversizedString[22:], 0xFFFF) // character length points past the block
+
+	nonZeroFirstByte := append([]byte(nil), validBlock...)
+	nonZeroFirstByte[0] = 0x01
+
+	tests := []struct {
+		name    string
+		message []byte
+	}{
+		{"empty", []byte{}},
+		{"truncated header", []byte{0x0A, 0x02}},
+		{"short rsa block", buildLoginMessage(validBlock[:64])},
+		{"string past the end", buildLoginMessage(oversizedString)},
+		{"first byte not zero", buildLoginMessage(nonZeroFirstByte)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := ParseRequest(newIncoming(tt.message), plainDecrypter{})
+			if err == nil {
+				t.Error("expected an error, got none")
+			}
+			if request.IsValid {
+				t.Error("expected request to be invalid")
+			}
+		})
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	message := buildLoginMessage(buildRsaBlock([4]uint32{5, 6, 7, 8}, 1, "cam", "pw", false))
+	framed := binary.LittleEndian.AppendUint16(nil, uint16(len(message)))
+	framed = append(framed, message...)
+
+	// split the frame in small writes, a single Read is not guaranteed to get the whole message
+	go func() {
+		for i := 0; i < len(framed); i += 7 {
+			end := min(i+7, len(framed))
+			client.Write(framed[i:end])
+		}
+	}()
+
+	request, err := ReadRequest(server, plainDecrypter{}, time.Second)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if request.Character != "cam" || request.XteaKey != [4]uint32{5, 6, 7, 8} {
+		t.Errorf("unexpected request %+v", request)
+	}
+}
+
+func TestReadRequestTooLarge(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go client.Write(binary.LittleEndian.AppendUint16(nil, MAX_LOGIN_PACKET_SIZE+1))
+
+	_, err := ReadRequest(server, plainDecrypter{}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "invalid login message length") {
+		t.Errorf("expected invalid length error, got %v", err)
+	}
+}
+
+func TestReadRequestTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// the header arrives but the message body never does
+	go client.Write(binary.LittleEndian.AppendUint16(nil, 135))
+
+	start := time.Now()
+	_, err := ReadRequest(server, plainDecrypter{}, 50*time.Millisecond)
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("expected ReadRequest to give up after the timeout, took %s", time.Since(start))
+	}
+}
+
+func FuzzParseRequest(f *testing.F) {
+	f.Add(buildLoginMessage(buildRsaBlock([4]uint32{1, 2, 3, 4}, 1, "cam", "pw", false)))
+	f.Add(buildLoginMessage(buildRsaBlock([4]uint32{1, 2, 3, 4}, 1, "cam", "pw", true)))
+	f.Add([]byte{0x0A, 0x02, 0x00, 0x5C, 0x03})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		request, err := ParseRequest(newIncoming(data), plainDecrypter{})
+		if err == nil && !request.IsValid {
+			t.Errorf("expected request without error to be valid")
+		}
+	})
+}
@@ -1,228 +1,399 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
+	"go-opentibia-camplayerserver/access"
 	"go-opentibia-camplayerserver/cam"
 	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/command"
 	"go-opentibia-camplayerserver/config"
 	"go-opentibia-camplayerserver/crypt"
+	"go-opentibia-camplayerserver/library"
+	"go-opentibia-camplayerserver/listener"
+	"go-opentibia-camplayerserver/login"
 	"go-opentibia-camplayerserver/packet"
 	"go-opentibia-camplayerserver/protocol"
-	"io"
+	"go-opentibia-camplayerserver/session"
+	"go-opentibia-camplayerserver/status"
+	"go-opentibia-camplayerserver/welcome"
 	"net"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-type LoginRequest struct {
-	ClientOs          uint16
-	ProtocolVersion   uint16
-	XteaKey           [4]uint32
-	GamemasterFlag    uint8
-	AccountNumber     uint32
-	Character         string
-	Password          string
-	OTCv8StringLength uint16
-	OTCv8String       string
-	OTCv8Version      uint16
-	IsValid           bool
+// camServer holds what the cam server needs to turn an accepted connection into a session
+type camServer struct {
+	decrypter        crypt.Decrypter
+	accessController *access.Controller
+	sessions         *session.Manager
+	writerOptions    client.WriterOptions
+	// swapped as a whole when the config is reloaded, a connection keeps the one it was accepted with
+	current atomic.Pointer[serverConfig]
 }
 
-func startCamServer(closeCamServerCh <-chan struct{}, wg *sync.WaitGroup, decrypter *crypt.RSA, cfg *config.Config) {
+type serverConfig struct {
+	cfg     *config.Config
+	library *library.Library
+}
+
+func (s *camServer) configure(cfg *config.Config, camLibrary *library.Library) {
+	s.current.Store(&serverConfig{cfg: cfg, library: camLibrary})
+}
+
+func startCamServer(ctx context.Context, wg *sync.WaitGroup, server *camServer) {
 	defer wg.Done()
 
-	fmt.Printf("Cam server starting to listen to %s:%d\n", cfg.CamServer.HostName, cfg.CamServer.Port)
+	cfg := server.current.Load().cfg
+	address := fmt.Sprintf("%s:%d", cfg.CamServer.HostName, cfg.CamServer.Port)
+	fmt.Printf("Cam server starting to listen to %s\n", address)
 
-	tcpListener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.CamServer.HostName, cfg.CamServer.Port))
+	camListener, err := listener.Listen(address, listener.Options{
+		HandshakeTimeout: time.Duration(cfg.CamServer.LoginTimeout) * time.Second,
+	})
 	if err != nil {
 		fmt.Println("[startCamServer] - Error starting server:", err)
 		return
 	}
-	defer tcpListener.Close()
-
-	for {
-		select {
-		case <-closeCamServerCh:
-			fmt.Printf("CamServer is shutting down and no longer accepting connections.\n")
-			return
-		default:
-
-			// timeout to avoid infinite lock at Accept and be able to handle closeCamServer channel
-			tcpListener.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second))
-
-			tcpConnection, err := tcpListener.Accept()
-			if err != nil {
-				if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
-					continue
-				}
-				fmt.Println("[startCamServer] - Error accepting connection:", err)
-				continue
-			}
 
-			if tcpConn, ok := tcpConnection.(*net.TCPConn); ok {
-				tcpConn.SetNoDelay(true)
-			}
+	if err := camListener.Serve(ctx, server.handleConnection); err != nil {
+		fmt.Println("[startCamServer] - Error serving connections:", err)
+	}
 
-			loginRequest, err := handleClientLoginRequest(tcpConnection, decrypter, cfg)
-			if err != nil {
-				fmt.Println("[startCamServer] - Error handling client login request:", err)
-			}
+	fmt.Printf("CamServer is shutting down and no longer accepting connections.\n")
+}
 
-			if loginRequest.IsValid {
-				fmt.Printf("Request Received: clientOs %d; protocolVersion: %d; accountNumber: %d; character %s; password %s; otcv8: \n\tstrlen %d\n\tstr: %s\n\tversion: %d\n", loginRequest.ClientOs, loginRequest.ProtocolVersion, loginRequest.AccountNumber, loginRequest.Character, loginRequest.Password, loginRequest.OTCv8StringLength, loginRequest.OTCv8String, loginRequest.OTCv8Version)
-
-				client := &client.Client{
-					Conn:      tcpConnection,
-					FileId:    loginRequest.Character,
-					XteaKey:   loginRequest.XteaKey,
-					CancelCh:  closeCamServerCh,
-					CommandCh: make(chan string),
-				}
-
-				wg.Add(1)
-				go cam.HandleCamFileStreaming(wg, client, "Test_2_25-10-2024-18-36-45.cam")
-				wg.Add(1)
-				go handleClientInputPackets(wg, client)
-			}
+// handleConnection runs the login handshake of a new connection and starts its session
+func (s *camServer) handleConnection(ctx context.Context, conn net.Conn) {
+	current := s.current.Load()
 
-		}
+	remoteIp, err := access.RemoteIp(conn)
+	if err != nil {
+		fmt.Println("[handleConnection] - Error reading remote address:", err)
+		conn.Close()
+		return
 	}
-}
 
-func main() {
+	accessErr := s.accessController.AllowConnection(remoteIp)
+	if errors.Is(accessErr, access.ErrRateLimited) {
+		// answering would need the RSA decryption the rate limit is protecting, so just drop it
+		conn.Close()
+		return
+	}
 
-	var wg sync.WaitGroup
-	stopCh := make(chan struct{})
+	loginRequest, err := login.ReadRequest(conn, s.decrypter, time.Duration(current.cfg.CamServer.LoginTimeout)*time.Second)
+	if err != nil {
+		fmt.Println("[handleConnection] - Error handling client login request:", err)
+		s.accessController.LoginFailed(remoteIp)
+	}
 
-	// Capture SIGINT and SIGTERM for graceful shutdown
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	if !loginRequest.IsValid {
+		conn.Close()
+		return
+	}
 
-	go func() {
-		<-signalChan // Block until a shutdown signal is received
-		fmt.Println("Shutdown signal received")
-		close(stopCh) // Notify server to stop accepting new connections
-	}()
+	// the request carries the password, it is only logged to debug logins
+	if current.cfg.LogLevel == config.LOG_LEVEL_DEBUG {
+		fmt.Printf("Request Received: clientOs %d; protocolVersion: %d; accountNumber: %d; character %s; password %s; otcv8: \n\tstrlen %d\n\tstr: %s\n\tversion: %d\n", loginRequest.ClientOs, loginRequest.ProtocolVersion, loginRequest.AccountNumber, loginRequest.Character, loginRequest.Password, loginRequest.OTCv8StringLength, loginRequest.OTCv8String, loginRequest.OTCv8Version)
+	}
 
-	config, err := config.LoadConfig()
+	camClient := &client.Client{
+		Conn:        conn,
+		FileId:      loginRequest.Character,
+		XteaKey:     loginRequest.XteaKey,
+		CommandCh:   make(chan command.Command),
+		Framing:     packet.FramingForProtocolVersion(loginRequest.ProtocolVersion),
+		Compression: current.cfg.CamServer.Compression,
+	}
+
+	if accessErr != nil {
+		rejectClient(camClient, remoteIp, accessErr)
+		return
+	}
+
+	// the listener may already have interrupted the connection, there is no point in starting a session then
+	if ctx.Err() != nil {
+		fmt.Printf("[handleConnection] - Login from %s took too long: %v\n", remoteIp, ctx.Err())
+		conn.Close()
+		return
+	}
+
+	playlist, err := current.resolvePlaylist(camClient.FileId)
 	if err != nil {
-		fmt.Printf("Error loading config: %v", err)
+		rejectClient(camClient, remoteIp, err)
+		return
 	}
 
-	rsaDecrypter, err := crypt.NewRSADecrypter(config.RSAKeyFile)
+	releaseSession, err := s.accessController.AcquireSession(remoteIp)
 	if err != nil {
-		fmt.Println("Error loading private key:", err)
-		os.Exit(1)
+		rejectClient(camClient, remoteIp, err)
+		return
 	}
 
-	fmt.Println("Starting Cam Server goroutine...")
-	wg.Add(1)
-	go startCamServer(stopCh, &wg, rsaDecrypter, &config)
+	// past this point the handshake timeout must not interrupt the session using the connection
+	if !listener.HandOver(ctx) {
+		fmt.Printf("[handleConnection] - Login from %s took too long\n", remoteIp)
+		releaseSession()
+		conn.Close()
+		return
+	}
 
-	wg.Wait()
-	fmt.Println("Server shutdown gracefully")
+	camClient.Writer = client.NewWriter(conn, s.writerOptions)
 
+	if _, err := s.sessions.Start(camClient, playlist, releaseSession); err != nil {
+		releaseSession()
+		rejectClient(camClient, remoteIp, err)
+	}
 }
 
-func handleClientLoginRequest(conn net.Conn, decrypter *crypt.RSA, cfg *config.Config) (LoginRequest, error) {
-	//defer conn.Close()
+var errCamUnavailable = errors.New("This cam is not available.")
+
+// resolvePlaylist finds what the viewer asked for in the library, falling back to the default cam
+func (c *serverConfig) resolvePlaylist(fileId string) (library.Playlist, error) {
+	playlist, err := c.library.Resolve(fileId)
+	if errors.Is(err, library.ErrNotFound) && c.cfg.CamServer.DefaultCam != "" {
+		return c.library.Resolve(c.cfg.CamServer.DefaultCam)
+	}
+	if err != nil && !errors.Is(err, library.ErrNotFound) {
+		// the details are for the server log, not for the viewer
+		fmt.Printf("[resolvePlaylist] - Error resolving %q: %v\n", fileId, err)
+		return playlist, errCamUnavailable
+	}
+	return playlist, err
+}
 
-	const INCOMING_PACKET_SIZE = 65535
-	packet := packet.NewIncoming(INCOMING_PACKET_SIZE)
-	var request LoginRequest
-	request.IsValid = false
+func rejectClient(c *client.Client, remoteIp net.IP, reason error) {
+	fmt.Printf("[handleConnection] - Rejecting connection from %s: %v\n", remoteIp, reason)
+	protocol.SendClientError(c, reason.Error())
+	c.Close()
+}
 
-	reqLen, err := conn.Read(packet.PeekBuffer())
+func newWriterOptions(cfg *config.Config) (client.WriterOptions, error) {
+	policy, err := client.ParseFullQueuePolicy(cfg.CamServer.SendQueuePolicy)
 	if err != nil {
-		return request, fmt.Errorf("[handleClient] - error reading: %w", err)
+		return client.WriterOptions{}, err
 	}
-	packet.Resize(reqLen)
-
-	packet.GetUint16() // message size
-	packet.GetUint8()
 
-	request.ClientOs = packet.GetUint16()
-	request.ProtocolVersion = packet.GetUint16()
+	return client.WriterOptions{
+		QueueSize:    cfg.CamServer.SendQueueSize,
+		WriteTimeout: time.Duration(cfg.CamServer.WriteTimeout) * time.Second,
+		Policy:       policy,
+	}, nil
+}
 
-	decryptedMsg, err := decrypter.DecryptNoPadding(packet.PeekBuffer())
+func newAccessController(cfg *config.Config) (*access.Controller, error) {
+	banList, err := access.LoadBanList(cfg.Access.BanListFile)
 	if err != nil {
-		return request, fmt.Errorf("[parseLogin] - error while decrypting packet: %w", err)
+		return nil, err
 	}
 
-	copy(packet.PeekBuffer(), decryptedMsg)
+	return access.NewController(newAccessLimits(cfg), banList), nil
+}
 
-	if packet.GetUint8() != 0 {
-		return request, fmt.Errorf("[parseLogin] - error decrypted packet's first byte is not zero")
+func newAccessLimits(cfg *config.Config) access.Limits {
+	return access.Limits{
+		ConnectionsPerMinute: cfg.Access.ConnectionsPerMinute,
+		MaxSessionsPerIp:     cfg.Access.MaxSessionsPerIp,
+		MaxSessions:          cfg.Access.MaxSessions,
+		LoginFailureLimit:    cfg.Access.LoginFailureLimit,
+		LoginFailureLockout:  time.Duration(cfg.Access.LoginFailureLockout) * time.Second,
 	}
+}
 
-	request.XteaKey[0] = packet.GetUint32()
-	request.XteaKey[1] = packet.GetUint32()
-	request.XteaKey[2] = packet.GetUint32()
-	request.XteaKey[3] = packet.GetUint32()
+// newPlayerOptions builds the options of the sessions from cfg, cache is kept across config reloads
+func newPlayerOptions(cfg *config.Config, cache *cam.CamCache) (cam.PlayerOptions, error) {
+	toLines := func(configLines []config.WelcomeLine) []welcome.Line {
+		lines := make([]welcome.Line, 0, len(configLines))
+		for _, line := range configLines {
+			lines = append(lines, welcome.Line{Text: line.Text, Type: line.Type})
+		}
+		return lines
+	}
 
-	packet.GetUint8()
-	request.AccountNumber = packet.GetUint32()
-	request.Character = packet.GetString()
-	request.Password = packet.GetString()
+	welcomeScreen, err := welcome.NewScreen(welcome.Options{
+		ServerName:           cfg.CamServer.ServerName,
+		Motd:                 cfg.Motd,
+		Lines:                toLines(cfg.Welcome.Lines),
+		Announcements:        toLines(cfg.Welcome.Announcements),
+		AnnouncementInterval: time.Duration(cfg.Welcome.AnnouncementInterval) * time.Second,
+	})
+	if err != nil {
+		return cam.PlayerOptions{}, err
+	}
 
-	request.OTCv8StringLength = packet.GetUint16()
-	if request.OTCv8StringLength == 5 {
-		request.OTCv8String = packet.GetStringSlice(int(request.OTCv8StringLength))
-		if request.OTCv8String == "OTCv8" {
-			request.OTCv8Version = packet.GetUint16()
+	// already validated when loading the config
+	statusFormat, err := status.ParseFormat(cfg.Status.Format)
+	if err != nil {
+		return cam.PlayerOptions{}, err
+	}
+
+	var statusType protocol.MessageType
+	if cfg.Status.MessageType != "" {
+		statusType, err = protocol.ParseMessageType(cfg.Status.MessageType)
+		if err != nil {
+			return cam.PlayerOptions{}, fmt.Errorf("status message type: %w", err)
 		}
 	}
 
-	// remoteIpAddress, err := utils.GetRemoteIpAddr(conn)
-	// if err != nil {
-	// 	fmt.Printf("[handleClient] - could not get remote IP address: %s\n", err)
-	// 	return
-	// }
+	onEnd, err := cam.ParseEndMode(cfg.CamServer.OnEnd)
+	if err != nil {
+		return cam.PlayerOptions{}, err
+	}
 
-	///TODO: add validations: empty account number, wrong client version, account locked, ip banned and wrong account id
+	speed, err := cam.NewSpeedControl(cfg.CamServer.MinSpeed, cfg.CamServer.MaxSpeed, cfg.CamServer.SpeedSteps)
+	if err != nil {
+		return cam.PlayerOptions{}, err
+	}
 
-	request.IsValid = true
-	return request, nil
+	return cam.PlayerOptions{
+		Welcome:           welcomeScreen,
+		StatusFormat:      statusFormat,
+		StatusInterval:    time.Duration(cfg.Status.RefreshInterval) * time.Millisecond,
+		StatusMessageType: statusType,
+		OnEnd:             onEnd,
+		LobbyMessage:      cfg.CamServer.LobbyMessage,
+		SkipIdle:          cfg.CamServer.SkipIdle,
+		IdleThreshold:     time.Duration(cfg.CamServer.SkipIdleThreshold * float64(time.Second)),
+		IdleDelay:         time.Duration(cfg.CamServer.SkipIdleDelay) * time.Millisecond,
+		Speed:             speed,
+		Cache:             cache,
+		Follow:            cfg.CamServer.Follow,
+		FollowDelay:       time.Duration(cfg.CamServer.FollowDelay) * time.Millisecond,
+		FollowTimeout:     time.Duration(cfg.CamServer.SettleDelay) * time.Second,
+	}, nil
 }
 
-func handleClientInputPackets(wg *sync.WaitGroup, c *client.Client) {
-	defer wg.Done()
+// watchLibrary keeps the library up to date with the cam directory, dropping the compiled copies of the cams
+// that change and compiling the recordings once they are finished
+func watchLibrary(ctx context.Context, camLibrary *library.Library, cache *cam.CamCache, settleDelay time.Duration) error {
+	return camLibrary.Watch(ctx, library.WatchOptions{
+		SettleDelay: settleDelay,
+		OnChange: func(change library.Change) {
+			fmt.Printf("[watchLibrary] - %s %s\n", change.Name, change.Type)
+			if cache == nil || !library.IsCamFile(change.Name) {
+				return
+			}
 
-	for {
-		select {
-		case <-c.CancelCh:
-			fmt.Println("Client disconnected or cancelled command")
-			return
+			switch change.Type {
+			case library.CAM_WRITTEN, library.CAM_REMOVED:
+				cache.Forget(change.Path)
+			case library.CAM_FINISHED:
+				go func() {
+					if err := cache.Warm(change.Path); err != nil {
+						fmt.Printf("[watchLibrary] - Error compiling %s: %v\n", change.Name, err)
+					}
+				}()
+			}
+		},
+	})
+}
 
-		default:
-			c.Conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+// startWatchingLibrary watches the cam directory when cfg asks for it, the returned function stops the watch and is
+// nil when the directory is not watched
+func startWatchingLibrary(ctx context.Context, cfg *config.Config, camLibrary *library.Library, cache *cam.CamCache) context.CancelFunc {
+	if !cfg.CamServer.WatchCamDirectory {
+		return nil
+	}
 
-			header := make([]byte, packet.HEADER_LENGTH)
-			if _, err := io.ReadFull(c.Conn, header); err != nil {
-				if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
-					continue
-				}
-				return
-			}
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	settleDelay := time.Duration(cfg.CamServer.SettleDelay) * time.Second
+	if err := watchLibrary(watchCtx, camLibrary, cache, settleDelay); err != nil {
+		fmt.Println("Error watching cam directory, reading it at every login:", err)
+		stopWatching()
+		return nil
+	}
+	return stopWatching
+}
 
-			// parse header: it has only the packet length
-			packetLength := int(binary.LittleEndian.Uint16(header))
+func main() {
 
-			packet := packet.NewIncoming(packetLength)
-			if _, err := io.ReadFull(c.Conn, packet.PeekBuffer()); err != nil {
-				continue
-			}
+	var wg sync.WaitGroup
+	ctx, stopAccepting := context.WithCancel(context.Background())
 
-			if err := packet.XteaDecrypt(c.XteaKey); err != nil {
-				fmt.Printf("Error during XteaDecrypt: %v\n", err)
-				continue
-			}
+	config, err := config.LoadConfig()
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		os.Exit(1)
+	}
 
-			protocol.ParsePacket(c, packet)
+	rsaDecrypter, err := crypt.NewRSADecrypter(config.RSAKeyFile)
+	if err != nil {
+		fmt.Println("Error loading private key:", err)
+		os.Exit(1)
+	}
+
+	accessController, err := newAccessController(&config)
+	if err != nil {
+		fmt.Println("Error loading ban list:", err)
+		os.Exit(1)
+	}
+
+	writerOptions, err := newWriterOptions(&config)
+	if err != nil {
+		fmt.Println("Error in cam server config:", err)
+		os.Exit(1)
+	}
+
+	var cache *cam.CamCache
+	if config.CamServer.CacheDirectory != "" {
+		cache, err = cam.NewCamCache(config.CamServer.CacheDirectory)
+		if err != nil {
+			fmt.Println("Error in cache config:", err)
+			os.Exit(1)
 		}
 	}
+
+	playerOptions, err := newPlayerOptions(&config, cache)
+	if err != nil {
+		fmt.Println("Error in player config:", err)
+		os.Exit(1)
+	}
+	sessions := session.NewManager(playerOptions)
+
+	camLibrary := library.New(config.CamServer.CamDirectory)
+	stopWatching := startWatchingLibrary(ctx, &config, camLibrary, cache)
+
+	server := &camServer{
+		decrypter:        rsaDecrypter,
+		accessController: accessController,
+		sessions:         sessions,
+		writerOptions:    writerOptions,
+	}
+	server.configure(&config, camLibrary)
+
+	startReloading(ctx, &reloader{
+		ctx:          ctx,
+		running:      config,
+		server:       server,
+		cache:        cache,
+		stopWatching: stopWatching,
+	})
+
+	fmt.Println("Starting Cam Server goroutine...")
+	wg.Add(1)
+	go startCamServer(ctx, &wg, server)
+
+	// Capture SIGINT and SIGTERM for graceful shutdown, a second signal skips the drain period
+	signalChan := make(chan os.Signal, 2)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	<-signalChan // Block until a shutdown signal is received
+	fmt.Println("Shutdown signal received")
+	stopAccepting() // Notify server to stop accepting new connections
+
+	gracePeriod := time.Duration(config.CamServer.ShutdownGracePeriod) * time.Second
+	go func() {
+		<-signalChan
+		fmt.Println("Second shutdown signal received, stopping sessions now")
+		sessions.Shutdown(0)
+	}()
+
+	sessions.Shutdown(gracePeriod)
+	wg.Wait()
+	fmt.Println("Server shutdown gracefully")
 }
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/access"
+	"go-opentibia-camplayerserver/cam"
+	"go-opentibia-camplayerserver/camtest"
+	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/clock"
+	"go-opentibia-camplayerserver/config"
+	"go-opentibia-camplayerserver/crypt"
+	"go-opentibia-camplayerserver/library"
+	"go-opentibia-camplayerserver/listener"
+	"go-opentibia-camplayerserver/loadtest"
+	"go-opentibia-camplayerserver/session"
+	"go-opentibia-camplayerserver/viewer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testXteaKey = [4]uint32{0x11, 0x22, 0x33, 0x44}
+
+const testCamName = "Knight_1_25-10-2024-18-36-45"
+
+// newTestServer serves the cams of a temporary directory, playing them with a fake clock
+func newTestServer(t *testing.T, cams map[string]string, options cam.PlayerOptions) (*camServer, *clock.Fake) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, lines := range cams {
+		if err := os.WriteFile(filepath.Join(dir, name+".cam"), []byte(lines), 0644); err != nil {
+			t.Fatalf("failed to write cam file: %v", err)
+		}
+	}
+
+	fakeClock := clock.NewFake(time.Date(2024, 10, 25, 18, 0, 0, 0, time.UTC))
+	options.Clock = fakeClock
+	if options.StatusInterval == 0 {
+		// out of the way of the packets, tests about the status line set their own
+		options.StatusInterval = 24 * time.Hour
+	}
+
+	server := &camServer{
+		decrypter:        camtest.PlainRSA{},
+		accessController: access.NewController(access.Limits{}, nil),
+		sessions:         session.NewManager(options),
+		writerOptions:    client.WriterOptions{},
+	}
+	server.configure(&config.Config{}, library.New(dir))
+	t.Cleanup(func() { server.sessions.Shutdown(0) })
+
+	return server, fakeClock
+}
+
+// connect logs a viewer in to the server and waits for the session to be running
+func connect(t *testing.T, server *camServer, fakeClock *clock.Fake, character string, protocolVersion uint16) *camtest.Viewer {
+	t.Helper()
+
+	serverConn, viewerConn := camtest.Pipe()
+	go server.handleConnection(context.Background(), serverConn)
+
+	testViewer, err := camtest.NewViewer(viewerConn, fakeClock, character, testXteaKey, protocolVersion)
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	t.Cleanup(func() { testViewer.Close() })
+
+	return testViewer
+}
+
+func syncViewer(t *testing.T, viewer *camtest.Viewer) {
+	t.Helper()
+	if err := viewer.Sync(); err != nil {
+		t.Fatalf("expected the session to go on, got %v", err)
+	}
+}
+
+func advance(t *testing.T, viewer *camtest.Viewer, d time.Duration) {
+	t.Helper()
+	if err := viewer.Advance(d); err != nil {
+		t.Fatalf("expected the session to go on, got %v", err)
+	}
+}
+
+// expectPackets checks the game packets received, by their first byte, and the time each arrived at
+func expectPackets(t *testing.T, viewer *camtest.Viewer, start time.Time, expected map[byte]time.Duration) {
+	t.Helper()
+
+	received := 0
+	for _, p := range viewer.Take() {
+		if _, _, ok := p.TextMessage(); ok {
+			continue
+		}
+		received++
+
+		at, ok := expected[p.Data[0]]
+		if !ok {
+			t.Errorf("unexpected packet %x at %s", p.Data, p.At.Sub(start))
+			continue
+		}
+		if p.At.Sub(start) != at {
+			t.Errorf("expected packet %x at %s, got it at %s", p.Data, at, p.At.Sub(start))
+		}
+	}
+
+	if received != len(expected) {
+		t.Errorf("expected %d packets, got %d", len(expected), received)
+	}
+}
+
+// expectMessage checks a text message with exactly text was received
+func expectMessage(t *testing.T, viewer *camtest.Viewer, text string) {
+	t.Helper()
+
+	var texts []string
+	for _, p := range viewer.Take() {
+		if _, message, ok := p.TextMessage(); ok {
+			if message == text {
+				return
+			}
+			texts = append(texts, message)
+		}
+	}
+	t.Errorf("expected the message %q, got %q", text, texts)
+}
+
+func TestSessionPlaysPacketsAtRecordedTimes(t *testing.T) {
+	server, fakeClock := newTestServer(t, map[string]string{
+		testCamName: "< 0 a1\n< 1000 a2\n< 2500 a3\n",
+	}, cam.PlayerOptions{})
+	start := fakeClock.Now()
+
+	viewer := connect(t, server, fakeClock, testCamName, 860)
+	syncViewer(t, viewer)
+	expectPackets(t, viewer, start, map[byte]time.Duration{0xa1: 0})
+
+	advance(t, viewer, 999*time.Millisecond)
+	expectPackets(t, viewer, start, map[byte]time.Duration{})
+
+	advance(t, viewer, 2*time.Second)
+	expectPackets(t, viewer, start, map[byte]time.Duration{0xa2: time.Second, 0xa3: 2500 * time.Millisecond})
+}
+
+func TestSessionSpeedChanges(t *testing.T) {
+	server, fakeClock := newTestServer(t, map[string]string{
+		testCamName: "< 0 a1\n< 4000 a2\n< 8000 a3\n< 9000 a4\n",
+	}, cam.PlayerOptions{})
+	start := fakeClock.Now()
+
+	viewer := connect(t, server, fakeClock, testCamName, 860)
+	advance(t, viewer, time.Second)
+	viewer.Take()
+
+	// the 3 seconds left until the second packet take 1.5 at double speed
+	viewer.Say("/speed 2")
+	syncViewer(t, viewer)
+	expectMessage(t, viewer, "Speed set to 2.00x.")
+
+	advance(t, viewer, 2*time.Second)
+	expectPackets(t, viewer, start, map[byte]time.Duration{0xa2: 2500 * time.Millisecond})
+
+	// paused for an hour at 6 seconds, the remaining 2 seconds to the third packet are played after resuming
+	advance(t, viewer, 500*time.Millisecond)
+	viewer.Say("/pause")
+	advance(t, viewer, time.Hour)
+	expectPackets(t, viewer, start, map[byte]time.Duration{})
+
+	viewer.Send(0x6F) // speed up resumes at normal speed
+	advance(t, viewer, 3*time.Second)
+	expectPackets(t, viewer, start, map[byte]time.Duration{0xa3: time.Hour + 5500*time.Millisecond, 0xa4: time.Hour + 6500*time.Millisecond})
+}
+
+func TestSessionSeeks(t *testing.T) {
+	server, fakeClock := newTestServer(t, map[string]string{
+		testCamName: "< 0 a1\n< 1000 a2\n< 2000 a3\n< 30000 a4\n",
+	}, cam.PlayerOptions{})
+	start := fakeClock.Now()
+
+	viewer := connect(t, server, fakeClock, testCamName, 860)
+	syncViewer(t, viewer)
+	viewer.Take()
+
+	// the packets skipped over are sent right away, the next one is due 10 seconds after the new position
+	viewer.Say("/seek +20")
+	syncViewer(t, viewer)
+	expectMessage(t, viewer, "Moved to 20.0.")
+
+	advance(t, viewer, 10*time.Second)
+	expectPackets(t, viewer, start, map[byte]time.Duration{0xa4: 10 * time.Second})
+
+	// going back replays the cam from its beginning
+	viewer.Send(0x72)
+	syncViewer(t, viewer)
+	packets := viewer.Take()
+	if len(packets) != 4 {
+		t.Fatalf("expected the 3 first packets to be replayed and a reply, got %d packets", len(packets))
+	}
+	if _, text, _ := packets[3].TextMessage(); text != "Moved to 20.0." {
+		t.Errorf("expected to be back at 20.0, got %q", text)
+	}
+}
+
+func TestSessionClosesAfterTheEnd(t *testing.T) {
+	server, fakeClock := newTestServer(t, map[string]string{
+		testCamName: "< 0 a1\n< 1000 a2\n",
+	}, cam.PlayerOptions{})
+	start := fakeClock.Now()
+
+	viewer := connect(t, server, fakeClock, testCamName, 860)
+	advance(t, viewer, time.Second+cam.END_OF_FILE_CLOSE_DELAY-time.Nanosecond)
+	expectPackets(t, viewer, start, map[byte]time.Duration{0xa1: 0, 0xa2: time.Second})
+
+	if err := viewer.Advance(time.Nanosecond); !errors.Is(err, camtest.ErrClosed) {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+
+	if closedAt, _ := viewer.Closed(); closedAt.Sub(start) != time.Second+cam.END_OF_FILE_CLOSE_DELAY {
+		t.Errorf("expected the connection to be closed %s after the end, got %s", cam.END_OF_FILE_CLOSE_DELAY, closedAt.Sub(start))
+	}
+}
+
+func TestSessionWithSequencedFraming(t *testing.T) {
+	// large enough to be compressed
+	data := "a1"
+	for len(data) < 2*1024 {
+		data += "00"
+	}
+
+	server, fakeClock := newTestServer(t, map[string]string{testCamName: "< 0 " + data + "\n"}, cam.PlayerOptions{})
+	server.current.Load().cfg.CamServer.Compression = true
+
+	viewer := connect(t, server, fakeClock, testCamName, 1100)
+	syncViewer(t, viewer)
+
+	packets := viewer.Take()
+	if len(packets) != 1 || len(packets[0].Data) != len(data)/2 || packets[0].Data[0] != 0xa1 {
+		t.Fatalf("expected the packet to be decompressed and decrypted, got %d packets", len(packets))
+	}
+}
+
+func TestSessionRejectsUnknownCam(t *testing.T) {
+	server, fakeClock := newTestServer(t, nil, cam.PlayerOptions{})
+
+	viewer := connect(t, server, fakeClock, "Nobody", 860)
+	if err := viewer.Sync(); !errors.Is(err, camtest.ErrClosed) {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+
+	packets := viewer.Take()
+	if len(packets) != 1 || packets[0].Data[0] != 0x0A || string(packets[0].Data[3:]) != library.ErrNotFound.Error() {
+		t.Errorf("expected a login error telling the cam was not found, got %v", packets)
+	}
+}
+
+func TestEndToEndOverTcp(t *testing.T) {
+	decrypter, err := crypt.NewRSADecrypter("key.pem")
+	if err != nil {
+		t.Fatalf("failed to load the server key: %v", err)
+	}
+	encrypter, err := crypt.NewRSAEncrypter("key.pem")
+	if err != nil {
+		t.Fatalf("failed to load the public key: %v", err)
+	}
+
+	// real time this time, the packets are a few milliseconds apart
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, testCamName+".cam"), []byte("< 0 a1\n< 10 a2\n< 20 a3\n"), 0644); err != nil {
+		t.Fatalf("failed to write cam file: %v", err)
+	}
+
+	server := &camServer{
+		decrypter:        decrypter,
+		accessController: access.NewController(access.Limits{}, nil),
+		sessions:         session.NewManager(cam.PlayerOptions{OnEnd: cam.END_LOBBY}),
+		writerOptions:    client.WriterOptions{},
+	}
+	server.configure(&config.Config{}, library.New(dir))
+	t.Cleanup(func() { server.sessions.Shutdown(0) })
+
+	camListener, err := listener.Listen("127.0.0.1:0", listener.Options{})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go camListener.Serve(ctx, server.handleConnection)
+
+	c, err := viewer.Dial(ctx, camListener.Addr().String(), testCamName, viewer.Options{Encrypter: encrypter})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+	c.Conn().SetDeadline(time.Now().Add(5 * time.Second))
+
+	var packets []byte
+	sentHudOff := false
+	for {
+		data, err := c.ReadPacket()
+		if err != nil {
+			t.Fatalf("expected the session to go on until the lobby, got %v after %x", err, packets)
+		}
+
+		if message, ok := viewer.ParseTextMessage(data); ok {
+			if message.Text == cam.DEFAULT_LOBBY_MESSAGE {
+				break
+			}
+			continue
+		}
+		packets = append(packets, data[0])
+
+		// the chat commands go all the way through as well
+		if !sentHudOff {
+			c.Say("/hud off")
+			sentHudOff = true
+		}
+	}
+
+	if string(packets) != "\xa1\xa2\xa3" {
+		t.Errorf("expected the 3 packets in order, got %x", packets)
+	}
+
+	c.Logout()
+	if _, err := c.ReadPacket(); err == nil {
+		t.Error("expected the server to close the connection after the logout")
+	}
+}
+
+func TestLoadTestOverTcp(t *testing.T) {
+	decrypter, err := crypt.NewRSADecrypter("key.pem")
+	if err != nil {
+		t.Fatalf("failed to load the server key: %v", err)
+	}
+	encrypter, err := crypt.NewRSAEncrypter("key.pem")
+	if err != nil {
+		t.Fatalf("failed to load the public key: %v", err)
+	}
+
+	var lines strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&lines, "< %d a%d %02x\n", i*20, i%10, i)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, testCamName+".cam"), []byte(lines.String()), 0644); err != nil {
+		t.Fatalf("failed to write cam file: %v", err)
+	}
+
+	server := &camServer{
+		decrypter:        decrypter,
+		accessController: access.NewController(access.Limits{}, nil),
+		sessions:         session.NewManager(cam.PlayerOptions{OnEnd: cam.END_LOOP}),
+		writerOptions:    client.WriterOptions{},
+	}
+	server.configure(&config.Config{}, library.New(dir))
+	t.Cleanup(func() { server.sessions.Shutdown(0) })
+
+	camListener, err := listener.Listen("127.0.0.1:0", listener.Options{})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go camListener.Serve(ctx, server.handleConnection)
+
+	cams, err := loadtest.LoadCams(dir, nil)
+	if err != nil || len(cams) != 1 || len(cams[0].Packets) != 20 {
+		t.Fatalf("expected the cam and its packets, got %v and %v", cams, err)
+	}
+
+	test := loadtest.New(loadtest.Options{
+		Address:         camListener.Addr().String(),
+		Viewer:          viewer.Options{Encrypter: encrypter},
+		Cams:            cams,
+		Viewers:         5,
+		RampUp:          100 * time.Millisecond,
+		Duration:        time.Second,
+		CommandInterval: 200 * time.Millisecond,
+		Seed:            1,
+	})
+	report, err := test.Run(ctx)
+	if err != nil {
+		t.Fatalf("expected the load test to run, got %v", err)
+	}
+
+	if report.Sessions != 5 || report.Failed != 0 || report.Connected != 0 {
+		t.Errorf("expected 5 sessions without failures, got %+v", report)
+	}
+	if report.Packets == 0 || report.Unmatched != 0 || report.Commands == 0 {
+		t.Errorf("expected matched packets and commands, got %+v", report)
+	}
+	if report.Latency.Samples == 0 || report.Latency.P50 > 100*time.Millisecond {
+		t.Errorf("expected packets close to their recorded times, got %+v", report.Latency)
+	}
+}
@@ -0,0 +1,100 @@
+package packet
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// FramingMode is how a packet is wrapped between the length header and the encrypted payload
+type FramingMode uint8
+
+const (
+	FRAMING_PLAIN    FramingMode = iota // length header followed by the encrypted payload
+	FRAMING_CHECKSUM                    // an adler32 checksum of the encrypted payload follows the length header
+	FRAMING_SEQUENCE                    // a sequence number with a compression flag follows the length header
+)
+
+const (
+	CHECKSUM_PROTOCOL_VERSION = 830
+	SEQUENCE_PROTOCOL_VERSION = 1100
+
+	// the highest bit of the sequence number tells the payload is deflate compressed
+	SEQUENCE_COMPRESSED_FLAG = 0x80000000
+	SEQUENCE_NUMBER_MASK     = 0x7FFFFFFF
+
+	// smaller payloads are not worth compressing
+	COMPRESSION_MIN_SIZE = 128
+
+	// a compressed payload does not inflate to more than an uncompressed packet can carry
+	MAX_DECOMPRESSED_SIZE = 0xFFFF
+)
+
+var ErrDecompressedTooLarge = errors.New("decompressed payload too large")
+
+func FramingForProtocolVersion(version uint16) FramingMode {
+	if version >= SEQUENCE_PROTOCOL_VERSION {
+		return FRAMING_SEQUENCE
+	}
+	if version >= CHECKSUM_PROTOCOL_VERSION {
+		return FRAMING_CHECKSUM
+	}
+	return FRAMING_PLAIN
+}
+
+func (f FramingMode) String() string {
+	switch f {
+	case FRAMING_PLAIN:
+		return "plain"
+	case FRAMING_CHECKSUM:
+		return "checksum"
+	case FRAMING_SEQUENCE:
+		return "sequence"
+	}
+	return "unknown"
+}
+
+// deflate writers allocate a lot of state, so they are reused across packets
+var deflaterPool = sync.Pool{
+	New: func() any {
+		writer, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return writer
+	},
+}
+
+// deflate compresses data as a raw deflate stream (no zlib header), which is what the clients inflate
+func deflate(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+
+	writer := deflaterPool.Get().(*flate.Writer)
+	defer deflaterPool.Put(writer)
+
+	writer.Reset(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return compressed.Bytes(), nil
+}
+
+// inflate decompresses a raw deflate stream, refusing to go past MAX_DECOMPRESSED_SIZE since the data comes from the
+// other side of the connection
+func inflate(data []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, MAX_DECOMPRESSED_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > MAX_DECOMPRESSED_SIZE {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrDecompressedTooLarge, MAX_DECOMPRESSED_SIZE)
+	}
+	return decompressed, nil
+}
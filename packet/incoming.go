@@ -2,17 +2,34 @@ package packet
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"go-opentibia-camplayerserver/crypt"
+	"hash/adler32"
 )
 
 const (
 	HEADER_LENGTH = 2
 )
 
+var (
+	ErrOutOfBounds      = errors.New("read out of packet bounds")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrSequenceMismatch = errors.New("sequence mismatch")
+)
+
+type Position struct {
+	X uint16
+	Y uint16
+	Z uint8
+}
+
+// Incoming never panics on malformed data: the first out of bounds access is kept as a sticky error,
+// every read after it returns the zero value, and callers check Err() once they are done parsing
 type Incoming struct {
 	buffer   []byte
 	position int
+	err      error
 }
 
 func NewIncoming(size int) *Incoming {
@@ -26,74 +43,232 @@ func (p *Incoming) size() int {
 	return len(p.buffer[p.position:])
 }
 
+func (p *Incoming) Err() error {
+	return p.err
+}
+
+func (p *Incoming) canRead(n int) bool {
+	if p.err != nil {
+		return false
+	}
+
+	if n < 0 || p.position+n > len(p.buffer) {
+		p.err = fmt.Errorf("%w: reading %d bytes at position %d of %d", ErrOutOfBounds, n, p.position, len(p.buffer))
+		return false
+	}
+
+	return true
+}
+
 func (p *Incoming) Resize(size int) {
+	if size < 0 || size > cap(p.buffer) {
+		if p.err == nil {
+			p.err = fmt.Errorf("%w: resizing to %d with capacity %d", ErrOutOfBounds, size, cap(p.buffer))
+		}
+		return
+	}
 	p.buffer = p.buffer[:size]
 }
 
-func (p *Incoming) skipBytes(n int) {
-	if p.position+n > p.size() {
-		panic("skipping more bytes than size")
+// SkipBytes moves past n bytes, or sets the sticky error when there are fewer
+func (p *Incoming) SkipBytes(n int) {
+	if !p.canRead(n) {
+		return
 	}
 	p.position += n
 }
 
 func (p *Incoming) GetUint8() uint8 {
+	if !p.canRead(1) {
+		return 0
+	}
 	result := p.buffer[p.position]
 	p.position += 1
 	return result
 }
 
 func (p *Incoming) peekUint8() uint8 {
+	if !p.canRead(1) {
+		return 0
+	}
 	return p.buffer[p.position]
 }
 
 func (p *Incoming) GetUint16() uint16 {
+	if !p.canRead(2) {
+		return 0
+	}
 	result := binary.LittleEndian.Uint16(p.buffer[p.position:])
 	p.position += 2
 	return result
 }
 
 func (p *Incoming) peekUint16() uint16 {
+	if !p.canRead(2) {
+		return 0
+	}
 	return binary.LittleEndian.Uint16(p.buffer[p.position:])
 }
 
 func (p *Incoming) GetUint32() uint32 {
+	if !p.canRead(4) {
+		return 0
+	}
 	result := binary.LittleEndian.Uint32(p.buffer[p.position:])
 	p.position += 4
 	return result
 }
 
 func (p *Incoming) peekUint32() uint32 {
+	if !p.canRead(4) {
+		return 0
+	}
 	return binary.LittleEndian.Uint32(p.buffer[p.position:])
 }
 
+func (p *Incoming) GetPosition() Position {
+	var position Position
+	position.X = p.GetUint16()
+	position.Y = p.GetUint16()
+	position.Z = p.GetUint8()
+	return position
+}
+
+func (p *Incoming) GetItemId() uint16 {
+	return p.GetUint16()
+}
+
+func (p *Incoming) GetCreatureId() uint32 {
+	return p.GetUint32()
+}
+
+// Remaining returns how many bytes are left to read
+func (p *Incoming) Remaining() int {
+	if p.position > len(p.buffer) {
+		return 0
+	}
+	return p.size()
+}
+
 func (p *Incoming) GetString() string {
 	stringLength := int(p.GetUint16())
-	result := string(p.buffer[p.position:(p.position + stringLength)])
-	p.position += stringLength
-	return result
+	return p.GetStringSlice(stringLength)
 }
 
 func (p *Incoming) GetStringSlice(length int) string {
+	if !p.canRead(length) {
+		return ""
+	}
 	result := string(p.buffer[p.position:(p.position + length)])
 	p.position += length
 	return result
 }
 
 func (p *Incoming) PeekBuffer() []byte {
+	if p.position > len(p.buffer) {
+		return nil
+	}
 	return p.buffer[p.position:]
 }
 
-func (p *Incoming) XteaDecrypt(xteaKey [4]uint32) error {
+// VerifyChecksum reads the adler32 checksum and validates it against the rest of the packet
+func (p *Incoming) VerifyChecksum() error {
+	checksum := p.GetUint32()
+	if p.err != nil {
+		return p.err
+	}
+
+	if calculated := adler32.Checksum(p.PeekBuffer()); calculated != checksum {
+		return fmt.Errorf("%w: received 0x%08x, calculated 0x%08x", ErrChecksumMismatch, checksum, calculated)
+	}
+
+	return nil
+}
+
+// SkipChecksum skips a leading adler32 checksum when it matches the rest of the packet,
+// used where the protocol version, and so the framing, is not known yet
+func (p *Incoming) SkipChecksum() bool {
+	if p.Remaining() <= 4 {
+		return false
+	}
+
+	if adler32.Checksum(p.buffer[p.position+4:]) != p.peekUint32() {
+		return false
+	}
+
+	p.SkipBytes(4)
+	return true
+}
+
+// GetSequence reads the sequence number of a sequenced packet and its compression flag
+func (p *Incoming) GetSequence() (uint32, bool) {
+	sequence := p.GetUint32()
+	return sequence & SEQUENCE_NUMBER_MASK, sequence&SEQUENCE_COMPRESSED_FLAG != 0
+}
+
+// XteaDecryptSequencedExpanded decrypts a sequenced packet, where a single byte ahead of the payload tells the
+// padding length, with a key expanded once for the whole connection
+func (p *Incoming) XteaDecryptSequencedExpanded(expandedXteaKey *[64]uint32) error {
 
-	if len(p.buffer)%8 != 0 {
+	if len(p.PeekBuffer())%8 != 0 {
 		return fmt.Errorf("error decrypting IncomingPacket: packet length is not multiple of eigth")
 	}
 
-	expandedXteaKey := crypt.ExpandXteaKey(xteaKey)
-	crypt.XteaDecrypt(p.PeekBuffer(), expandedXteaKey)
+	crypt.XteaDecrypt(p.PeekBuffer(), *expandedXteaKey)
+
+	paddingLength := int(p.GetUint8())
+	if p.err != nil {
+		return p.err
+	}
+
+	if paddingLength > p.Remaining() {
+		return fmt.Errorf("error decrypting IncomingPacket: padding length %d exceeds the %d decrypted bytes", paddingLength, p.Remaining())
+	}
+	p.Resize(len(p.buffer) - paddingLength)
+
+	return p.err
+}
 
-	p.GetUint16()
+// Decompress inflates the rest of the packet, for sequenced packets flagged as compressed
+func (p *Incoming) Decompress() error {
+	if p.err != nil {
+		return p.err
+	}
+
+	decompressed, err := inflate(p.PeekBuffer())
+	if err != nil {
+		return fmt.Errorf("error decompressing IncomingPacket: %w", err)
+	}
 
+	p.buffer = decompressed
+	p.position = 0
 	return nil
 }
+
+func (p *Incoming) XteaDecrypt(xteaKey [4]uint32) error {
+	expandedXteaKey := crypt.ExpandXteaKey(xteaKey)
+	return p.XteaDecryptExpanded(&expandedXteaKey)
+}
+
+// XteaDecryptExpanded is XteaDecrypt with a key expanded once for the whole connection
+func (p *Incoming) XteaDecryptExpanded(expandedXteaKey *[64]uint32) error {
+
+	if len(p.PeekBuffer())%8 != 0 {
+		return fmt.Errorf("error decrypting IncomingPacket: packet length is not multiple of eigth")
+	}
+
+	crypt.XteaDecrypt(p.PeekBuffer(), *expandedXteaKey)
+
+	// the decrypted payload starts with its own length, anything after it is padding
+	payloadLength := int(p.GetUint16())
+	if p.err != nil {
+		return p.err
+	}
+
+	if payloadLength > p.Remaining() {
+		return fmt.Errorf("error decrypting IncomingPacket: payload length %d exceeds the %d decrypted bytes", payloadLength, p.Remaining())
+	}
+	p.Resize(p.position + payloadLength)
+
+	return p.err
+}
@@ -1,6 +1,10 @@
 package packet
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"go-opentibia-camplayerserver/crypt"
 	"testing"
 	"unsafe"
 )
@@ -167,13 +171,13 @@ func TestIncomingBufferOverflow(t *testing.T) {
 	var packet Incoming
 	packet.buffer = []byte{0x01}
 
-	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("expected a panic due to buffer overflow, but got none")
-		}
-	}()
+	if got := packet.GetUint16(); got != 0 {
+		t.Errorf("expected zero value reading past the buffer, got %d", got)
+	}
 
-	packet.GetUint16() // Should panic because there's not enough data
+	if !errors.Is(packet.Err(), ErrOutOfBounds) {
+		t.Errorf("expected ErrOutOfBounds due to buffer overflow, got %v", packet.Err())
+	}
 }
 
 func TestIncomingSkipBytes(t *testing.T) {
@@ -181,7 +185,7 @@ func TestIncomingSkipBytes(t *testing.T) {
 	packet.buffer = []byte{0x01, 0x02, 0x03, 0x04}
 
 	sizeBefore := packet.size()
-	packet.skipBytes(2)
+	packet.SkipBytes(2)
 
 	uint8Data := packet.GetUint8()
 	if uint8Data != 0x03 {
@@ -200,26 +204,26 @@ func TestIncomingSkipTooManyBytesShouldFail(t *testing.T) {
 	var packet Incoming
 	packet.buffer = []byte{0x01, 0x02, 0x03}
 
-	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("expected a panic when skipping too many bytes, but got none")
-		}
-	}()
+	packet.SkipBytes(10)
 
-	packet.skipBytes(10) // Should panic
+	if !errors.Is(packet.Err(), ErrOutOfBounds) {
+		t.Errorf("expected ErrOutOfBounds when skipping too many bytes, got %v", packet.Err())
+	}
+
+	if packet.size() != 3 {
+		t.Errorf("expected position to be unchanged, but size is %d", packet.size())
+	}
 }
 
 func TestIncomingEmptyBufferShouldFail(t *testing.T) {
 	var packet Incoming
 	packet.buffer = []byte{}
 
-	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("expected a panic with an empty buffer, but got none")
-		}
-	}()
+	packet.GetUint32()
 
-	packet.GetUint32() // Should panic due to empty buffer
+	if !errors.Is(packet.Err(), ErrOutOfBounds) {
+		t.Errorf("expected ErrOutOfBounds with an empty buffer, got %v", packet.Err())
+	}
 }
 
 func TestIncomingResizeSmaller(t *testing.T) {
@@ -235,15 +239,15 @@ func TestIncomingResizeLargerShouldFail(t *testing.T) {
 	packet := NewIncoming(5)
 	packet.buffer = []byte{0x01, 0x02, 0x03, 0x04, 0x05}
 
-	// Expect a panic when resizing to a larger value
-	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("expected a panic when resizing to a larger value, but no panic occurred")
-		}
-	}()
-
-	// Attempt to resize to a larger size, which should cause a panic
 	packet.Resize(10)
+
+	if !errors.Is(packet.Err(), ErrOutOfBounds) {
+		t.Errorf("expected ErrOutOfBounds when resizing to a larger value, got %v", packet.Err())
+	}
+
+	if len(packet.buffer) != 5 {
+		t.Errorf("expected buffer size to remain 5, but got %d", len(packet.buffer))
+	}
 }
 
 func TestIncomingInit(t *testing.T) {
@@ -253,3 +257,283 @@ func TestIncomingInit(t *testing.T) {
 		t.Errorf("expected buffer size to be 10, but got %d", len(packet.buffer))
 	}
 }
+
+func TestIncomingErrorIsSticky(t *testing.T) {
+	var packet Incoming
+	packet.buffer = []byte{0x05, 0x00, 'a', 'b', 0x01}
+
+	if got := packet.GetString(); got != "" {
+		t.Errorf("expected empty string for truncated data, got %s", got)
+	}
+
+	firstErr := packet.Err()
+	if !errors.Is(firstErr, ErrOutOfBounds) {
+		t.Fatalf("expected ErrOutOfBounds for truncated string, got %v", firstErr)
+	}
+
+	// later reads that would fit must still fail, the packet is already corrupted
+	if got := packet.GetUint8(); got != 0 {
+		t.Errorf("expected zero value after an error, got %d", got)
+	}
+
+	if packet.Err() != firstErr {
+		t.Errorf("expected first error to be kept, got %v", packet.Err())
+	}
+}
+
+func TestIncomingGetStringSliceNegativeLength(t *testing.T) {
+	var packet Incoming
+	packet.buffer = []byte{0x01, 0x02}
+
+	packet.GetStringSlice(-1)
+
+	if !errors.Is(packet.Err(), ErrOutOfBounds) {
+		t.Errorf("expected ErrOutOfBounds for negative length, got %v", packet.Err())
+	}
+}
+
+func TestIncomingGetPosition(t *testing.T) {
+	var packet Incoming
+	packet.buffer = []byte{0xE8, 0x03, 0xD0, 0x07, 0x07}
+
+	want := Position{X: 1000, Y: 2000, Z: 7}
+	if got := packet.GetPosition(); got != want {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+
+	if packet.Err() != nil {
+		t.Errorf("expected no error, got %v", packet.Err())
+	}
+}
+
+func TestIncomingGetPositionTruncated(t *testing.T) {
+	var packet Incoming
+	packet.buffer = []byte{0xE8, 0x03, 0xD0}
+
+	if got := packet.GetPosition(); got != (Position{X: 1000}) {
+		t.Errorf("expected only the complete coordinate to be read, got %+v", got)
+	}
+
+	if !errors.Is(packet.Err(), ErrOutOfBounds) {
+		t.Errorf("expected ErrOutOfBounds for truncated position, got %v", packet.Err())
+	}
+}
+
+func TestIncomingGetItemAndCreatureId(t *testing.T) {
+	var packet Incoming
+	packet.buffer = []byte{0x0C, 0x0C, 0x01, 0x00, 0x00, 0x10}
+
+	if got := packet.GetItemId(); got != 0x0C0C {
+		t.Errorf("expected item id 0x0C0C, got 0x%x", got)
+	}
+
+	if got := packet.GetCreatureId(); got != 0x10000001 {
+		t.Errorf("expected creature id 0x10000001, got 0x%x", got)
+	}
+
+	if packet.Remaining() != 0 {
+		t.Errorf("expected no remaining bytes, got %d", packet.Remaining())
+	}
+}
+
+func TestIncomingXteaDecrypt(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+
+	outgoing := NewOutgoing(16)
+	outgoing.AddUint8(0x96)
+	outgoing.AddString("hi")
+	outgoing.XteaEncrypt(xteaKey)
+
+	encrypted := outgoing.Get()
+	packet := NewIncoming(len(encrypted))
+	copy(packet.PeekBuffer(), encrypted)
+
+	if err := packet.XteaDecrypt(xteaKey); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if packet.Remaining() != 5 {
+		t.Errorf("expected padding to be dropped leaving 5 bytes, got %d", packet.Remaining())
+	}
+
+	if opCode := packet.GetUint8(); opCode != 0x96 {
+		t.Errorf("expected opcode 0x96, got 0x%x", opCode)
+	}
+
+	if text := packet.GetString(); text != "hi" {
+		t.Errorf("expected text hi, got %s", text)
+	}
+}
+
+func TestIncomingXteaDecryptInvalidPayloadLength(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint16(data, 100) // payload length larger than the packet
+	crypt.XteaEncrypt(data, crypt.ExpandXteaKey(xteaKey))
+
+	packet := NewIncoming(len(data))
+	copy(packet.PeekBuffer(), data)
+
+	if err := packet.XteaDecrypt(xteaKey); err == nil {
+		t.Error("expected an error for a payload length past the packet, got none")
+	}
+}
+
+func TestIncomingVerifyChecksum(t *testing.T) {
+	var packet Incoming
+	packet.buffer = append([]byte{0x98, 0x03, 0xE6, 0x11}, []byte("Wikipedia")...)
+
+	if err := packet.VerifyChecksum(); err != nil {
+		t.Fatalf("expected checksum to match, got %v", err)
+	}
+
+	if got := packet.GetStringSlice(9); got != "Wikipedia" {
+		t.Errorf("expected payload after the checksum, got %s", got)
+	}
+}
+
+func TestIncomingVerifyChecksumMismatch(t *testing.T) {
+	var packet Incoming
+	packet.buffer = append([]byte{0x98, 0x03, 0xE6, 0x11}, []byte("wikipedia")...)
+
+	if err := packet.VerifyChecksum(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestIncomingSkipChecksum(t *testing.T) {
+	var withChecksum Incoming
+	withChecksum.buffer = append([]byte{0x98, 0x03, 0xE6, 0x11}, []byte("Wikipedia")...)
+
+	if !withChecksum.SkipChecksum() || withChecksum.Remaining() != 9 {
+		t.Errorf("expected checksum to be skipped, remaining %d", withChecksum.Remaining())
+	}
+
+	var withoutChecksum Incoming
+	withoutChecksum.buffer = []byte("Wikipedia")
+
+	if withoutChecksum.SkipChecksum() || withoutChecksum.Remaining() != 9 {
+		t.Errorf("expected nothing to be skipped, remaining %d", withoutChecksum.Remaining())
+	}
+
+	if withoutChecksum.Err() != nil {
+		t.Errorf("expected no error, got %v", withoutChecksum.Err())
+	}
+}
+
+func TestFramingForProtocolVersion(t *testing.T) {
+	tests := []struct {
+		version uint16
+		framing FramingMode
+	}{
+		{760, FRAMING_PLAIN},
+		{810, FRAMING_PLAIN},
+		{830, FRAMING_CHECKSUM},
+		{860, FRAMING_CHECKSUM},
+		{1098, FRAMING_CHECKSUM},
+		{1100, FRAMING_SEQUENCE},
+		{1281, FRAMING_SEQUENCE},
+	}
+
+	for _, tt := range tests {
+		if got := FramingForProtocolVersion(tt.version); got != tt.framing {
+			t.Errorf("expected framing %s for version %d, got %s", tt.framing, tt.version, got)
+		}
+	}
+}
+
+func encodeSequenced(t *testing.T, xteaKey [4]uint32, payload []byte, sequence uint32, compress bool) []byte {
+	t.Helper()
+
+	outgoing := NewOutgoing(len(payload))
+	outgoing.AddBytes(payload)
+	compressed := compress && outgoing.Compress()
+	expandedXteaKey := crypt.ExpandXteaKey(xteaKey)
+	outgoing.XteaEncryptSequencedExpanded(&expandedXteaKey)
+	outgoing.AddSequence(sequence, compressed)
+	outgoing.HeaderAddBlockCount()
+
+	// skip the length header, as a reader would after reading it
+	return outgoing.Get()[HEADER_LENGTH:]
+}
+
+func TestIncomingSequenced(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+	payload := []byte{0x96, 0x01, 0x02, 0x00, 'h', 'i'}
+
+	data := encodeSequenced(t, xteaKey, payload, 3, false)
+	packet := NewIncoming(len(data))
+	copy(packet.PeekBuffer(), data)
+
+	sequence, compressed := packet.GetSequence()
+	if sequence != 3 || compressed {
+		t.Errorf("expected uncompressed sequence 3, got %d %v", sequence, compressed)
+	}
+
+	expandedXteaKey := crypt.ExpandXteaKey(xteaKey)
+	if err := packet.XteaDecryptSequencedExpanded(&expandedXteaKey); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !bytes.Equal(packet.PeekBuffer(), payload) {
+		t.Errorf("expected payload %x, got %x", payload, packet.PeekBuffer())
+	}
+}
+
+func TestIncomingSequencedCompressed(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+	payload := bytes.Repeat([]byte("Welcome to the cam server! "), 10)
+
+	data := encodeSequenced(t, xteaKey, payload, SEQUENCE_NUMBER_MASK, true)
+	packet := NewIncoming(len(data))
+	copy(packet.PeekBuffer(), data)
+
+	sequence, compressed := packet.GetSequence()
+	if sequence != SEQUENCE_NUMBER_MASK || !compressed {
+		t.Fatalf("expected compressed sequence 0x%x, got 0x%x %v", SEQUENCE_NUMBER_MASK, sequence, compressed)
+	}
+
+	expandedXteaKey := crypt.ExpandXteaKey(xteaKey)
+	if err := packet.XteaDecryptSequencedExpanded(&expandedXteaKey); err != nil {
+		t.Fatalf("expected no error decrypting, got %v", err)
+	}
+
+	if err := packet.Decompress(); err != nil {
+		t.Fatalf("expected no error decompressing, got %v", err)
+	}
+
+	if !bytes.Equal(packet.PeekBuffer(), payload) {
+		t.Errorf("expected payload to survive compression")
+	}
+}
+
+func TestIncomingDecompressRefusesBombs(t *testing.T) {
+	bomb, err := deflate(make([]byte, 16*MAX_DECOMPRESSED_SIZE))
+	if err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+
+	packet := NewIncoming(len(bomb))
+	copy(packet.PeekBuffer(), bomb)
+
+	if err := packet.Decompress(); !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Errorf("expected ErrDecompressedTooLarge for %d compressed bytes, got %v", len(bomb), err)
+	}
+}
+
+func TestIncomingXteaDecryptSequencedInvalidPadding(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+
+	data := make([]byte, 8)
+	data[0] = 200 // padding length larger than the packet
+	crypt.XteaEncrypt(data, crypt.ExpandXteaKey(xteaKey))
+
+	packet := NewIncoming(len(data))
+	copy(packet.PeekBuffer(), data)
+
+	expandedXteaKey := crypt.ExpandXteaKey(xteaKey)
+	if err := packet.XteaDecryptSequencedExpanded(&expandedXteaKey); err == nil {
+		t.Error("expected an error for a padding length past the packet, got none")
+	}
+}
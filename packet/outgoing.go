@@ -4,17 +4,27 @@ import (
 	"encoding/binary"
 	"fmt"
 	"go-opentibia-camplayerserver/crypt"
+	"hash/adler32"
+	"sync"
 )
 
 const (
 	HEADER_OFFSET     = 10
 	MULTIPLE_OF_EIGHT = 8
+
+	// larger buffers are left to the garbage collector instead of being kept in the pool
+	MAX_POOLED_BUFFER_SIZE = 32 * 1024
 )
 
 type Outgoing struct {
 	buffer   []byte
 	position int
 	header   int
+	pooled   bool
+}
+
+var outgoingPool = sync.Pool{
+	New: func() any { return &Outgoing{pooled: true} },
 }
 
 func NewOutgoing(size int) *Outgoing {
@@ -26,6 +36,31 @@ func NewOutgoing(size int) *Outgoing {
 	}
 }
 
+// AcquireOutgoing is NewOutgoing reusing the buffer of a released packet, the packet goes back with Release
+// once its data was written
+func AcquireOutgoing(size int) *Outgoing {
+	p := outgoingPool.Get().(*Outgoing)
+
+	needed := size + (HEADER_OFFSET + MULTIPLE_OF_EIGHT)
+	if cap(p.buffer) < needed {
+		p.buffer = make([]byte, needed)
+	}
+	p.buffer = p.buffer[:needed]
+	p.position = 0
+	p.header = HEADER_OFFSET
+
+	return p
+}
+
+// Release hands a packet from AcquireOutgoing back to the pool, neither it nor what Get returned may be
+// used afterwards. Packets from NewOutgoing are left alone.
+func (p *Outgoing) Release() {
+	if !p.pooled || cap(p.buffer) > MAX_POOLED_BUFFER_SIZE {
+		return
+	}
+	outgoingPool.Put(p)
+}
+
 func (p *Outgoing) GetHeaderSize() int {
 	return (HEADER_OFFSET + MULTIPLE_OF_EIGHT)
 }
@@ -102,6 +137,54 @@ func (p *Outgoing) HeaderAddSize() {
 	p.header -= 2
 }
 
+// AddChecksum prepends the adler32 checksum of everything already in the packet, it goes after encryption and before the length header
+func (p *Outgoing) AddChecksum() {
+	checksum := adler32.Checksum(p.Get())
+	binary.LittleEndian.PutUint32(p.buffer[p.header-4:], checksum)
+	p.header -= 4
+}
+
+// Compress deflates the payload in place when it is large enough and actually gets smaller, it must run before encryption
+func (p *Outgoing) Compress() bool {
+	payload := p.buffer[HEADER_OFFSET : HEADER_OFFSET+p.position]
+	if len(payload) < COMPRESSION_MIN_SIZE {
+		return false
+	}
+
+	compressed, err := deflate(payload)
+	if err != nil {
+		fmt.Println("Error: compressing packet:", err)
+		return false
+	}
+
+	if len(compressed) >= len(payload) {
+		return false
+	}
+
+	copy(payload, compressed)
+	p.position = len(compressed)
+	return true
+}
+
+// AddSequence prepends the sequence number used instead of the checksum by sequenced clients, it goes after encryption
+func (p *Outgoing) AddSequence(sequence uint32, compressed bool) {
+	sequence &= SEQUENCE_NUMBER_MASK
+	if compressed {
+		sequence |= SEQUENCE_COMPRESSED_FLAG
+	}
+
+	binary.LittleEndian.PutUint32(p.buffer[p.header-4:], sequence)
+	p.header -= 4
+}
+
+// HeaderAddBlockCount is the sequenced counterpart of HeaderAddSize: the header holds the number of
+// 8 bytes encrypted blocks, not counting the sequence number
+func (p *Outgoing) HeaderAddBlockCount() {
+	blocks := uint16((p.Size() - 4) / MULTIPLE_OF_EIGHT)
+	binary.LittleEndian.PutUint16(p.buffer[p.header-2:], blocks)
+	p.header -= 2
+}
+
 func (p *Outgoing) addPadding() {
 	size := p.Size()
 	if size%8 != 0 {
@@ -113,11 +196,32 @@ func (p *Outgoing) addPadding() {
 }
 
 func (p *Outgoing) XteaEncrypt(xteaKey [4]uint32) error {
+	expandedXteaKey := crypt.ExpandXteaKey(xteaKey)
+	return p.XteaEncryptExpanded(&expandedXteaKey)
+}
+
+// XteaEncryptExpanded is XteaEncrypt with a key expanded once for the whole connection
+func (p *Outgoing) XteaEncryptExpanded(expandedXteaKey *[64]uint32) error {
 
 	p.HeaderAddSize()
 	p.addPadding()
 
-	expandedXteaKey := crypt.ExpandXteaKey(xteaKey)
-	crypt.XteaEncrypt(p.Get(), expandedXteaKey)
+	crypt.XteaEncrypt(p.Get(), *expandedXteaKey)
+	return nil
+}
+
+// XteaEncryptSequencedExpanded encrypts for sequenced clients, which expect the padding length in a single
+// byte ahead of the payload instead of the payload length, with a key expanded once for the whole connection
+func (p *Outgoing) XteaEncryptSequencedExpanded(expandedXteaKey *[64]uint32) error {
+
+	paddingLength := (MULTIPLE_OF_EIGHT - (p.Size()+1)%MULTIPLE_OF_EIGHT) % MULTIPLE_OF_EIGHT
+	for i := 0; i < paddingLength; i++ {
+		p.AddUint8(0x33)
+	}
+
+	p.buffer[p.header-1] = uint8(paddingLength)
+	p.header -= 1
+
+	crypt.XteaEncrypt(p.Get(), *expandedXteaKey)
 	return nil
 }
@@ -1,7 +1,9 @@
 package packet
 
 import (
+	"bytes"
 	"encoding/binary"
+	"go-opentibia-camplayerserver/crypt"
 	"testing"
 )
 
@@ -145,3 +147,91 @@ func TestOutgoingXteaEncrypt(t *testing.T) {
 		t.Errorf("Expected size %d after encryption, got %d", expectedSize, packet.Size())
 	}
 }
+
+func TestAddChecksum(t *testing.T) {
+	packet := NewOutgoing(64)
+	packet.AddBytes([]byte("Wikipedia"))
+	packet.AddChecksum()
+	packet.HeaderAddSize()
+
+	// adler32("Wikipedia") = 0x11E60398
+	expected := append([]byte{0x0D, 0x00, 0x98, 0x03, 0xE6, 0x11}, []byte("Wikipedia")...)
+	if !bytes.Equal(packet.Get(), expected) {
+		t.Errorf("Expected %x, got %x", expected, packet.Get())
+	}
+}
+
+func TestOutgoingSequencedFraming(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+	payload := []byte{0xB4, 0x15, 0x02, 0x00, 'h', 'i'}
+
+	packet := NewOutgoing(len(payload))
+	packet.AddBytes(payload)
+	expandedXteaKey := crypt.ExpandXteaKey(xteaKey)
+	packet.XteaEncryptSequencedExpanded(&expandedXteaKey)
+	packet.AddSequence(7, false)
+	packet.HeaderAddBlockCount()
+
+	data := packet.Get()
+
+	// 1 padding length byte + 6 bytes of payload + 1 byte of padding make a single block
+	if blocks := binary.LittleEndian.Uint16(data); blocks != 1 {
+		t.Fatalf("Expected 1 block in header, got %d", blocks)
+	}
+
+	if sequence := binary.LittleEndian.Uint32(data[2:]); sequence != 7 {
+		t.Errorf("Expected sequence 7 without compression flag, got 0x%x", sequence)
+	}
+
+	encrypted := append([]byte(nil), data[6:]...)
+	if len(encrypted) != 8 {
+		t.Fatalf("Expected 8 encrypted bytes, got %d", len(encrypted))
+	}
+
+	crypt.XteaDecrypt(encrypted, crypt.ExpandXteaKey(xteaKey))
+
+	if encrypted[0] != 1 {
+		t.Errorf("Expected padding length 1, got %d", encrypted[0])
+	}
+
+	if !bytes.Equal(encrypted[1:7], payload) {
+		t.Errorf("Expected payload %x, got %x", payload, encrypted[1:7])
+	}
+}
+
+func TestOutgoingCompress(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x6A, 0x01, 0x02}, 100)
+
+	packet := NewOutgoing(len(payload))
+	packet.AddBytes(payload)
+
+	if !packet.Compress() {
+		t.Fatal("Expected repetitive payload to be compressed")
+	}
+
+	if packet.Size() >= len(payload) {
+		t.Errorf("Expected compressed size below %d, got %d", len(payload), packet.Size())
+	}
+
+	decompressed, err := inflate(packet.Get())
+	if err != nil {
+		t.Fatalf("Expected no error inflating, got %v", err)
+	}
+
+	if !bytes.Equal(decompressed, payload) {
+		t.Errorf("Expected inflated data to match the payload")
+	}
+}
+
+func TestOutgoingCompressSkipsSmallPayload(t *testing.T) {
+	packet := NewOutgoing(16)
+	packet.AddBytes(bytes.Repeat([]byte{0x00}, 16))
+
+	if packet.Compress() {
+		t.Error("Expected payload below COMPRESSION_MIN_SIZE not to be compressed")
+	}
+
+	if packet.Size() != 16 {
+		t.Errorf("Expected size to stay 16, got %d", packet.Size())
+	}
+}
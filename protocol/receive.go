@@ -1,9 +1,16 @@
 package protocol
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/command"
 	"go-opentibia-camplayerserver/packet"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type SpeakClass = uint8
@@ -26,49 +33,106 @@ const (
 	TALKTYPE_MONSTER_SAY  SpeakClass = 17
 )
 
-func ParsePacket(c *client.Client, packet *packet.Incoming) {
+// seek step of the arrow key shortcuts
+const ARROW_SEEK_STEP = 10 * time.Second
+
+// ParsePacket turns a client packet into a player command, giving up on delivering it once ctx is done
+func ParsePacket(ctx context.Context, c *client.Client, packet *packet.Incoming) error {
 
 	opCode := packet.GetUint8()
+	if err := packet.Err(); err != nil {
+		return fmt.Errorf("[ParsePacket] - empty packet: %w", err)
+	}
 
 	switch opCode {
 	case 0x14:
-		c.CommandCh <- "logout"
-		return
+		return pushCommand(ctx, c, command.Logout{})
 	case 0x6F:
-		c.CommandCh <- "speedUp"
-		return
+		return pushCommand(ctx, c, command.SpeedUp{})
 	case 0x70:
-		c.CommandCh <- "moveFoward"
-		return
+		return pushCommand(ctx, c, command.Seek{Replies: command.NewReplies(), Delta: ARROW_SEEK_STEP})
 	case 0x71:
-		c.CommandCh <- "speedDown"
-		return
+		return pushCommand(ctx, c, command.SpeedDown{})
 	case 0x72:
-		c.CommandCh <- "moveBackward"
-		return
+		return pushCommand(ctx, c, command.Seek{Replies: command.NewReplies(), Delta: -ARROW_SEEK_STEP})
 
 	case 0x96:
-		message := strings.ToLower(ParseSay(packet))
+		message, err := ParseSay(packet)
+		if err != nil {
+			return fmt.Errorf("[ParsePacket] - malformed say packet: %w", err)
+		}
+
+		cmd, err := ParseChatCommand(message)
+		if err != nil {
+			SendTextMessage(c, err.Error(), MESSAGE_STATUS_SMALL)
+			return nil
+		}
+
+		return pushCommand(ctx, c, cmd)
+	}
+
+	return nil
+}
+
+// ChatCommands lists the commands understood by ParseChatCommand, as shown to the viewers
+var ChatCommands = []string{"/pause", "/stop", "/speed <multiplier>", "/seek <+seconds|-seconds>", "/hud <on|off>", "/skipidle <on|off>"}
+
+// ParseChatCommand turns what the viewer typed into a command, text that is not a command becomes a Say
+func ParseChatCommand(message string) (command.Command, error) {
+	if len(message) == 0 || message[0] != '/' {
+		return command.Say{Text: message}, nil
+	}
+
+	fields := strings.Fields(strings.ToLower(message))
+	name, args := fields[0], fields[1:]
+
+	switch name {
+
+	case "/pause":
+		return command.Pause{}, nil
+
+	case "/stop":
+		return command.Stop{}, nil
+
+	case "/speed":
+		if len(args) != 1 {
+			return nil, errors.New("Usage: /speed <multiplier>")
+		}
 
-		if len(message) > 0 && message[0] == '/' {
-			switch message {
+		speed, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "x"), 64)
+		if err != nil || math.IsNaN(speed) || math.IsInf(speed, 0) {
+			return nil, fmt.Errorf("Invalid speed %q.", args[0])
+		}
+		return command.SetSpeed{Replies: command.NewReplies(), Speed: speed}, nil
 
-			case "/pause":
-				c.CommandCh <- "pause"
-				return
+	case "/seek":
+		if len(args) != 1 {
+			return nil, errors.New("Usage: /seek <+seconds|-seconds>")
+		}
+
+		seconds, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || math.IsNaN(seconds) || math.Abs(seconds) > math.MaxInt64/float64(time.Second) {
+			return nil, fmt.Errorf("Invalid seek offset %q.", args[0])
+		}
+		return command.Seek{Replies: command.NewReplies(), Delta: time.Duration(seconds * float64(time.Second))}, nil
 
-			case "/stop":
-				c.CommandCh <- "stop"
-				return
-			}
+	case "/hud":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return nil, errors.New("Usage: /hud <on|off>")
 		}
+		return command.SetHud{Replies: command.NewReplies(), Visible: args[0] == "on"}, nil
 
-		c.CommandCh <- "talk"
-		return
+	case "/skipidle":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return nil, errors.New("Usage: /skipidle <on|off>")
+		}
+		return command.SetSkipIdle{Replies: command.NewReplies(), Enabled: args[0] == "on"}, nil
 	}
+
+	return command.Say{Text: message}, nil
 }
 
-func ParseSay(packet *packet.Incoming) string {
+func ParseSay(packet *packet.Incoming) (string, error) {
 
 	speakClass := packet.GetUint8()
 
@@ -82,5 +146,36 @@ func ParseSay(packet *packet.Incoming) string {
 	default:
 	}
 
-	return packet.GetString()
+	text := packet.GetString()
+	if err := packet.Err(); err != nil {
+		return "", err
+	}
+
+	return text, nil
+}
+
+// pushCommand hands the command to the player, and waits for the outcome of those that report one
+func pushCommand(ctx context.Context, c *client.Client, cmd command.Command) error {
+	select {
+	case c.CommandCh <- cmd:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	replyCh := command.ReplyChannel(cmd)
+	if replyCh == nil {
+		return nil
+	}
+
+	select {
+	case reply := <-replyCh:
+		if reply.Err != nil {
+			SendTextMessage(c, reply.Err.Error(), MESSAGE_STATUS_SMALL)
+		} else if reply.Message != "" {
+			SendTextMessage(c, reply.Message, MESSAGE_STATUS_CONSOLE_BLUE)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
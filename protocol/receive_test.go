@@ -1,9 +1,13 @@
 package protocol
 
 import (
+	"context"
+	"errors"
 	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/command"
 	"go-opentibia-camplayerserver/packet"
 	"testing"
+	"time"
 )
 
 // Helper function to create a mock Incoming packet with predefined bytes
@@ -16,7 +20,7 @@ func newMockIncoming(data []byte) *packet.Incoming {
 // Helper function to create a mock Client
 func newMockClient() *client.Client {
 	return &client.Client{
-		CommandCh: make(chan string, 1), // Buffer to avoid blocking
+		CommandCh: make(chan command.Command, 1), // Buffer to avoid blocking
 	}
 }
 
@@ -28,10 +32,9 @@ func TestParsePacket(t *testing.T) {
 	}{
 		{"Logout Packet", []byte{0x14}, "logout"},
 		{"Speed Up Packet", []byte{0x6F}, "speedUp"},
-		{"Move Forward Packet", []byte{0x70}, "moveFoward"},
 		{"Speed Down Packet", []byte{0x71}, "speedDown"},
-		{"Move Backward Packet", []byte{0x72}, "moveBackward"},
-		{"Say Packet", []byte{0x96, TALKTYPE_SAY, 0x02, 0x00, 'H', 'i'}, "talk"},
+		{"Say Packet", []byte{0x96, TALKTYPE_SAY, 0x02, 0x00, 'H', 'i'}, `say "Hi"`},
+		{"Pause Command", []byte{0x96, TALKTYPE_SAY, 0x06, 0x00, '/', 'P', 'a', 'u', 's', 'e'}, "pause"},
 	}
 
 	for _, tt := range tests {
@@ -40,12 +43,14 @@ func TestParsePacket(t *testing.T) {
 			mockPacket := newMockIncoming(tt.inputData)
 
 			// Run the ParsePacket function
-			ParsePacket(mockClient, mockPacket)
+			if err := ParsePacket(context.Background(), mockClient, mockPacket); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
 
 			// Check if the expected command was sent to CommandCh
 			select {
 			case cmd := <-mockClient.CommandCh:
-				if cmd != tt.expected {
+				if cmd.String() != tt.expected {
 					t.Errorf("expected command %s, got %s", tt.expected, cmd)
 				}
 			default:
@@ -56,6 +61,103 @@ func TestParsePacket(t *testing.T) {
 	}
 }
 
+func TestParsePacketSeekWaitsForReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		opCode   byte
+		expected time.Duration
+	}{
+		{"Move Forward Packet", 0x70, ARROW_SEEK_STEP},
+		{"Move Backward Packet", 0x72, -ARROW_SEEK_STEP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &countingConn{}
+			c := &client.Client{Conn: conn, CommandCh: make(chan command.Command)}
+
+			// stands in for the player
+			go func() {
+				cmd := (<-c.CommandCh).(command.Seek)
+				if cmd.Delta != tt.expected {
+					t.Errorf("expected seek by %s, got %s", tt.expected, cmd.Delta)
+				}
+				cmd.Reply("Moved to 10.0.", nil)
+			}()
+
+			if err := ParsePacket(context.Background(), c, newMockIncoming([]byte{tt.opCode})); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if conn.writes != 1 {
+				t.Errorf("expected the reply to be sent to the viewer, got %d writes", conn.writes)
+			}
+		})
+	}
+}
+
+func TestParsePacketReplyGivesUpWhenCancelled(t *testing.T) {
+	c := &client.Client{CommandCh: make(chan command.Command, 1)}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// nobody replies to the queued seek
+	err := ParsePacket(ctx, c, newMockIncoming([]byte{0x70}))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+}
+
+func TestParseChatCommand(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected command.Command
+		valid    bool
+	}{
+		{"hello", command.Say{Text: "hello"}, true},
+		{"/Stop", command.Stop{}, true},
+		{"/pause", command.Pause{}, true},
+		{"/unknown 1", command.Say{Text: "/unknown 1"}, true},
+		{"/speed 1.5", command.SetSpeed{Speed: 1.5}, true},
+		{"/speed 2x", command.SetSpeed{Speed: 2}, true},
+		{"/seek +30", command.Seek{Delta: 30 * time.Second}, true},
+		{"/seek -2.5", command.Seek{Delta: -2500 * time.Millisecond}, true},
+		{"/hud OFF", command.SetHud{Visible: false}, true},
+		{"/hud on", command.SetHud{Visible: true}, true},
+		{"/skipidle on", command.SetSkipIdle{Enabled: true}, true},
+		{"/skipidle", nil, false},
+		{"/hud", nil, false},
+		{"/hud maybe", nil, false},
+		{"/speed", nil, false},
+		{"/speed fast", nil, false},
+		{"/speed nan", nil, false},
+		{"/seek 1e300", nil, false},
+		{"/seek 1 2", nil, false},
+	}
+
+	for _, tt := range tests {
+		cmd, err := ParseChatCommand(tt.input)
+		if (err == nil) != tt.valid {
+			t.Errorf("unexpected error for %q: %v", tt.input, err)
+			continue
+		}
+		if !tt.valid {
+			continue
+		}
+
+		if cmd.String() != tt.expected.String() {
+			t.Errorf("expected %s for %q, got %s", tt.expected, tt.input, cmd)
+		}
+
+		switch cmd.(type) {
+		case command.Seek, command.SetSpeed, command.SetHud, command.SetSkipIdle:
+			if command.ReplyChannel(cmd) == nil {
+				t.Errorf("expected %q to carry a reply channel", tt.input)
+			}
+		}
+	}
+}
+
 func TestParseSay(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -71,10 +173,70 @@ func TestParseSay(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockPacket := newMockIncoming(tt.inputData)
 
-			result := ParseSay(mockPacket)
+			result, err := ParseSay(mockPacket)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
 			if result != tt.expectedText {
 				t.Errorf("expected Say result %s, got %s", tt.expectedText, result)
 			}
 		})
 	}
 }
+
+func TestParsePacketMalformed(t *testing.T) {
+	tests := []struct {
+		name      string
+		inputData []byte
+	}{
+		{"Empty Packet", []byte{}},
+		{"Say Without Text", []byte{0x96, TALKTYPE_SAY}},
+		{"Say Text Past The End", []byte{0x96, TALKTYPE_SAY, 0x10, 0x00, 'H', 'i'}},
+		{"Private Say Without Receiver", []byte{0x96, TALKTYPE_PRIVATE, 0x04, 0x00, 'J'}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := newMockClient()
+
+			if err := ParsePacket(context.Background(), mockClient, newMockIncoming(tt.inputData)); err == nil {
+				t.Error("expected an error, got none")
+			}
+
+			select {
+			case cmd := <-mockClient.CommandCh:
+				t.Errorf("expected no command for a malformed packet, got %s", cmd)
+			default:
+			}
+		})
+	}
+}
+
+func FuzzParsePacket(f *testing.F) {
+	f.Add([]byte{0x14})
+	f.Add([]byte{0x6F})
+	f.Add([]byte{0x96, TALKTYPE_SAY, 0x06, 0x00, '/', 'p', 'a', 'u', 's', 'e'})
+	f.Add([]byte{0x96, TALKTYPE_PRIVATE, 0x04, 0x00, 'J', 'o', 'h', 'n', 0x02, 0x00, 'H', 'i'})
+	f.Add([]byte{0x96, TALKTYPE_CHANNEL_Y, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		mockClient := newMockClient()
+		ParsePacket(context.Background(), mockClient, newMockIncoming(data))
+	})
+}
+
+func FuzzParseSay(f *testing.F) {
+	f.Add([]byte{TALKTYPE_SAY, 0x02, 0x00, 'H', 'i'})
+	f.Add([]byte{TALKTYPE_PRIVATE_RED, 0x01, 0x00, 'A', 0x02, 0x00, 'H', 'i'})
+	f.Add([]byte{TALKTYPE_CHANNEL_R1, 0x05, 0x00, 0x00, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		text, err := ParseSay(newMockIncoming(data))
+		if err != nil && text != "" {
+			t.Errorf("expected empty text on error, got %q", text)
+		}
+		if len(text) > len(data) {
+			t.Errorf("text of %d bytes parsed from %d bytes of input", len(text), len(data))
+		}
+	})
+}
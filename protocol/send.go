@@ -2,8 +2,9 @@ package protocol
 
 import (
 	"fmt"
+	"go-opentibia-camplayerserver/client"
 	"go-opentibia-camplayerserver/packet"
-	"net"
+	"strings"
 )
 
 const DEFAULT_PACKET_SIZE = 1024
@@ -24,40 +25,137 @@ const (
 	MESSAGE_STATUS_CONSOLE_RED    MessageType = 0x19
 )
 
-func SendRawData(conn net.Conn, xteaKey [4]uint32, rawData *[]byte) {
-	packet := packet.NewOutgoing(len(*rawData))
+// server ping opcodes: older protocols ping with 0x1E, newer ones use 0x1D for the ping and 0x1E for the ping back
+const (
+	OPCODE_PING      = 0x1D
+	OPCODE_PING_BACK = 0x1E
+)
+
+// IsPingPacket tells whether a packet carries nothing but a ping
+func IsPingPacket(data []byte) bool {
+	return len(data) == 1 && (data[0] == OPCODE_PING || data[0] == OPCODE_PING_BACK)
+}
+
+// message types by the name used in the config files
+var messageTypeNames = map[string]MessageType{
+	"console_yellow": MESSAGE_STATUS_CONSOLE_YELLOW,
+	"console_lblue":  MESSAGE_STATUS_CONSOLE_LBLUE,
+	"console_orange": MESSAGE_STATUS_CONSOLE_ORANGE,
+	"warning":        MESSAGE_STATUS_WARNING,
+	"event_advance":  MESSAGE_EVENT_ADVANCE,
+	"event_default":  MESSAGE_EVENT_DEFAULT,
+	"status_default": MESSAGE_STATUS_DEFAULT,
+	"info_descr":     MESSAGE_INFO_DESCR,
+	"status_small":   MESSAGE_STATUS_SMALL,
+	"console_blue":   MESSAGE_STATUS_CONSOLE_BLUE,
+	"console_red":    MESSAGE_STATUS_CONSOLE_RED,
+}
+
+func ParseMessageType(name string) (MessageType, error) {
+	messageType, ok := messageTypeNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown message type %q", name)
+	}
+	return messageType, nil
+}
+
+func SendRawData(c *client.Client, rawData *[]byte) {
+	packet := packet.AcquireOutgoing(len(*rawData))
 	packet.AddBytes(*rawData)
 
-	SendData(conn, xteaKey, packet)
+	SendData(c, packet)
 }
 
-func SendClientError(conn net.Conn, xteaKey [4]uint32, errorData string) {
+func SendClientError(c *client.Client, errorData string) {
 	packet := packet.NewOutgoing(DEFAULT_PACKET_SIZE)
 	packet.AddUint8(0x0A)
 	packet.AddString(errorData)
 
-	SendData(conn, xteaKey, packet)
+	SendData(c, packet)
 }
 
-func SendTextMessage(conn net.Conn, xteaKey [4]uint32, message string, messageType MessageType) {
+func SendTextMessage(c *client.Client, message string, messageType MessageType) {
 	packet := packet.NewOutgoing(1 + 2 + len(message)) // message type + string length + string
 	packet.AddUint8(0xB4)
 	packet.AddUint8(uint8(messageType))
 	packet.AddString(message)
 
-	SendData(conn, xteaKey, packet)
+	SendData(c, packet)
 }
 
-func SendData(conn net.Conn, xteaKey [4]uint32, packet *packet.Outgoing) error {
-	packet.XteaEncrypt(xteaKey)
-	packet.HeaderAddSize()
+// SendData encodes and sends the packet, a packet from packet.AcquireOutgoing is released once written
+func SendData(c *client.Client, outgoing *packet.Outgoing) error {
+	c.SendMutex.Lock()
+	defer c.SendMutex.Unlock()
+
+	encodeData(c, outgoing)
+
+	if c.Writer != nil {
+		return c.Writer.WritePacket(outgoing)
+	}
+
+	err := write(c, outgoing.Get())
+	outgoing.Release()
+	return err
+}
 
-	dataToSend := packet.Get()
+// SendRawDataBatch frames every raw packet on its own and sends them all in a single write
+func SendRawDataBatch(c *client.Client, rawData [][]byte) error {
+	c.SendMutex.Lock()
+	defer c.SendMutex.Unlock()
 
-	_, err := conn.Write(dataToSend)
+	// the frames are at most as large as the buffers they are encoded in
+	size := 0
+	for _, data := range rawData {
+		size += len(data) + packet.HEADER_OFFSET + packet.MULTIPLE_OF_EIGHT
+	}
+
+	dataToSend := make([]byte, 0, size)
+	for _, data := range rawData {
+		packet := packet.AcquireOutgoing(len(data))
+		packet.AddBytes(data)
+		dataToSend = append(dataToSend, encodeData(c, packet)...)
+		packet.Release()
+	}
+
+	if len(dataToSend) == 0 {
+		return nil
+	}
+
+	return write(c, dataToSend)
+}
+
+func write(c *client.Client, data []byte) error {
+	if c.Writer != nil {
+		return c.Writer.Write(data)
+	}
+
+	_, err := c.Conn.Write(data)
 	if err != nil {
 		return fmt.Errorf("failed to send data: %v", err)
 	}
 
 	return nil
 }
+
+func encodeData(c *client.Client, outgoing *packet.Outgoing) []byte {
+	switch c.Framing {
+	case packet.FRAMING_SEQUENCE:
+		compressed := c.Compression && outgoing.Compress()
+		outgoing.XteaEncryptSequencedExpanded(c.ExpandedXteaKey())
+		outgoing.AddSequence(c.SendSequence, compressed)
+		outgoing.HeaderAddBlockCount()
+		c.SendSequence++
+
+	case packet.FRAMING_CHECKSUM:
+		outgoing.XteaEncryptExpanded(c.ExpandedXteaKey())
+		outgoing.AddChecksum()
+		outgoing.HeaderAddSize()
+
+	default:
+		outgoing.XteaEncryptExpanded(c.ExpandedXteaKey())
+		outgoing.HeaderAddSize()
+	}
+
+	return outgoing.Get()
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/packet"
+	"testing"
+)
+
+type discardConn struct {
+	MockConn
+}
+
+func (d *discardConn) Write(data []byte) (int, error) { return len(data), nil }
+
+// a typical game packet of a cam
+var benchmarkPacket = make([]byte, 180)
+
+func BenchmarkSendRawData(b *testing.B) {
+	framings := []struct {
+		name    string
+		framing packet.FramingMode
+	}{
+		{"Plain", packet.FRAMING_PLAIN},
+		{"Checksum", packet.FRAMING_CHECKSUM},
+		{"Sequence", packet.FRAMING_SEQUENCE},
+	}
+
+	for _, framing := range framings {
+		b.Run(framing.name, func(b *testing.B) {
+			c := &client.Client{Conn: &discardConn{}, XteaKey: [4]uint32{1, 2, 3, 4}, Framing: framing.framing}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(benchmarkPacket)))
+
+			for i := 0; i < b.N; i++ {
+				SendRawData(c, &benchmarkPacket)
+			}
+		})
+	}
+}
+
+func BenchmarkSendRawDataThroughWriter(b *testing.B) {
+	c := &client.Client{Conn: &discardConn{}, XteaKey: [4]uint32{1, 2, 3, 4}, Framing: packet.FRAMING_CHECKSUM}
+	c.Writer = client.NewWriter(c.Conn, client.WriterOptions{Policy: client.QUEUE_FULL_PAUSE})
+	defer c.Writer.Close()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkPacket)))
+
+	for i := 0; i < b.N; i++ {
+		SendRawData(c, &benchmarkPacket)
+	}
+}
+
+func BenchmarkSendRawDataBatch(b *testing.B) {
+	c := &client.Client{Conn: &discardConn{}, XteaKey: [4]uint32{1, 2, 3, 4}, Framing: packet.FRAMING_CHECKSUM}
+	batch := make([][]byte, 64)
+	for i := range batch {
+		batch[i] = benchmarkPacket
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(batch) * len(benchmarkPacket)))
+
+	for i := 0; i < b.N; i++ {
+		SendRawDataBatch(c, batch)
+	}
+}
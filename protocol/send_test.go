@@ -2,7 +2,10 @@ package protocol
 
 import (
 	"bytes"
+	"encoding/binary"
+	"go-opentibia-camplayerserver/client"
 	"go-opentibia-camplayerserver/packet"
+	"hash/adler32"
 	"net"
 	"testing"
 	"time"
@@ -14,7 +17,7 @@ type MockConn struct {
 }
 
 func (m *MockConn) Write(data []byte) (int, error) {
-	m.writtenData = data
+	m.writtenData = append([]byte(nil), data...)
 	if m.err != nil {
 		return 0, m.err
 	}
@@ -34,7 +37,7 @@ func TestSendRawData(t *testing.T) {
 	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
 	mockConn := &MockConn{}
 
-	SendRawData(mockConn, xteaKey, &rawData)
+	SendRawData(&client.Client{Conn: mockConn, XteaKey: xteaKey}, &rawData)
 
 	expectedData := []byte{0x08, 0x00, 0x5c, 0xb8, 0x3e, 0x2c, 0xc8, 0x1f, 0x36, 0x7d}
 
@@ -48,7 +51,7 @@ func TestSendClientError(t *testing.T) {
 	errorData := "Test error"
 	mockConn := &MockConn{}
 
-	SendClientError(mockConn, xteaKey, errorData)
+	SendClientError(&client.Client{Conn: mockConn, XteaKey: xteaKey}, errorData)
 
 	expectedData := []byte{0x10, 0x00, 0x5d, 0x2b, 0x35, 0x14, 0x6f, 0xe5, 0x65, 0x81, 0x1d, 0x7c, 0x20, 0x7f, 0x3f, 0xdd, 0x13, 0x5e}
 
@@ -63,7 +66,7 @@ func TestSendTextMessage(t *testing.T) {
 	messageType := MessageType(1)
 	mockConn := &MockConn{}
 
-	SendTextMessage(mockConn, xteaKey, message, messageType)
+	SendTextMessage(&client.Client{Conn: mockConn, XteaKey: xteaKey}, message, messageType)
 
 	expectedData := []byte{0x18, 0x00, 0x3e, 0xfb, 0x4d, 0x03, 0x48, 0x78, 0xfd, 0x39, 0xf3, 0xdb, 0xf6, 0x42, 0x91, 0x11, 0xf7, 0xf0, 0x54, 0xc0, 0xa2, 0x22, 0x54, 0x6c, 0xc7, 0x39}
 
@@ -78,7 +81,7 @@ func TestSendData(t *testing.T) {
 	packet := packet.NewOutgoing(10)
 	packet.AddUint8(0xFF) // example data
 
-	err := SendData(mockConn, xteaKey, packet)
+	err := SendData(&client.Client{Conn: mockConn, XteaKey: xteaKey}, packet)
 	if err != nil {
 		t.Fatalf("Expected no error, but got %v", err)
 	}
@@ -89,3 +92,137 @@ func TestSendData(t *testing.T) {
 		t.Errorf("Expected %v, but got %v", expectedData, mockConn.writtenData)
 	}
 }
+
+func TestSendDataWithChecksum(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+	mockConn := &MockConn{}
+	outgoing := packet.NewOutgoing(10)
+	outgoing.AddUint8(0xFF) // example data
+
+	err := SendData(&client.Client{Conn: mockConn, XteaKey: xteaKey, Framing: packet.FRAMING_CHECKSUM}, outgoing)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	// same encrypted block as TestSendData, preceded by its adler32 checksum
+	expectedData := []byte{0x0c, 0x00, 0x3c, 0x03, 0x27, 0x0c, 0x30, 0x60, 0x3f, 0x01, 0xf7, 0x8d, 0x16, 0xd1}
+
+	if !bytes.Equal(mockConn.writtenData, expectedData) {
+		t.Errorf("Expected %v, but got %v", expectedData, mockConn.writtenData)
+	}
+
+	checksum := binary.LittleEndian.Uint32(mockConn.writtenData[2:])
+	if checksum != adler32.Checksum(mockConn.writtenData[6:]) {
+		t.Errorf("Expected checksum to match the encrypted payload, got 0x%08x", checksum)
+	}
+}
+
+func TestSendDataSequenced(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+	mockConn := &MockConn{}
+	c := &client.Client{Conn: mockConn, XteaKey: xteaKey, Framing: packet.FRAMING_SEQUENCE, SendSequence: 5}
+
+	for expectedSequence := uint32(5); expectedSequence < 7; expectedSequence++ {
+		outgoing := packet.NewOutgoing(10)
+		outgoing.AddUint8(0xFF)
+
+		if err := SendData(c, outgoing); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if blocks := binary.LittleEndian.Uint16(mockConn.writtenData); blocks != 1 {
+			t.Errorf("Expected 1 block, got %d", blocks)
+		}
+
+		if sequence := binary.LittleEndian.Uint32(mockConn.writtenData[2:]); sequence != expectedSequence {
+			t.Errorf("Expected sequence %d, got %d", expectedSequence, sequence)
+		}
+	}
+
+	if c.SendSequence != 7 {
+		t.Errorf("Expected next sequence to be 7, got %d", c.SendSequence)
+	}
+}
+
+func TestSendDataSequencedCompressed(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+	mockConn := &MockConn{}
+	c := &client.Client{Conn: mockConn, XteaKey: xteaKey, Framing: packet.FRAMING_SEQUENCE, Compression: true}
+
+	rawData := bytes.Repeat([]byte{0x00, 0x01}, 200)
+	SendRawData(c, &rawData)
+
+	sequence := binary.LittleEndian.Uint32(mockConn.writtenData[2:])
+	if sequence&packet.SEQUENCE_COMPRESSED_FLAG == 0 {
+		t.Errorf("Expected compression flag to be set, got 0x%x", sequence)
+	}
+
+	if len(mockConn.writtenData) >= len(rawData) {
+		t.Errorf("Expected compressed frame smaller than %d bytes, got %d", len(rawData), len(mockConn.writtenData))
+	}
+}
+
+type countingConn struct {
+	MockConn
+	writes int
+}
+
+func (c *countingConn) Write(data []byte) (int, error) {
+	c.writes++
+	c.writtenData = append(c.writtenData, data...)
+	return len(data), nil
+}
+
+func TestSendRawDataBatch(t *testing.T) {
+	xteaKey := [4]uint32{0x1, 0x2, 0x3, 0x4}
+	conn := &countingConn{}
+
+	rawData := [][]byte{{0x01, 0x02, 0x03}, {0x01, 0x02, 0x03}}
+	if err := SendRawDataBatch(&client.Client{Conn: conn, XteaKey: xteaKey}, rawData); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if conn.writes != 1 {
+		t.Errorf("Expected a single write, got %d", conn.writes)
+	}
+
+	// two copies of the frame from TestSendRawData
+	frame := []byte{0x08, 0x00, 0x5c, 0xb8, 0x3e, 0x2c, 0xc8, 0x1f, 0x36, 0x7d}
+	expectedData := append(append([]byte(nil), frame...), frame...)
+
+	if !bytes.Equal(conn.writtenData, expectedData) {
+		t.Errorf("Expected %v, but got %v", expectedData, conn.writtenData)
+	}
+}
+
+func TestSendRawDataBatchEmpty(t *testing.T) {
+	conn := &countingConn{}
+
+	if err := SendRawDataBatch(&client.Client{Conn: conn}, nil); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	if conn.writes != 0 {
+		t.Errorf("Expected no write for an empty batch, got %d", conn.writes)
+	}
+}
+
+func TestParseMessageType(t *testing.T) {
+	if messageType, err := ParseMessageType(" Console_Blue "); err != nil || messageType != MESSAGE_STATUS_CONSOLE_BLUE {
+		t.Errorf("expected console blue, got %d and %v", messageType, err)
+	}
+
+	if _, err := ParseMessageType("loud"); err == nil {
+		t.Error("expected an error for an unknown message type")
+	}
+}
+
+func TestIsPingPacket(t *testing.T) {
+	if !IsPingPacket([]byte{OPCODE_PING}) || !IsPingPacket([]byte{OPCODE_PING_BACK}) {
+		t.Error("expected a lone ping opcode to be a ping packet")
+	}
+
+	if IsPingPacket([]byte{OPCODE_PING, 0x0A}) || IsPingPacket(nil) || IsPingPacket([]byte{0x0A}) {
+		t.Error("expected packets with more than a ping not to be ping packets")
+	}
+}
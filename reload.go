@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-opentibia-camplayerserver/access"
+	"go-opentibia-camplayerserver/cam"
+	"go-opentibia-camplayerserver/config"
+	"go-opentibia-camplayerserver/library"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// reloader applies the config file to the running server when it changes, it is only used from its goroutine
+type reloader struct {
+	ctx     context.Context
+	running config.Config // the config in effect, the fields needing a restart keep their value at startup
+	server  *camServer
+	cache   *cam.CamCache
+	// stops the watch of the cam directory, nil when it is not watched
+	stopWatching context.CancelFunc
+}
+
+// startReloading reloads the config on SIGHUP and when the config file changes, until ctx is done
+func startReloading(ctx context.Context, r *reloader) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	changes := make(chan struct{}, 1)
+	err := config.Watch(ctx, func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+			// a reload is already on its way
+		}
+	})
+	if err != nil {
+		fmt.Println("Error watching config file, reloading it on SIGHUP only:", err)
+	}
+
+	go func() {
+		defer signal.Stop(hangups)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				fmt.Println("SIGHUP received, reloading config")
+				r.reload()
+			case <-changes:
+				fmt.Println("Config file changed, reloading config")
+				r.reload()
+			}
+		}
+	}()
+}
+
+func (r *reloader) reload() {
+	loaded, err := config.Reload()
+	if err != nil {
+		fmt.Printf("[reloader.reload] - Keeping the running config: %v\n", err)
+		return
+	}
+
+	restartRequired, err := r.apply(loaded)
+	if err != nil {
+		fmt.Printf("[reloader.reload] - Keeping the running config: %v\n", err)
+		return
+	}
+
+	fmt.Println("Config reloaded, new sessions use it")
+	if len(restartRequired) > 0 {
+		fmt.Printf("[reloader.reload] - Restart the server to apply the changes to %s\n", strings.Join(restartRequired, ", "))
+	}
+}
+
+// apply swaps the reloadable part of loaded into the running server and tells the fields that changed but need a
+// restart; everything is built before anything is swapped, so an invalid config leaves the server as it was
+func (r *reloader) apply(loaded config.Config) ([]string, error) {
+	effective, restartRequired := config.Reloaded(r.running, loaded)
+
+	banList, err := access.LoadBanList(effective.Access.BanListFile)
+	if err != nil {
+		return nil, err
+	}
+
+	playerOptions, err := newPlayerOptions(&effective, r.cache)
+	if err != nil {
+		return nil, fmt.Errorf("player config: %w", err)
+	}
+
+	camLibrary := r.server.current.Load().library
+	stopWatching := r.stopWatching
+	movedLibrary := effective.CamServer.CamDirectory != r.running.CamServer.CamDirectory
+	if movedLibrary {
+		camLibrary = library.New(effective.CamServer.CamDirectory)
+		if _, err := camLibrary.Cams(); err != nil {
+			return nil, err
+		}
+		stopWatching = startWatchingLibrary(r.ctx, &effective, camLibrary, r.cache)
+	}
+
+	r.server.accessController.SetLimits(newAccessLimits(&effective))
+	r.server.accessController.SetBanList(banList)
+	r.server.sessions.SetPlayerOptions(playerOptions)
+	r.server.configure(&effective, camLibrary)
+
+	if movedLibrary && r.stopWatching != nil {
+		r.stopWatching()
+	}
+	r.stopWatching = stopWatching
+	r.running = effective
+
+	return restartRequired, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go-opentibia-camplayerserver/access"
+	"go-opentibia-camplayerserver/cam"
+	"go-opentibia-camplayerserver/config"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReloadAppliesTheReloadableFields(t *testing.T) {
+	server, _ := newTestServer(t, map[string]string{testCamName: "< 0 a1\n"}, cam.PlayerOptions{})
+
+	running := config.Config{CamServer: config.CamServer{Port: 7171, CamDirectory: server.current.Load().library.Root()}}
+	server.configure(&running, server.current.Load().library)
+	r := &reloader{ctx: context.Background(), running: running, server: server}
+
+	recordings := t.TempDir()
+	os.WriteFile(filepath.Join(recordings, "Lobby.cam"), []byte("< 0 a1\n"), 0644)
+
+	loaded := running
+	loaded.CamServer.Port = 7272
+	loaded.CamServer.CamDirectory = recordings
+	loaded.CamServer.DefaultCam = "Lobby"
+	loaded.Access.MaxSessions = 1
+	loaded.Motd = "Welcome back"
+
+	restartRequired, err := r.apply(loaded)
+	if err != nil {
+		t.Fatalf("failed to apply the config: %v", err)
+	}
+	if !slices.Equal(restartRequired, []string{"camserver.port"}) {
+		t.Errorf("expected the port to need a restart, got %v", restartRequired)
+	}
+
+	current := server.current.Load()
+	if current.cfg.CamServer.Port != 7171 || current.cfg.Motd != "Welcome back" {
+		t.Errorf("expected the reloadable fields only to be swapped in, got %+v", current.cfg)
+	}
+	if playlist, err := current.resolvePlaylist("Nobody"); err != nil || playlist.Entries[0].Path != filepath.Join(recordings, "Lobby.cam") {
+		t.Errorf("expected the default cam of the new directory, got %+v and %v", playlist, err)
+	}
+
+	ip := net.ParseIP("10.0.0.1")
+	server.accessController.AcquireSession(ip)
+	if _, err := server.accessController.AcquireSession(ip); !errors.Is(err, access.ErrTooManySessions) {
+		t.Errorf("expected the new session limit, got %v", err)
+	}
+
+	// an invalid config leaves the server as it was
+	invalid := r.running
+	invalid.CamServer.OnEnd = "forever"
+	invalid.Motd = "Not this one"
+	if _, err := r.apply(invalid); err == nil {
+		t.Error("expected an invalid end mode to be rejected")
+	}
+
+	missing := r.running
+	missing.CamServer.CamDirectory = filepath.Join(recordings, "missing")
+	if _, err := r.apply(missing); err == nil {
+		t.Error("expected a missing cam directory to be rejected")
+	}
+
+	if server.current.Load() != current || r.running.Motd != "Welcome back" {
+		t.Errorf("expected the running config to be kept, got %+v", server.current.Load().cfg)
+	}
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/packet"
+	"go-opentibia-camplayerserver/protocol"
+	"io"
+)
+
+var errPingPacket = errors.New("ping packet")
+
+// readClientPackets reads and dispatches client packets until the connection fails or is closed by the session
+func readClientPackets(ctx context.Context, c *client.Client) error {
+	header := make([]byte, packet.HEADER_LENGTH)
+
+	for {
+		if _, err := io.ReadFull(c.Conn, header); err != nil {
+			return fmt.Errorf("[readClientPackets] - error reading header: %w", err)
+		}
+
+		// parse header: it has only the packet length
+		packetLength := int(binary.LittleEndian.Uint16(header))
+
+		incoming := packet.NewIncoming(packetLength)
+		if _, err := io.ReadFull(c.Conn, incoming.PeekBuffer()); err != nil {
+			return fmt.Errorf("[readClientPackets] - error reading packet: %w", err)
+		}
+
+		if err := decodeClientPacket(c, incoming); err != nil {
+			if errors.Is(err, packet.ErrChecksumMismatch) {
+				c.ChecksumFailures++
+				fmt.Printf("Rejecting packet from %s (%d so far): %v\n", c.Conn.RemoteAddr(), c.ChecksumFailures, err)
+			} else if errors.Is(err, packet.ErrSequenceMismatch) {
+				c.SequenceFailures++
+				fmt.Printf("Rejecting packet from %s (%d so far): %v\n", c.Conn.RemoteAddr(), c.SequenceFailures, err)
+			} else if !errors.Is(err, errPingPacket) {
+				fmt.Printf("Error decoding packet: %v\n", err)
+			}
+			continue
+		}
+
+		if err := protocol.ParsePacket(ctx, c, incoming); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Printf("Dropping malformed packet: %v\n", err)
+		}
+	}
+}
+
+// decodeClientPacket validates and decrypts a client packet according to the framing of its protocol version
+func decodeClientPacket(c *client.Client, incoming *packet.Incoming) error {
+	switch c.Framing {
+	case packet.FRAMING_SEQUENCE:
+		sequence, compressed := incoming.GetSequence()
+		if err := incoming.Err(); err != nil {
+			return err
+		}
+
+		// sequenced clients send their keep alive as a bare zero sequence
+		if sequence == 0 && !compressed {
+			return errPingPacket
+		}
+
+		// the first sequence is taken as is, every packet after it must follow in order
+		if c.ReceiveSequence != 0 && sequence != (c.ReceiveSequence+1)&packet.SEQUENCE_NUMBER_MASK {
+			return fmt.Errorf("%w: expected sequence %d, received %d", packet.ErrSequenceMismatch, c.ReceiveSequence+1, sequence)
+		}
+		c.ReceiveSequence = sequence
+
+		if err := incoming.XteaDecryptSequencedExpanded(c.ExpandedXteaKey()); err != nil {
+			return err
+		}
+
+		if compressed {
+			return incoming.Decompress()
+		}
+		return nil
+
+	case packet.FRAMING_CHECKSUM:
+		if err := incoming.VerifyChecksum(); err != nil {
+			return err
+		}
+	}
+
+	return incoming.XteaDecryptExpanded(c.ExpandedXteaKey())
+}
@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/cam"
+	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/library"
+	"go-opentibia-camplayerserver/protocol"
+	"sync"
+	"time"
+)
+
+// Session is one viewer connection: a player goroutine streaming the cam and a reader goroutine for the
+// client packets, bound to a context so whichever side ends first takes the other one down with it
+type Session struct {
+	Id            uint64
+	Client        *client.Client
+	Playlist      library.Playlist
+	playerOptions cam.PlayerOptions
+	cancel        context.CancelFunc
+	done          chan struct{}
+}
+
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) Stop() {
+	s.cancel()
+}
+
+func (s *Session) run(ctx context.Context, onEnd func()) {
+	defer close(s.done)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		defer s.cancel()
+
+		if err := cam.HandlePlaylistStreaming(ctx, s.Client, s.Playlist, s.playerOptions); err != nil {
+			fmt.Printf("[session %d] - playback ended: %v\n", s.Id, err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer s.cancel()
+
+		if err := readClientPackets(ctx, s.Client); err != nil && ctx.Err() == nil {
+			fmt.Printf("[session %d] - client disconnected: %v\n", s.Id, err)
+		}
+	}()
+
+	// closing the connection is what unblocks the reader, the player watches the context
+	<-ctx.Done()
+	s.Client.Close()
+	wg.Wait()
+
+	if onEnd != nil {
+		onEnd()
+	}
+}
+
+var ErrShuttingDown = errors.New("The server is shutting down.")
+
+type Manager struct {
+	mutex         sync.Mutex
+	closed        bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	sessions      map[uint64]*Session
+	nextId        uint64
+	wg            sync.WaitGroup
+	playerOptions cam.PlayerOptions
+}
+
+func NewManager(playerOptions cam.PlayerOptions) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Manager{
+		ctx:           ctx,
+		cancel:        cancel,
+		sessions:      make(map[uint64]*Session),
+		playerOptions: playerOptions,
+	}
+}
+
+// SetPlayerOptions replaces the options the sessions started from now on play with, running sessions keep theirs
+func (m *Manager) SetPlayerOptions(playerOptions cam.PlayerOptions) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.playerOptions = playerOptions
+}
+
+// Start runs a new session for the client until either side ends it or the manager shuts down, onEnd runs after cleanup
+func (m *Manager) Start(c *client.Client, playlist library.Playlist, onEnd func()) (*Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return nil, ErrShuttingDown
+	}
+
+	m.nextId++
+	ctx, cancel := context.WithCancel(m.ctx)
+
+	s := &Session{
+		Id:            m.nextId,
+		Client:        c,
+		Playlist:      playlist,
+		playerOptions: m.playerOptions,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+	m.sessions[s.Id] = s
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		s.run(ctx, onEnd)
+
+		m.mutex.Lock()
+		delete(m.sessions, s.Id)
+		m.mutex.Unlock()
+	}()
+
+	return s, nil
+}
+
+func (m *Manager) Count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.sessions)
+}
+
+func (m *Manager) Sessions() []*Session {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (m *Manager) Broadcast(message string, messageType protocol.MessageType) {
+	for _, s := range m.Sessions() {
+		protocol.SendTextMessage(s.Client, message, messageType)
+	}
+}
+
+// Shutdown warns the viewers and gives them gracePeriod to finish before every session is stopped,
+// it returns once all of them have cleaned up
+func (m *Manager) Shutdown(gracePeriod time.Duration) {
+	m.mutex.Lock()
+	m.closed = true
+	m.mutex.Unlock()
+
+	allDone := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(allDone)
+	}()
+
+	if gracePeriod > 0 && m.Count() > 0 {
+		fmt.Printf("Draining %d sessions for %s\n", m.Count(), gracePeriod)
+		m.Broadcast(fmt.Sprintf("The server is shutting down in %d seconds.", int(gracePeriod.Seconds())), protocol.MESSAGE_STATUS_WARNING)
+
+		select {
+		case <-allDone:
+		case <-time.After(gracePeriod):
+		}
+	}
+
+	m.cancel()
+	<-allDone
+}
@@ -0,0 +1,222 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/cam"
+	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/command"
+	"go-opentibia-camplayerserver/crypt"
+	"go-opentibia-camplayerserver/library"
+	"go-opentibia-camplayerserver/packet"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testXteaKey = [4]uint32{1, 2, 3, 4}
+
+// writeLongCam writes a cam lasting about a minute, so playback never ends on its own during a test
+func writeLongCam(t *testing.T) string {
+	t.Helper()
+
+	var lines strings.Builder
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&lines, "< %d 0a0102\n", 1000+i*1000)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "long.cam")
+	if err := os.WriteFile(filePath, []byte(lines.String()), 0644); err != nil {
+		t.Fatalf("failed to write cam file: %v", err)
+	}
+	return filePath
+}
+
+// newPipeClient returns a server side client and the viewer end of the connection, whose output is discarded
+func newPipeClient(t *testing.T) (*client.Client, net.Conn) {
+	t.Helper()
+
+	server, viewer := net.Pipe()
+	t.Cleanup(func() { viewer.Close() })
+
+	go io.Copy(io.Discard, viewer)
+
+	c := &client.Client{
+		Conn:      server,
+		XteaKey:   testXteaKey,
+		CommandCh: make(chan command.Command),
+	}
+	c.Writer = client.NewWriter(server, client.WriterOptions{})
+
+	return c, viewer
+}
+
+func sendClientPacket(t *testing.T, viewer net.Conn, data ...byte) {
+	t.Helper()
+
+	outgoing := packet.NewOutgoing(len(data))
+	outgoing.AddBytes(data)
+	outgoing.XteaEncrypt(testXteaKey)
+	outgoing.HeaderAddSize()
+
+	if _, err := viewer.Write(outgoing.Get()); err != nil {
+		t.Fatalf("failed to send client packet: %v", err)
+	}
+}
+
+// newSequencedClientPacket encodes data as a sequenced client packet, without its length header as it is decoded
+func newSequencedClientPacket(sequence uint32, data ...byte) *packet.Incoming {
+	outgoing := packet.NewOutgoing(len(data))
+	outgoing.AddBytes(data)
+	expandedXteaKey := crypt.ExpandXteaKey(testXteaKey)
+	outgoing.XteaEncryptSequencedExpanded(&expandedXteaKey)
+	outgoing.AddSequence(sequence, false)
+	outgoing.HeaderAddBlockCount()
+
+	encoded := outgoing.Get()[packet.HEADER_LENGTH:]
+	incoming := packet.NewIncoming(len(encoded))
+	copy(incoming.PeekBuffer(), encoded)
+	return incoming
+}
+
+func waitDone(t *testing.T, s *Session) {
+	t.Helper()
+
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected session to end")
+	}
+}
+
+func TestSessionEndsWhenClientDisconnects(t *testing.T) {
+	manager := NewManager(cam.PlayerOptions{})
+	c, viewer := newPipeClient(t)
+
+	ended := make(chan struct{})
+	s, err := manager.Start(c, library.Single(writeLongCam(t)), func() { close(ended) })
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	viewer.Close()
+	waitDone(t, s)
+
+	select {
+	case <-ended:
+	default:
+		t.Error("expected onEnd to run once the session ended")
+	}
+
+	if manager.Count() != 0 {
+		t.Errorf("expected no active sessions, got %d", manager.Count())
+	}
+}
+
+func TestSessionEndsWhenViewerLogsOut(t *testing.T) {
+	manager := NewManager(cam.PlayerOptions{})
+	c, viewer := newPipeClient(t)
+
+	s, err := manager.Start(c, library.Single(writeLongCam(t)), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	sendClientPacket(t, viewer, 0x14)
+	waitDone(t, s)
+
+	// the reader side must have been stopped by closing the connection
+	if _, err := c.Conn.Read(make([]byte, 1)); err == nil {
+		t.Error("expected connection to be closed")
+	}
+}
+
+func TestSessionEndsWhenPlaybackFails(t *testing.T) {
+	manager := NewManager(cam.PlayerOptions{})
+	c, _ := newPipeClient(t)
+
+	s, err := manager.Start(c, library.Single(filepath.Join(t.TempDir(), "missing.cam")), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// nothing is sent by the viewer, only the player failing can end it
+	waitDone(t, s)
+}
+
+func TestManagerShutdownDrainsSessions(t *testing.T) {
+	manager := NewManager(cam.PlayerOptions{})
+	playlist := library.Single(writeLongCam(t))
+
+	var sessions []*Session
+	for i := 0; i < 3; i++ {
+		c, _ := newPipeClient(t)
+		s, err := manager.Start(c, playlist, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	start := time.Now()
+	manager.Shutdown(100 * time.Millisecond)
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected viewers to get the grace period, shutdown took %s", elapsed)
+	}
+
+	for _, s := range sessions {
+		waitDone(t, s)
+	}
+
+	if _, err := manager.Start(&client.Client{}, playlist, nil); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected ErrShuttingDown after shutdown, got %v", err)
+	}
+}
+
+func TestManagerShutdownEndsEarlyWhenSessionsFinish(t *testing.T) {
+	manager := NewManager(cam.PlayerOptions{})
+	c, viewer := newPipeClient(t)
+
+	if _, err := manager.Start(c, library.Single(writeLongCam(t)), nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		viewer.Close()
+	}()
+
+	start := time.Now()
+	manager.Shutdown(10 * time.Second)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected shutdown to end once the last viewer left, took %s", elapsed)
+	}
+}
+
+func TestDecodeClientPacketChecksTheSequence(t *testing.T) {
+	c := &client.Client{XteaKey: testXteaKey, Framing: packet.FRAMING_SEQUENCE}
+
+	for _, sequence := range []uint32{5, 6} {
+		if err := decodeClientPacket(c, newSequencedClientPacket(sequence, 0x14)); err != nil {
+			t.Fatalf("expected sequence %d to be accepted, got %v", sequence, err)
+		}
+	}
+
+	err := decodeClientPacket(c, newSequencedClientPacket(8, 0x14))
+	if !errors.Is(err, packet.ErrSequenceMismatch) || errors.Is(err, packet.ErrChecksumMismatch) {
+		t.Errorf("expected a sequence mismatch, got %v", err)
+	}
+
+	// checksum framing reports its own mismatch
+	c = &client.Client{XteaKey: testXteaKey, Framing: packet.FRAMING_CHECKSUM}
+	incoming := packet.NewIncoming(12)
+	if err := decodeClientPacket(c, incoming); !errors.Is(err, packet.ErrChecksumMismatch) {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+}
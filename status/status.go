@@ -0,0 +1,213 @@
+package status
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_FORMAT renders as "100.0/200.0 | Speed: 1.00x"
+const DEFAULT_FORMAT = "{position}/{duration} | {speed}"
+
+const (
+	DEFAULT_BAR_WIDTH = 20
+	MAXIMUM_BAR_WIDTH = 100
+)
+
+// State is what the status line reports about the playback
+type State struct {
+	Position     float64 // seconds
+	Duration     float64 // seconds, zero when unknown
+	Speed        float64 // zero when paused
+	RecordedAt   time.Time
+	SkipIdle     bool
+	SkippingIdle bool // an idle period is being skipped right now
+}
+
+type token struct {
+	render   func(state State, arg int) string
+	takesArg bool
+	arg      int
+}
+
+// Tokens accepted in a format spec, {bar} also takes its width as {bar:30}
+var tokens = map[string]token{
+	"position":       {render: func(s State, _ int) string { return fmt.Sprintf("%.1f", s.Position) }},
+	"position_clock": {render: func(s State, _ int) string { return formatClock(s.Position) }},
+	"duration":       {render: renderDuration},
+	"duration_clock": {render: renderDurationClock},
+	"remaining":      {render: renderRemaining},
+	"percent":        {render: renderPercent},
+	"recorded":       {render: renderRecorded},
+	"speed":          {render: renderSpeed},
+	"bar":            {render: renderBar, takesArg: true, arg: DEFAULT_BAR_WIDTH},
+	"skipidle":       {render: renderSkipIdle},
+}
+
+// Format is a parsed status line spec: text with {token} placeholders
+type Format struct {
+	spec  string
+	parts []part
+}
+
+type part struct {
+	literal string
+	token   *token
+	arg     int
+}
+
+var Default, _ = ParseFormat(DEFAULT_FORMAT)
+
+func ParseFormat(spec string) (*Format, error) {
+	if spec == "" {
+		spec = DEFAULT_FORMAT
+	}
+
+	format := &Format{spec: spec}
+	rest := spec
+
+	for len(rest) > 0 {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			format.parts = append(format.parts, part{literal: rest})
+			break
+		}
+
+		if open > 0 {
+			format.parts = append(format.parts, part{literal: rest[:open]})
+		}
+
+		closing := strings.IndexByte(rest[open:], '}')
+		if closing < 0 {
+			return nil, fmt.Errorf("unclosed { in status format %q", spec)
+		}
+
+		tokenPart, err := parseToken(rest[open+1 : open+closing])
+		if err != nil {
+			return nil, fmt.Errorf("status format %q: %w", spec, err)
+		}
+		format.parts = append(format.parts, tokenPart)
+
+		rest = rest[open+closing+1:]
+	}
+
+	return format, nil
+}
+
+func parseToken(placeholder string) (part, error) {
+	name, argText, hasArg := strings.Cut(placeholder, ":")
+
+	t, ok := tokens[name]
+	if !ok {
+		return part{}, fmt.Errorf("unknown token {%s}", placeholder)
+	}
+
+	arg := t.arg
+	if hasArg {
+		if !t.takesArg {
+			return part{}, fmt.Errorf("token {%s} takes no argument", name)
+		}
+
+		width, err := strconv.Atoi(argText)
+		if err != nil || width <= 0 || width > MAXIMUM_BAR_WIDTH {
+			return part{}, fmt.Errorf("invalid width in {%s}, expected 1 to %d", placeholder, MAXIMUM_BAR_WIDTH)
+		}
+		arg = width
+	}
+
+	return part{token: &t, arg: arg}, nil
+}
+
+func (f *Format) String() string {
+	return f.spec
+}
+
+func (f *Format) Render(state State) string {
+	var line strings.Builder
+
+	for _, p := range f.parts {
+		if p.token == nil {
+			line.WriteString(p.literal)
+		} else {
+			line.WriteString(p.token.render(state, p.arg))
+		}
+	}
+
+	return line.String()
+}
+
+func orUnknown(known bool, render func() string) string {
+	if !known {
+		return "?"
+	}
+	return render()
+}
+
+// formatClock formats seconds as m:ss, or h:mm:ss from one hour on
+func formatClock(seconds float64) string {
+	total := int(seconds)
+	if total < 0 {
+		total = 0
+	}
+
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total/60%60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
+
+func renderDuration(s State, _ int) string {
+	return orUnknown(s.Duration > 0, func() string { return fmt.Sprintf("%.1f", s.Duration) })
+}
+
+func renderDurationClock(s State, _ int) string {
+	return orUnknown(s.Duration > 0, func() string { return formatClock(s.Duration) })
+}
+
+func renderRemaining(s State, _ int) string {
+	return orUnknown(s.Duration > 0, func() string { return formatClock(s.Duration - s.Position) })
+}
+
+func progress(s State) float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return min(max(s.Position/s.Duration, 0), 1)
+}
+
+func renderPercent(s State, _ int) string {
+	return orUnknown(s.Duration > 0, func() string { return fmt.Sprintf("%d%%", int(progress(s)*100)) })
+}
+
+func renderRecorded(s State, _ int) string {
+	return orUnknown(!s.RecordedAt.IsZero(), func() string {
+		return s.RecordedAt.Add(time.Duration(s.Position * float64(time.Second))).Format("15:04:05")
+	})
+}
+
+// renderSpeed also tells when an idle period is being skipped, so the default line shows it
+func renderSpeed(s State, _ int) string {
+	if s.Speed <= 0 {
+		return "Paused"
+	}
+	if s.SkippingIdle {
+		return fmt.Sprintf("Speed: %.2fx, skipping idle", s.Speed)
+	}
+	return fmt.Sprintf("Speed: %.2fx", s.Speed)
+}
+
+func renderSkipIdle(s State, _ int) string {
+	switch {
+	case s.SkippingIdle:
+		return "Skipping idle"
+	case s.SkipIdle:
+		return "Skip idle"
+	}
+	return ""
+}
+
+func renderBar(s State, width int) string {
+	filled := int(progress(s) * float64(width))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
@@ -0,0 +1,77 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+var testState = State{
+	Position:   100,
+	Duration:   200,
+	Speed:      1,
+	RecordedAt: time.Date(2024, 10, 25, 18, 36, 45, 0, time.UTC),
+}
+
+func TestDefaultFormat(t *testing.T) {
+	if got := Default.Render(testState); got != "100.0/200.0 | Speed: 1.00x" {
+		t.Errorf("expected the original status line, got %q", got)
+	}
+
+	format, err := ParseFormat("")
+	if err != nil || format.String() != DEFAULT_FORMAT {
+		t.Errorf("expected an empty spec to be the default format, got %v and %v", format, err)
+	}
+}
+
+func TestRenderTokens(t *testing.T) {
+	tests := []struct {
+		spec     string
+		state    State
+		expected string
+	}{
+		{"{position_clock}/{duration_clock}", testState, "1:40/3:20"},
+		{"{remaining} left", testState, "1:40 left"},
+		{"{percent}", testState, "50%"},
+		{"{recorded}", testState, "18:38:25"},
+		{"{bar:10}", testState, "[#####-----]"},
+		{"{bar}", State{Position: 5, Duration: 4}, "[####################]"},
+		{"{speed}", State{Speed: 0}, "Paused"},
+		{"{duration} {remaining} {percent} {recorded}", State{}, "? ? ? ?"},
+		{"{bar:4}", State{}, "[----]"},
+		{"{position_clock}", State{Position: 3725}, "1:02:05"},
+		{"plain text", testState, "plain text"},
+		{"{speed}", State{Speed: 2, SkippingIdle: true}, "Speed: 2.00x, skipping idle"},
+		{"[{skipidle}]", State{}, "[]"},
+		{"{skipidle}", State{SkipIdle: true}, "Skip idle"},
+		{"{skipidle}", State{SkipIdle: true, SkippingIdle: true}, "Skipping idle"},
+	}
+
+	for _, tt := range tests {
+		format, err := ParseFormat(tt.spec)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", tt.spec, err)
+			continue
+		}
+
+		if got := format.Render(tt.state); got != tt.expected {
+			t.Errorf("expected %q for %q, got %q", tt.expected, tt.spec, got)
+		}
+	}
+}
+
+func TestParseFormatErrors(t *testing.T) {
+	specs := []string{
+		"{position",
+		"{elapsed}",
+		"{speed:2}",
+		"{bar:0}",
+		"{bar:wide}",
+		"{bar:1000}",
+	}
+
+	for _, spec := range specs {
+		if _, err := ParseFormat(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Package viewer is a headless game client for the cam server: it logs in, decrypts what the server sends
+// and sends the control packets of a real client, for end-to-end tests and load testing
+package viewer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-opentibia-camplayerserver/crypt"
+	"go-opentibia-camplayerserver/login"
+	"go-opentibia-camplayerserver/packet"
+	"go-opentibia-camplayerserver/protocol"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	DEFAULT_PROTOCOL_VERSION = 860
+	DEFAULT_CLIENT_OS        = 2
+
+	// client packet opcodes
+	OPCODE_LOGOUT        = 0x14
+	OPCODE_SPEED_UP      = 0x6F
+	OPCODE_SEEK_FORWARD  = 0x70
+	OPCODE_SPEED_DOWN    = 0x71
+	OPCODE_SEEK_BACKWARD = 0x72
+	OPCODE_SAY           = 0x96
+
+	// server packet opcodes
+	OPCODE_LOGIN_ERROR  = 0x0A // only as the single packet sent before the server closes the connection
+	OPCODE_TEXT_MESSAGE = 0xB4
+)
+
+type Options struct {
+	ProtocolVersion uint16          // DEFAULT_PROTOCOL_VERSION when zero
+	ClientOs        uint16          // DEFAULT_CLIENT_OS when zero
+	Encrypter       crypt.Encrypter // public key matching the server key
+	XteaKey         [4]uint32       // a random key when zero
+	AccountNumber   uint32
+	Password        string
+}
+
+// Client is one viewer connection. Reading and sending may happen from different goroutines.
+type Client struct {
+	conn         net.Conn
+	xteaKey      [4]uint32
+	expandedKey  [64]uint32
+	framing      packet.FramingMode
+	sendSequence uint32
+	sendMutex    sync.Mutex
+	header       []byte
+}
+
+// Dial connects to the cam server at address and logs in to play the cam named character
+func Dial(ctx context.Context, address string, character string, options Options) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Login(conn, character, options)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Login sends the login message over conn, the server answers with the cam packets or a login error
+func Login(conn net.Conn, character string, options Options) (*Client, error) {
+	if options.ProtocolVersion == 0 {
+		options.ProtocolVersion = DEFAULT_PROTOCOL_VERSION
+	}
+	if options.ClientOs == 0 {
+		options.ClientOs = DEFAULT_CLIENT_OS
+	}
+	if options.Encrypter == nil {
+		return nil, errors.New("[Login] - no key to encrypt the login with")
+	}
+	if options.XteaKey == [4]uint32{} {
+		var key [16]byte
+		rand.Read(key[:])
+		for i := range options.XteaKey {
+			options.XteaKey[i] = binary.LittleEndian.Uint32(key[i*4:])
+		}
+	}
+
+	block := []byte{0x00}
+	for _, k := range options.XteaKey {
+		block = binary.LittleEndian.AppendUint32(block, k)
+	}
+	block = append(block, 0x00) // gamemaster flag
+	block = binary.LittleEndian.AppendUint32(block, options.AccountNumber)
+	block = binary.LittleEndian.AppendUint16(block, uint16(len(character)))
+	block = append(block, character...)
+	block = binary.LittleEndian.AppendUint16(block, uint16(len(options.Password)))
+	block = append(block, options.Password...)
+
+	if len(block) > login.RSA_BLOCK_SIZE {
+		return nil, fmt.Errorf("[Login] - character %q and password do not fit the login block", character)
+	}
+	block = append(block, make([]byte, login.RSA_BLOCK_SIZE-len(block))...)
+
+	encrypted, err := options.Encrypter.EncryptNoPadding(block)
+	if err != nil {
+		return nil, fmt.Errorf("[Login] - error encrypting login block: %w", err)
+	}
+
+	message := packet.NewOutgoing(12 + login.RSA_BLOCK_SIZE)
+	message.AddUint8(0x0A) // protocol id
+	message.AddUint16(options.ClientOs)
+	message.AddUint16(options.ProtocolVersion)
+	if options.ProtocolVersion >= login.CLIENT_VERSION_PROTOCOL_VERSION {
+		message.AddUint32(uint32(options.ProtocolVersion)) // client version
+	}
+	if options.ProtocolVersion >= login.CONTENT_REVISION_PROTOCOL_VERSION {
+		message.AddUint16(0) // dat revision
+		message.AddUint8(0)  // preview state
+	}
+	message.AddBytes(encrypted)
+
+	if options.ProtocolVersion >= packet.CHECKSUM_PROTOCOL_VERSION {
+		message.AddChecksum()
+	}
+	message.HeaderAddSize()
+
+	if _, err := conn.Write(message.Get()); err != nil {
+		return nil, fmt.Errorf("[Login] - error sending login: %w", err)
+	}
+
+	return newClient(conn, options.XteaKey, packet.FramingForProtocolVersion(options.ProtocolVersion)), nil
+}
+
+func newClient(conn net.Conn, xteaKey [4]uint32, framing packet.FramingMode) *Client {
+	return &Client{
+		conn:         conn,
+		xteaKey:      xteaKey,
+		expandedKey:  crypt.ExpandXteaKey(xteaKey),
+		framing:      framing,
+		sendSequence: 1, // a bare zero sequence is a keep alive
+		header:       make([]byte, packet.HEADER_LENGTH),
+	}
+}
+
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
+
+// ReadPacket returns the next packet sent by the server, decrypted and decompressed
+func (c *Client) ReadPacket() ([]byte, error) {
+	if _, err := io.ReadFull(c.conn, c.header); err != nil {
+		return nil, err
+	}
+
+	length := int(binary.LittleEndian.Uint16(c.header))
+	if c.framing == packet.FRAMING_SEQUENCE {
+		// the header counts the encrypted blocks, the sequence number comes on top of them
+		length = length*packet.MULTIPLE_OF_EIGHT + 4
+	}
+
+	incoming := packet.NewIncoming(length)
+	if _, err := io.ReadFull(c.conn, incoming.PeekBuffer()); err != nil {
+		return nil, err
+	}
+
+	if err := c.decode(incoming); err != nil {
+		return nil, fmt.Errorf("[ReadPacket] - error decoding packet: %w", err)
+	}
+
+	return incoming.PeekBuffer(), nil
+}
+
+func (c *Client) decode(incoming *packet.Incoming) error {
+	switch c.framing {
+	case packet.FRAMING_SEQUENCE:
+		_, compressed := incoming.GetSequence()
+		if err := incoming.XteaDecryptSequencedExpanded(&c.expandedKey); err != nil {
+			return err
+		}
+		if compressed {
+			return incoming.Decompress()
+		}
+		return incoming.Err()
+
+	case packet.FRAMING_CHECKSUM:
+		if err := incoming.VerifyChecksum(); err != nil {
+			return err
+		}
+	}
+
+	return incoming.XteaDecryptExpanded(&c.expandedKey)
+}
+
+// Send frames and encrypts a client packet the way the server expects it for the protocol version
+func (c *Client) Send(data ...byte) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	outgoing := packet.AcquireOutgoing(len(data))
+	defer outgoing.Release()
+	outgoing.AddBytes(data)
+
+	switch c.framing {
+	case packet.FRAMING_SEQUENCE:
+		outgoing.XteaEncryptSequencedExpanded(&c.expandedKey)
+		outgoing.AddSequence(c.sendSequence, false)
+		c.sendSequence++
+	case packet.FRAMING_CHECKSUM:
+		outgoing.XteaEncryptExpanded(&c.expandedKey)
+		outgoing.AddChecksum()
+	default:
+		outgoing.XteaEncryptExpanded(&c.expandedKey)
+	}
+	outgoing.HeaderAddSize()
+
+	if _, err := c.conn.Write(outgoing.Get()); err != nil {
+		return fmt.Errorf("[Send] - error sending packet: %w", err)
+	}
+	return nil
+}
+
+// Say sends text to the default channel, which is how chat commands are typed
+func (c *Client) Say(text string) error {
+	if len(text) > 0xFFFF {
+		return errors.New("[Say] - text is too long")
+	}
+
+	data := []byte{OPCODE_SAY, protocol.TALKTYPE_SAY}
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(text)))
+	return c.Send(append(data, text...)...)
+}
+
+func (c *Client) SpeedUp() error      { return c.Send(OPCODE_SPEED_UP) }
+func (c *Client) SpeedDown() error    { return c.Send(OPCODE_SPEED_DOWN) }
+func (c *Client) SeekForward() error  { return c.Send(OPCODE_SEEK_FORWARD) }
+func (c *Client) SeekBackward() error { return c.Send(OPCODE_SEEK_BACKWARD) }
+func (c *Client) Logout() error       { return c.Send(OPCODE_LOGOUT) }
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+type TextMessage struct {
+	Type protocol.MessageType
+	Text string
+}
+
+// ParseTextMessage decodes a text message packet
+func ParseTextMessage(data []byte) (TextMessage, bool) {
+	if len(data) == 0 || data[0] != OPCODE_TEXT_MESSAGE {
+		return TextMessage{}, false
+	}
+
+	incoming := packet.NewIncoming(len(data))
+	copy(incoming.PeekBuffer(), data)
+	incoming.GetUint8()
+	messageType := incoming.GetUint8()
+	text := incoming.GetString()
+
+	if incoming.Err() != nil || incoming.Remaining() != 0 {
+		return TextMessage{}, false
+	}
+	return TextMessage{Type: protocol.MessageType(messageType), Text: text}, true
+}
+
+// ParseLoginError decodes the error the server sends instead of a session, the opcode is a game packet as
+// well so only the packet right before the connection closed can be one
+func ParseLoginError(data []byte) (string, bool) {
+	if len(data) == 0 || data[0] != OPCODE_LOGIN_ERROR {
+		return "", false
+	}
+
+	incoming := packet.NewIncoming(len(data))
+	copy(incoming.PeekBuffer(), data)
+	incoming.GetUint8()
+	text := incoming.GetString()
+
+	if incoming.Err() != nil || incoming.Remaining() != 0 {
+		return "", false
+	}
+	return text, true
+}
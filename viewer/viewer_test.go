@@ -0,0 +1,161 @@
+package viewer
+
+import (
+	"encoding/binary"
+	"go-opentibia-camplayerserver/client"
+	"go-opentibia-camplayerserver/crypt"
+	"go-opentibia-camplayerserver/login"
+	"go-opentibia-camplayerserver/packet"
+	"go-opentibia-camplayerserver/protocol"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginIsReadByTheServer(t *testing.T) {
+	decrypter, err := crypt.NewRSADecrypter("../key.pem")
+	if err != nil {
+		t.Fatalf("failed to load the server key: %v", err)
+	}
+	encrypter, err := crypt.NewRSAEncrypter("../key.pem")
+	if err != nil {
+		t.Fatalf("failed to load the public key: %v", err)
+	}
+
+	for _, version := range []uint16{760, 860, 1100} {
+		server, viewer := net.Pipe()
+		xteaKey := [4]uint32{5, 6, 7, 8}
+
+		go Login(viewer, "Knight_1", Options{ProtocolVersion: version, Encrypter: encrypter, XteaKey: xteaKey, AccountNumber: 42, Password: "secret"})
+
+		request, err := login.ReadRequest(server, decrypter, time.Second)
+		if err != nil {
+			t.Fatalf("protocol %d: expected the server to read the login, got %v", version, err)
+		}
+
+		if request.ProtocolVersion != version || request.XteaKey != xteaKey || request.Character != "Knight_1" || request.AccountNumber != 42 || request.Password != "secret" {
+			t.Errorf("protocol %d: unexpected login %+v", version, request)
+		}
+
+		server.Close()
+		viewer.Close()
+	}
+}
+
+func TestLoginSendsTheClientVersion(t *testing.T) {
+	expected := map[uint16]int{
+		860:  packet.HEADER_LENGTH + 4 + 5 + login.RSA_BLOCK_SIZE,
+		1000: packet.HEADER_LENGTH + 4 + 5 + 4 + login.RSA_BLOCK_SIZE,
+		1100: packet.HEADER_LENGTH + 4 + 5 + 7 + login.RSA_BLOCK_SIZE,
+	}
+
+	for version, length := range expected {
+		server, viewer := net.Pipe()
+		go Login(viewer, "Knight_1", Options{ProtocolVersion: version, Encrypter: plainRSA{}})
+
+		// the checksum, protocol id, os and version come first
+		message := make([]byte, length)
+		if _, err := io.ReadFull(server, message); err != nil {
+			t.Fatalf("protocol %d: failed to read the login: %v", version, err)
+		}
+		if size := int(binary.LittleEndian.Uint16(message)); size != length-packet.HEADER_LENGTH {
+			t.Errorf("protocol %d: expected a login of %d bytes, got %d", version, length-packet.HEADER_LENGTH, size)
+		}
+		if version >= login.CLIENT_VERSION_PROTOCOL_VERSION && binary.LittleEndian.Uint32(message[11:]) != uint32(version) {
+			t.Errorf("protocol %d: expected the client version after the protocol version, got %x", version, message[11:15])
+		}
+
+		server.Close()
+		viewer.Close()
+	}
+}
+
+func TestLoginPicksARandomXteaKey(t *testing.T) {
+	server, viewer := net.Pipe()
+	defer server.Close()
+	defer viewer.Close()
+
+	go func() {
+		request, _ := login.ReadRequest(server, plainRSA{}, time.Second)
+		if request.XteaKey == ([4]uint32{}) {
+			t.Error("expected a random xtea key")
+		}
+	}()
+
+	c, err := Login(viewer, "Knight_1", Options{Encrypter: plainRSA{}})
+	if err != nil || c.xteaKey == ([4]uint32{}) {
+		t.Fatalf("expected a random xtea key, got %v and %v", c, err)
+	}
+}
+
+type plainRSA struct{}
+
+func (plainRSA) EncryptNoPadding(plaintext []byte) ([]byte, error)  { return plaintext, nil }
+func (plainRSA) DecryptNoPadding(ciphertext []byte) ([]byte, error) { return ciphertext, nil }
+
+func TestReadPacketDecodesEveryFraming(t *testing.T) {
+	xteaKey := [4]uint32{1, 2, 3, 4}
+	long := strings.Repeat("a long message compressed by sequenced framing ", 10)
+
+	for _, version := range []uint16{760, 860, 1100} {
+		server, viewer := net.Pipe()
+
+		serverClient := &client.Client{
+			Conn:        server,
+			XteaKey:     xteaKey,
+			Framing:     packet.FramingForProtocolVersion(version),
+			Compression: true,
+		}
+		go func() {
+			protocol.SendTextMessage(serverClient, "Welcome", protocol.MESSAGE_STATUS_CONSOLE_BLUE)
+			protocol.SendRawDataBatch(serverClient, [][]byte{{0xa1, 0x02}, {0xa2}})
+			protocol.SendTextMessage(serverClient, long, protocol.MESSAGE_STATUS_SMALL)
+		}()
+
+		c := newClient(viewer, xteaKey, packet.FramingForProtocolVersion(version))
+
+		var packets [][]byte
+		for i := 0; i < 4; i++ {
+			data, err := c.ReadPacket()
+			if err != nil {
+				t.Fatalf("protocol %d: expected packet %d, got %v", version, i, err)
+			}
+			packets = append(packets, data)
+		}
+
+		if message, ok := ParseTextMessage(packets[0]); !ok || message.Text != "Welcome" || message.Type != protocol.MESSAGE_STATUS_CONSOLE_BLUE {
+			t.Errorf("protocol %d: expected the welcome message, got %x", version, packets[0])
+		}
+		if string(packets[1]) != "\xa1\x02" || string(packets[2]) != "\xa2" {
+			t.Errorf("protocol %d: expected the raw packets, got %x and %x", version, packets[1], packets[2])
+		}
+		if message, ok := ParseTextMessage(packets[3]); !ok || message.Text != long {
+			t.Errorf("protocol %d: expected the long message, got %x", version, packets[3])
+		}
+
+		server.Close()
+		viewer.Close()
+	}
+}
+
+func TestParseLoginError(t *testing.T) {
+	server, viewer := net.Pipe()
+	defer viewer.Close()
+
+	go protocol.SendClientError(&client.Client{Conn: server}, "Cam not found.")
+
+	c := newClient(viewer, [4]uint32{}, packet.FRAMING_PLAIN)
+	data, err := c.ReadPacket()
+	if err != nil {
+		t.Fatalf("expected the login error, got %v", err)
+	}
+
+	if text, ok := ParseLoginError(data); !ok || text != "Cam not found." {
+		t.Errorf("expected the login error text, got %q", text)
+	}
+	if _, ok := ParseTextMessage(data); ok {
+		t.Error("expected a login error not to be a text message")
+	}
+}
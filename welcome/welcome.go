@@ -0,0 +1,178 @@
+package welcome
+
+import (
+	"fmt"
+	"go-opentibia-camplayerserver/protocol"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const DEFAULT_MESSAGE_TYPE = "console_blue"
+
+// Line is one configured message, Text is a text/template rendered with Info
+type Line struct {
+	Text string
+	Type string // message type name, see protocol.ParseMessageType
+}
+
+// DefaultLines are sent when no welcome lines are configured
+var DefaultLines = []Line{
+	{Text: "{{.Motd}}"},
+	{Text: "Welcome{{if .ServerName}} to {{.ServerName}}{{end}}! Playing {{.CamName}}" +
+		"{{if .Character}} recorded by {{.Character}}{{end}}{{if .Date}} on {{.Date}}{{end}}, {{.Duration}} long."},
+	{Text: "Commands: {{join .Commands \", \"}}"},
+}
+
+type Options struct {
+	ServerName           string
+	Motd                 string
+	Lines                []Line
+	Announcements        []Line
+	AnnouncementInterval time.Duration // zero disables the announcements
+}
+
+// Info holds the template variables
+type Info struct {
+	CamName    string
+	Character  string
+	Date       string
+	Duration   string
+	Commands   []string
+	ServerName string
+	Motd       string
+}
+
+// Message is a rendered line
+type Message struct {
+	Text string
+	Type protocol.MessageType
+}
+
+type compiledLine struct {
+	template    *template.Template
+	messageType protocol.MessageType
+}
+
+// Screen renders the welcome sequence sent when playback starts and the announcements repeated during it
+type Screen struct {
+	options       Options
+	lines         []compiledLine
+	announcements []compiledLine
+}
+
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+func NewScreen(options Options) (*Screen, error) {
+	if len(options.Lines) == 0 {
+		options.Lines = DefaultLines
+	}
+
+	lines, err := compileLines("welcome", options.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	announcements, err := compileLines("announcement", options.Announcements)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Screen{
+		options:       options,
+		lines:         lines,
+		announcements: announcements,
+	}, nil
+}
+
+func compileLines(name string, lines []Line) ([]compiledLine, error) {
+	compiled := make([]compiledLine, 0, len(lines))
+
+	for i, line := range lines {
+		typeName := line.Type
+		if typeName == "" {
+			typeName = DEFAULT_MESSAGE_TYPE
+		}
+
+		messageType, err := protocol.ParseMessageType(typeName)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", name, i+1, err)
+		}
+
+		tmpl, err := template.New(fmt.Sprintf("%s%d", name, i+1)).Funcs(templateFuncs).Parse(line.Text)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", name, i+1, err)
+		}
+
+		// unknown variables only show up when executing, better to find out at startup than on the first viewer
+		if err := tmpl.Execute(io.Discard, Info{}); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", name, i+1, err)
+		}
+
+		compiled = append(compiled, compiledLine{template: tmpl, messageType: messageType})
+	}
+
+	return compiled, nil
+}
+
+func (s *Screen) AnnouncementInterval() time.Duration {
+	if len(s.announcements) == 0 {
+		return 0
+	}
+	return s.options.AnnouncementInterval
+}
+
+// Welcome renders the welcome sequence, lines rendering to nothing are left out
+func (s *Screen) Welcome(info Info) ([]Message, error) {
+	messages := make([]Message, 0, len(s.lines))
+
+	for _, line := range s.lines {
+		message, err := s.render(line, info)
+		if err != nil {
+			return messages, err
+		}
+		if message.Text != "" {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+}
+
+// Announcement renders the announcement number n, cycling through the configured ones
+func (s *Screen) Announcement(n int, info Info) (Message, error) {
+	if len(s.announcements) == 0 {
+		return Message{}, nil
+	}
+	return s.render(s.announcements[n%len(s.announcements)], info)
+}
+
+func (s *Screen) render(line compiledLine, info Info) (Message, error) {
+	info.ServerName = s.options.ServerName
+	info.Motd = s.options.Motd
+
+	var text strings.Builder
+	if err := line.template.Execute(&text, info); err != nil {
+		return Message{}, fmt.Errorf("error rendering %s: %w", line.template.Name(), err)
+	}
+
+	return Message{Text: strings.TrimSpace(text.String()), Type: line.messageType}, nil
+}
+
+// FormatDuration formats seconds as h:mm:ss, or m:ss under an hour
+func FormatDuration(seconds float64) string {
+	if seconds <= 0 {
+		return "?"
+	}
+
+	total := int(seconds)
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total/60%60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
@@ -0,0 +1,148 @@
+package welcome
+
+import (
+	"go-opentibia-camplayerserver/protocol"
+	"testing"
+	"time"
+)
+
+var testInfo = Info{
+	CamName:   "Knight_2_25-10-2024-18-36-45",
+	Character: "Knight",
+	Date:      "2024-10-25 18:36",
+	Duration:  "12:05",
+	Commands:  []string{"/pause", "/stop"},
+}
+
+func TestDefaultWelcome(t *testing.T) {
+	screen, err := NewScreen(Options{ServerName: "Cams", Motd: "Have fun"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	messages, err := screen.Welcome(testInfo)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := []string{
+		"Have fun",
+		"Welcome to Cams! Playing Knight_2_25-10-2024-18-36-45 recorded by Knight on 2024-10-25 18:36, 12:05 long.",
+		"Commands: /pause, /stop",
+	}
+
+	if len(messages) != len(expected) {
+		t.Fatalf("expected %d messages, got %d: %v", len(expected), len(messages), messages)
+	}
+
+	for i, message := range messages {
+		if message.Text != expected[i] {
+			t.Errorf("expected line %d to be %q, got %q", i+1, expected[i], message.Text)
+		}
+		if message.Type != protocol.MESSAGE_STATUS_CONSOLE_BLUE {
+			t.Errorf("expected line %d in the console, got type %d", i+1, message.Type)
+		}
+	}
+}
+
+func TestWelcomeSkipsEmptyLines(t *testing.T) {
+	screen, _ := NewScreen(Options{})
+
+	messages, _ := screen.Welcome(Info{CamName: "unnamed", Duration: "?"})
+
+	// no motd, and nothing about the recording besides its name
+	if len(messages) != 2 || messages[0].Text != "Welcome! Playing unnamed, ? long." {
+		t.Errorf("unexpected welcome %v", messages)
+	}
+}
+
+func TestConfiguredLines(t *testing.T) {
+	screen, err := NewScreen(Options{
+		Lines: []Line{
+			{Text: "{{upper .Character}} at {{.ServerName}}", Type: "warning"},
+		},
+		ServerName: "Cams",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	messages, _ := screen.Welcome(testInfo)
+	if len(messages) != 1 || messages[0].Text != "KNIGHT at Cams" || messages[0].Type != protocol.MESSAGE_STATUS_WARNING {
+		t.Errorf("unexpected welcome %v", messages)
+	}
+}
+
+func TestInvalidLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []Line
+	}{
+		{"Unknown Type", []Line{{Text: "hi", Type: "loud"}}},
+		{"Broken Template", []Line{{Text: "{{.CamName"}}},
+		{"Unknown Variable", []Line{{Text: "{{.Player}}"}}},
+		{"Unknown Function", []Line{{Text: "{{shout .CamName}}"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewScreen(Options{Lines: tt.lines}); err == nil {
+				t.Error("expected an error, got none")
+			}
+			if _, err := NewScreen(Options{Announcements: tt.lines}); err == nil {
+				t.Error("expected an error for the announcement, got none")
+			}
+		})
+	}
+}
+
+func TestAnnouncementsCycle(t *testing.T) {
+	screen, err := NewScreen(Options{
+		Announcements: []Line{
+			{Text: "first {{.CamName}}"},
+			{Text: "second", Type: "status_small"},
+		},
+		AnnouncementInterval: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if screen.AnnouncementInterval() != time.Minute {
+		t.Errorf("expected a minute between announcements, got %s", screen.AnnouncementInterval())
+	}
+
+	expected := []string{"first cam", "second", "first cam"}
+	for i, text := range expected {
+		message, _ := screen.Announcement(i, Info{CamName: "cam"})
+		if message.Text != text {
+			t.Errorf("expected announcement %d to be %q, got %q", i, text, message.Text)
+		}
+	}
+}
+
+func TestAnnouncementsDisabledWithoutLines(t *testing.T) {
+	screen, _ := NewScreen(Options{AnnouncementInterval: time.Minute})
+
+	if screen.AnnouncementInterval() != 0 {
+		t.Errorf("expected announcements to be disabled, got %s", screen.AnnouncementInterval())
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		seconds  float64
+		expected string
+	}{
+		{0, "?"},
+		{5.9, "0:05"},
+		{725, "12:05"},
+		{3725, "1:02:05"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatDuration(tt.seconds); got != tt.expected {
+			t.Errorf("expected %q for %.1f, got %q", tt.expected, tt.seconds, got)
+		}
+	}
+}